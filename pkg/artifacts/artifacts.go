@@ -6,26 +6,76 @@ import (
 
 	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
 	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/nodeinfo"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/registry"
 	"github.com/aquasecurity/trivy-kubernetes/utils"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 // Artifact holds information for kubernetes scannable resources
 type Artifact struct {
-	Namespace   string
-	Kind        string
-	Labels      map[string]string
-	Name        string
-	Images      []string
+	Namespace string
+	Kind      string
+	Labels    map[string]string
+	Name      string
+	Images    []string
+	// Credentials holds a credential per image with one matching, the credentials of several secrets
+	// for a registry are joined with commas, see ImageCredentials for the ordered credentials
 	Credentials []docker.Auth
 	RawResource map[string]interface{}
+	// NodeInfo is the typed node-collector output of the NodeInfo artifacts, RawResource holds it as a map
+	NodeInfo *nodeinfo.NodeInfo
+	// NodeInfoValidationError is set on the NodeInfo artifacts whose output does not fit the typed model,
+	// NodeInfo is then nil and the output is only available in RawResource
+	NodeInfoValidationError string
+	// RunningImageIDs holds the image digests running for each container, keyed by container name
+	RunningImageIDs map[string][]string
+	// ImagesMetadata holds the registry metadata of the images, keyed by image reference
+	ImagesMetadata map[string]registry.ImageMetadata
+	// ImageCredentials holds the credentials matching each image in the order the kubelet tries them,
+	// with the secret or provider they come from
+	ImageCredentials map[string][]k8s.Credential
+	// CredentialRefs holds the references of the image pull secrets tried for each image, set instead of
+	// Credentials when credentials are resolved by the caller
+	CredentialRefs map[string][]k8s.CredentialRef
+	// NodeInfoError is set on the Node artifacts whose node-collector failed, their NodeInfo artifact is missing
+	NodeInfoError *NodeInfoError
+	// ClusterID is the UID of the kube-system namespace of the artifact cluster, set by fleet scans
+	ClusterID string
+}
+
+// NodeInfoError describes why the node-collector failed on a node
+type NodeInfoError struct {
+	Node string
+	// Reason is the reason of the failure, one of the jobs.NodeFailureReason
+	Reason string
+	// JobName is the name of the job or DaemonSet of the node-collector
+	JobName string
+	// PodPhase is the phase of the node-collector pod, empty when the pod was not found
+	PodPhase string
+	// Logs holds the last log lines of the node-collector container
+	Logs []string
+	// Message is the error of the failure
+	Message string
+}
+
+func (e *NodeInfoError) Error() string {
+	return fmt.Sprintf("node %s: %s: %s", e.Node, e.Reason, e.Message)
 }
 
 // FromResource is a factory method to create an Artifact from an unstructured.Unstructured
 func FromResource(resource unstructured.Unstructured, serverAuths map[string]docker.Auth) (*Artifact, error) {
+	return FromResourceWithCredentials(resource, k8s.CredentialsFromAuths(serverAuths))
+}
+
+// FromResourceWithCredentials creates an Artifact from an unstructured.Unstructured with the ordered
+// registry credentials of its pod spec in ImageCredentials, Credentials is filled as by FromResource
+func FromResourceWithCredentials(resource unstructured.Unstructured, registryCredentials k8s.RegistryCredentials) (*Artifact, error) {
 	nestedKeys := getContainerNestedKeys(resource.GetKind())
 	images := make([]string, 0)
 	credentials := make([]docker.Auth, 0)
+	joinedAuths := registryCredentials.JoinedAuths()
+	var imageCredentials map[string][]k8s.Credential
 	cTypes := []string{"containers", "ephemeralContainers", "initContainers"}
 
 	for _, t := range cTypes {
@@ -35,13 +85,19 @@ func FromResource(resource unstructured.Unstructured, serverAuths map[string]doc
 		}
 		images = append(images, cTypeImages...)
 		for _, im := range cTypeImages {
-			as, err := k8s.MapContainerNamesToDockerAuths(im, serverAuths)
+			creds, err := k8s.MatchCredentials(im, registryCredentials)
 			if err != nil {
 				slog.Warn(fmt.Sprintf("unable to parse image reference, skipping: %s", im))
 				continue
 			}
-			if as != nil {
-				credentials = append(credentials, *as)
+			if auth, err := k8s.MapContainerNamesToDockerAuths(im, joinedAuths); err == nil && auth != nil {
+				credentials = append(credentials, *auth)
+			}
+			if len(creds) > 0 {
+				if imageCredentials == nil {
+					imageCredentials = make(map[string][]k8s.Credential)
+				}
+				imageCredentials[im] = creds
 			}
 		}
 	}
@@ -63,16 +119,39 @@ func FromResource(resource unstructured.Unstructured, serverAuths map[string]doc
 	}
 
 	return &Artifact{
-		Namespace:   resource.GetNamespace(),
-		Kind:        resource.GetKind(),
-		Labels:      labels,
-		Name:        name,
-		Images:      images,
-		Credentials: credentials,
-		RawResource: resource.Object,
+		Namespace:        resource.GetNamespace(),
+		Kind:             resource.GetKind(),
+		Labels:           labels,
+		Name:             name,
+		Images:           images,
+		Credentials:      credentials,
+		RawResource:      resource.Object,
+		ImageCredentials: imageCredentials,
 	}, nil
 }
 
+// FromResourceWithCredentialRefs creates an Artifact from an unstructured.Unstructured holding only
+// the references of the image pull secrets of its pod spec for every image, see k8s.CredentialResolver
+func FromResourceWithCredentialRefs(resource unstructured.Unstructured, refs []k8s.CredentialRef) (*Artifact, error) {
+	artifact, err := FromResourceWithCredentials(resource, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return artifact, nil
+	}
+	artifact.CredentialRefs = make(map[string][]k8s.CredentialRef, len(artifact.Images))
+	for _, im := range artifact.Images {
+		imageRefs := make([]k8s.CredentialRef, 0, len(refs))
+		for _, ref := range refs {
+			ref.Image = im
+			imageRefs = append(imageRefs, ref)
+		}
+		artifact.CredentialRefs[im] = imageRefs
+	}
+	return artifact, nil
+}
+
 func extractImages(resource unstructured.Unstructured, keys []string) ([]string, error) {
 	containers, found, err := unstructured.NestedSlice(resource.Object, keys...)
 	if err != nil {
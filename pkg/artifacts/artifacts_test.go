@@ -6,8 +6,10 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
 	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/kubectl/pkg/scheme"
@@ -47,6 +49,26 @@ func TestFromResource(t *testing.T) {
 	}
 }
 
+func TestFromResourceWithCredentials(t *testing.T) {
+	podSpecCred := k8s.Credential{Auth: docker.Auth{Username: "pod-spec", Password: "pass,word"}, CredentialRef: k8s.CredentialRef{Source: k8s.CredentialSourcePodSpec, Namespace: "default", SecretName: "regcred"}}
+	saCred := k8s.Credential{Auth: docker.Auth{Username: "service-account", Password: "pass"}, CredentialRef: k8s.CredentialRef{Source: k8s.CredentialSourceServiceAccount, Namespace: "default", SecretName: "hub", ServiceAccount: "default"}}
+	creds := k8s.RegistryCredentials{
+		"index.docker.io": {podSpecCred, saCred},
+		"quay.io":         {{Auth: docker.Auth{Username: "quay"}}},
+	}
+
+	result, err := FromResourceWithCredentials(resourceFromFile("deploy-with-sidecar.yaml"), creds)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"memcached", "nginx"}, result.Images)
+	// a credential per image, service account secrets first as before the ordered credentials
+	joined := docker.Auth{Username: "service-account,pod-spec", Password: "pass,pass,word"}
+	assert.Equal(t, []docker.Auth{joined, joined}, result.Credentials)
+	assert.Equal(t, map[string][]k8s.Credential{
+		"memcached": {podSpecCred, saCred},
+		"nginx":     {podSpecCred, saCred},
+	}, result.ImageCredentials)
+}
+
 func resourceFromFile(fixture string) unstructured.Unstructured {
 	fixture = filepath.Join("testdata", "fixtures", fixture)
 
@@ -0,0 +1,214 @@
+// Package fleet lists the artifacts of several clusters of a kubeconfig concurrently
+package fleet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/artifacts"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/trivyk8s"
+)
+
+const (
+	defaultParallelism = 10
+	defaultTimeout     = 10 * time.Minute
+)
+
+// Identity identifies a cluster of the fleet
+type Identity struct {
+	// ID is the UID of the kube-system namespace, it is stable across kubeconfig and context renames
+	ID string
+	// Context is the kubeconfig context of the cluster
+	Context string
+}
+
+// Result holds the artifacts of a cluster, or the error which stopped its scan
+type Result struct {
+	Identity Identity
+	// IdentityErr is set when the kube-system namespace cannot be read, the cluster is still scanned
+	// and identified by its API server URL, or else its context
+	IdentityErr error
+	Artifacts   []*artifacts.Artifact
+	Err         error
+}
+
+// ClusterFunc instansiate the cluster of a kubeconfig context, ctx is bounded by the fleet timeout
+type ClusterFunc func(ctx context.Context, kubeContext string) (k8s.Cluster, error)
+
+// Fleet scans the clusters of kubeconfig contexts
+type Fleet struct {
+	contexts       []string
+	kubeConfig     string
+	parallelism    int
+	timeout        time.Duration
+	clusterOptions []k8s.ClusterOption
+	k8sOptions     []trivyk8s.K8sOption
+	clusterFunc    ClusterFunc
+}
+
+type Option func(*Fleet)
+
+// WithKubeConfig set the kubeconfig of the contexts, KUBECONFIG or ~/.kube/config is used otherwise
+func WithKubeConfig(kubeConfig string) Option {
+	return func(f *Fleet) {
+		f.kubeConfig = kubeConfig
+	}
+}
+
+// WithParallelism set the number of clusters scanned concurrently, 10 by default
+func WithParallelism(parallelism int) Option {
+	return func(f *Fleet) {
+		f.parallelism = parallelism
+	}
+}
+
+// WithTimeout set the timeout of the scan of a single cluster, 10 minutes by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(f *Fleet) {
+		f.timeout = timeout
+	}
+}
+
+// WithClusterOptions set the options of every cluster, e.g. WithQPS
+func WithClusterOptions(opts ...k8s.ClusterOption) Option {
+	return func(f *Fleet) {
+		f.clusterOptions = append(f.clusterOptions, opts...)
+	}
+}
+
+// WithK8sOptions set the options of the scans of every cluster, e.g. WithExcludeNamespaces
+func WithK8sOptions(opts ...trivyk8s.K8sOption) Option {
+	return func(f *Fleet) {
+		f.k8sOptions = append(f.k8sOptions, opts...)
+	}
+}
+
+// WithClusterFunc set the function instansiating the cluster of a context, the clusters are
+// built with k8s.GetCluster and the cluster options otherwise
+func WithClusterFunc(clusterFunc ClusterFunc) Option {
+	return func(f *Fleet) {
+		f.clusterFunc = clusterFunc
+	}
+}
+
+// New instansiate a fleet of the kubeconfig contexts, all the contexts of the kubeconfig when empty
+func New(contexts []string, opts ...Option) (*Fleet, error) {
+	f := &Fleet{
+		parallelism: defaultParallelism,
+		timeout:     defaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.parallelism <= 0 {
+		f.parallelism = 1
+	}
+	if f.clusterFunc == nil {
+		f.clusterFunc = f.getCluster
+	}
+	if len(contexts) == 0 {
+		var err error
+		if contexts, err = k8s.ListContexts(f.kubeConfig); err != nil {
+			return nil, err
+		}
+	}
+	f.contexts = contexts
+	return f, nil
+}
+
+// Contexts returns the kubeconfig contexts of the fleet
+func (f *Fleet) Contexts() []string {
+	return f.contexts
+}
+
+// ListArtifacts returns the artifacts of all the namespaces of every cluster, see trivyk8s.ListArtifacts.
+// Results are in the order of the contexts, the failure of a cluster is reported in its result.
+func (f *Fleet) ListArtifacts(ctx context.Context) []Result {
+	return f.run(ctx, func(ctx context.Context, client trivyk8s.TrivyK8S) ([]*artifacts.Artifact, error) {
+		return client.AllNamespaces().ListArtifacts(ctx)
+	})
+}
+
+// ListClusterBomInfo returns the BOM artifacts of every cluster, see trivyk8s.ListClusterBomInfo
+func (f *Fleet) ListClusterBomInfo(ctx context.Context) []Result {
+	return f.run(ctx, func(ctx context.Context, client trivyk8s.TrivyK8S) ([]*artifacts.Artifact, error) {
+		return client.ListClusterBomInfo(ctx)
+	})
+}
+
+type listFunc func(ctx context.Context, client trivyk8s.TrivyK8S) ([]*artifacts.Artifact, error)
+
+func (f *Fleet) run(ctx context.Context, list listFunc) []Result {
+	results := make([]Result, len(f.contexts))
+	sem := make(chan struct{}, f.parallelism)
+	var wg sync.WaitGroup
+	for i, kubeContext := range f.contexts {
+		wg.Add(1)
+		go func(i int, kubeContext string) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i] = Result{Identity: Identity{Context: kubeContext}, Err: ctx.Err()}
+				return
+			}
+			defer func() { <-sem }()
+			results[i] = f.scan(ctx, kubeContext, list)
+		}(i, kubeContext)
+	}
+	wg.Wait()
+	return results
+}
+
+func (f *Fleet) scan(ctx context.Context, kubeContext string, list listFunc) Result {
+	result := Result{Identity: Identity{Context: kubeContext}}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	cluster, err := f.clusterFunc(ctx, kubeContext)
+	if err != nil {
+		result.Err = fmt.Errorf("cluster %s: %w", kubeContext, err)
+		return result
+	}
+	if result.Identity.ID, err = k8s.ClusterUID(ctx, cluster.GetK8sClientSet()); err != nil {
+		result.IdentityErr = fmt.Errorf("cluster %s: %w", kubeContext, err)
+		result.Identity.ID = fallbackID(cluster, kubeContext)
+	}
+	arts, err := list(ctx, trivyk8s.New(cluster, f.k8sOptions...))
+	if err != nil {
+		result.Err = fmt.Errorf("cluster %s: %w", kubeContext, err)
+		return result
+	}
+	for _, artifact := range arts {
+		artifact.ClusterID = result.Identity.ID
+	}
+	result.Artifacts = arts
+	return result
+}
+
+// fallbackID identifies a cluster whose kube-system namespace cannot be read by its API server URL,
+// or else by its context
+func fallbackID(cluster k8s.Cluster, kubeContext string) string {
+	restClient, ok := cluster.GetK8sClientSet().Discovery().RESTClient().(*rest.RESTClient)
+	if !ok || restClient == nil {
+		return kubeContext
+	}
+	server := restClient.Get().URL()
+	server.Path = strings.TrimSuffix(server.Path, "/")
+	return server.String()
+}
+
+// getCluster instansiate the cluster of the context, requests are bounded by the fleet timeout
+func (f *Fleet) getCluster(_ context.Context, kubeContext string) (k8s.Cluster, error) {
+	opts := []k8s.ClusterOption{k8s.WithContext(kubeContext), k8s.WithRequestTimeout(f.timeout)}
+	if f.kubeConfig != "" {
+		opts = append(opts, k8s.WithKubeConfig(f.kubeConfig))
+	}
+	return k8s.GetCluster(append(opts, f.clusterOptions...)...)
+}
@@ -0,0 +1,136 @@
+package fleet
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/fake"
+)
+
+func fakeClusters() ClusterFunc {
+	objects := func(uid, pod string) []runtime.Object {
+		return []runtime.Object{
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: types.UID(uid)}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: pod},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:1.25"}}},
+			},
+		}
+	}
+	clusters := map[string][]runtime.Object{
+		"prod": objects("2f0c5d3e-prod", "api"),
+		"dev":  objects("8a1b7c4d-dev", "web"),
+		// no kube-system namespace, e.g. not readable by the scanning identity
+		"restricted": {&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}},
+	}
+	return func(_ context.Context, kubeContext string) (k8s.Cluster, error) {
+		objs, ok := clusters[kubeContext]
+		if !ok {
+			return nil, errors.New("connection refused")
+		}
+		return fake.NewCluster(objs)
+	}
+}
+
+func TestFleetListArtifacts(t *testing.T) {
+	f, err := New([]string{"prod", "unreachable", "dev", "restricted"}, WithClusterFunc(fakeClusters()), WithParallelism(2))
+	require.NoError(t, err)
+
+	results := f.ListArtifacts(context.Background())
+	require.Len(t, results, 4)
+
+	wantPods := map[string][]string{"prod": {"api"}, "dev": {"web"}, "restricted": nil}
+	// the restricted cluster is identified by its context, the fake cluster has no API server URL
+	wantIDs := map[string]string{"prod": "2f0c5d3e-prod", "dev": "8a1b7c4d-dev", "restricted": "restricted"}
+	for _, result := range results {
+		pods, ok := wantPods[result.Identity.Context]
+		if !ok {
+			assert.Error(t, result.Err, result.Identity.Context)
+			assert.Empty(t, result.Artifacts)
+			continue
+		}
+		require.NoError(t, result.Err)
+		assert.Equal(t, wantIDs[result.Identity.Context], result.Identity.ID)
+		var gotPods []string
+		for _, artifact := range result.Artifacts {
+			assert.Equal(t, result.Identity.ID, artifact.ClusterID)
+			if artifact.Kind == "Pod" {
+				gotPods = append(gotPods, artifact.Name)
+			}
+		}
+		assert.Equal(t, pods, gotPods)
+	}
+	assert.Equal(t, []string{"prod", "unreachable", "dev", "restricted"}, []string{
+		results[0].Identity.Context, results[1].Identity.Context, results[2].Identity.Context, results[3].Identity.Context,
+	})
+	assert.ErrorContains(t, results[1].Err, "cluster unreachable: connection refused")
+	assert.NoError(t, results[0].IdentityErr)
+	assert.ErrorContains(t, results[3].IdentityErr, "getting kube-system namespace")
+}
+
+// serverCluster serves the clientset of an API server
+type serverCluster struct {
+	k8s.Cluster
+	clientset kubernetes.Interface
+}
+
+func (c serverCluster) GetK8sClientSet() kubernetes.Interface {
+	return c.clientset
+}
+
+func TestFallbackID(t *testing.T) {
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: "https://rancher.example.com/k8s/clusters/c-m-4x2kd"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://rancher.example.com/k8s/clusters/c-m-4x2kd", fallbackID(serverCluster{clientset: clientset}, "prod"))
+
+	cluster, err := fake.NewCluster(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", fallbackID(cluster, "prod"))
+}
+
+func TestFleetListClusterBomInfo(t *testing.T) {
+	f, err := New([]string{"dev"}, WithClusterFunc(fakeClusters()))
+	require.NoError(t, err)
+
+	results := f.ListClusterBomInfo(context.Background())
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, Identity{ID: "8a1b7c4d-dev", Context: "dev"}, results[0].Identity)
+	require.NotEmpty(t, results[0].Artifacts)
+	for _, artifact := range results[0].Artifacts {
+		assert.Equal(t, "8a1b7c4d-dev", artifact.ClusterID)
+	}
+}
+
+func TestNewAllContexts(t *testing.T) {
+	config := clientcmdapi.NewConfig()
+	for _, name := range []string{"prod", "dev", "staging"} {
+		config.Clusters[name] = &clientcmdapi.Cluster{Server: "https://" + name + ".example.com"}
+		config.Contexts[name] = &clientcmdapi.Context{Cluster: name}
+	}
+	kubeConfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, clientcmd.WriteToFile(*config, kubeConfig))
+
+	f, err := New(nil, WithKubeConfig(kubeConfig))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev", "prod", "staging"}, f.Contexts())
+
+	f, err = New([]string{"prod"}, WithKubeConfig(kubeConfig))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod"}, f.Contexts())
+}
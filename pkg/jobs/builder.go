@@ -11,6 +11,10 @@ import (
 
 type JobOption func(*JobBuilder)
 
+// defaultJobTTLAfterFinished is the time finished jobs are kept before the TTL controller deletes them,
+// it covers the jobs of a collector that crashed before deleting them
+const defaultJobTTLAfterFinished = 10 * time.Minute
+
 func WithTemplate(template string) JobOption {
 	return func(j *JobBuilder) {
 		j.template = template
@@ -157,6 +161,22 @@ func WithK8sNodeCommands(nodeCommands string) JobOption {
 	}
 }
 
+// WithJobTTLAfterFinished set the ttlSecondsAfterFinished of the job, 10 minutes when zero and unset when negative
+func WithJobTTLAfterFinished(ttl time.Duration) JobOption {
+	return func(j *JobBuilder) {
+		j.ttlAfterFinished = ttl
+	}
+}
+
+// withJobResultTransport set how the node-collector sends its output, configMap is the name of the
+// ConfigMap and of the service account of the ConfigMap transport
+func withJobResultTransport(transport resultTransport, configMap string) JobOption {
+	return func(jc *JobBuilder) {
+		jc.resultTransport = transport
+		jc.resultConfigMap = configMap
+	}
+}
+
 func GetJob(opts ...JobOption) (*batchv1.Job, error) {
 	jb := &JobBuilder{}
 	for _, opt := range opts {
@@ -191,6 +211,9 @@ type JobBuilder struct {
 	kubeletConfigMapping string
 	nodeConfigData       string
 	nodeCommands         string
+	resultTransport      resultTransport
+	resultConfigMap      string
+	ttlAfterFinished     time.Duration
 }
 
 func (b *JobBuilder) build() (*batchv1.Job, error) {
@@ -260,6 +283,13 @@ func (b *JobBuilder) build() (*batchv1.Job, error) {
 	if b.timeout > 0 {
 		job.Spec.ActiveDeadlineSeconds = ptr.To[int64](int64(b.timeout.Seconds()))
 	}
+	ttl := b.ttlAfterFinished
+	if ttl == 0 {
+		ttl = defaultJobTTLAfterFinished
+	}
+	if ttl > 0 {
+		job.Spec.TTLSecondsAfterFinished = ptr.To[int32](int32(ttl.Seconds()))
+	}
 	if b.securityContext != nil {
 		job.Spec.Template.Spec.Containers[0].SecurityContext = b.securityContext
 	}
@@ -275,5 +305,6 @@ func (b *JobBuilder) build() (*batchv1.Job, error) {
 	if len(b.volumeMounts) > 0 {
 		job.Spec.Template.Spec.Containers[0].VolumeMounts = b.volumeMounts
 	}
+	applyResultTransport(&job, b.resultTransport, b.resultConfigMap)
 	return &job, nil
 }
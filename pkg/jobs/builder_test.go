@@ -3,6 +3,7 @@ package jobs
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	batchv1 "k8s.io/api/batch/v1"
@@ -27,9 +28,10 @@ func TestLoadBuilder(t *testing.T) {
 				},
 				ObjectMeta: v1.ObjectMeta{Name: "node-collector"},
 				Spec: batchv1.JobSpec{
-					ActiveDeadlineSeconds: ptr.To[int64](300),
-					BackoffLimit:          ptr.To[int32](0),
-					Completions:           ptr.To[int32](1),
+					ActiveDeadlineSeconds:   ptr.To[int64](300),
+					BackoffLimit:            ptr.To[int32](0),
+					Completions:             ptr.To[int32](1),
+					TTLSecondsAfterFinished: ptr.To[int32](600),
 					Template: corev1.PodTemplateSpec{
 						ObjectMeta: v1.ObjectMeta{Labels: map[string]string{"app": "node-collector"}},
 						Spec: corev1.PodSpec{
@@ -163,3 +165,22 @@ func TestLoadBuilder(t *testing.T) {
 		})
 	}
 }
+
+func TestJobTTLAfterFinished(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		want *int32
+	}{
+		{name: "default", want: ptr.To[int32](600)},
+		{name: "custom", ttl: time.Hour, want: ptr.To[int32](3600)},
+		{name: "disabled", ttl: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := GetJob(WithTemplate(NodeCollectorName), WithJobTTLAfterFinished(tt.ttl))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, job.Spec.TTLSecondsAfterFinished)
+		})
+	}
+}
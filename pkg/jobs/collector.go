@@ -3,10 +3,15 @@ package jobs
 import (
 	"context"
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
+	"maps"
 	"strings"
+	"sync"
 	"time"
 
 	"os"
@@ -38,8 +43,12 @@ const (
 )
 
 type Collector interface {
-	ApplyAndCollect(ctx context.Context, nodeName string) (string, error)
-	Apply(ctx context.Context, nodeName string) (*batchv1.Job, error)
+	// ApplyAndCollect runs the job on the node and returns its output, the options apply to this node only
+	ApplyAndCollect(ctx context.Context, nodeName string, opts ...CollectorOption) (string, error)
+	// Apply creates the job of the node, the options apply to this node only
+	Apply(ctx context.Context, nodeName string, opts ...CollectorOption) (*batchv1.Job, error)
+	// Deprecated: AppendLabels mutates the collector shared by the nodes, pass the options of
+	// a node to ApplyAndCollect or Apply instead
 	AppendLabels(opts ...CollectorOption)
 	Cleanup(ctx context.Context)
 }
@@ -72,6 +81,49 @@ type jobCollector struct {
 	specCommandIds       []string
 	commandsFileSystem   embed.FS
 	nodeConfigFileSystem embed.FS
+	specLoader           SpecLoader
+	pauseImageRef        string
+	resultTransport      resultTransport
+	ttlAfterFinished     time.Duration
+	// args are shared by the per node copies of the collector
+	args *lazyCollectorArgs
+	// createdNamespaces are the namespaces created by the collector and its per node copies
+	createdNamespaces *namespaceSet
+}
+
+// namespaceSet is a set of namespace names safe for concurrent use
+type namespaceSet struct {
+	mu    sync.Mutex
+	names map[string]struct{}
+}
+
+func (s *namespaceSet) add(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.names == nil {
+		s.names = make(map[string]struct{})
+	}
+	s.names[name] = struct{}{}
+}
+
+func (s *namespaceSet) has(name string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.names[name]
+	return ok
+}
+
+// lazyCollectorArgs computes the collector args once, commands and platform are the same for every node
+type lazyCollectorArgs struct {
+	once sync.Once
+	ca   CollectorArgs
+	err  error
 }
 
 type CollectorOption func(*jobCollector)
@@ -141,6 +193,21 @@ func WithImageRef(imageRef string) CollectorOption {
 	}
 }
 
+// WithTTLAfterFinished set the ttlSecondsAfterFinished of the jobs, 10 minutes when zero and unset when negative
+func WithTTLAfterFinished(ttl time.Duration) CollectorOption {
+	return func(jc *jobCollector) {
+		jc.ttlAfterFinished = ttl
+	}
+}
+
+// WithPauseImageRef set the image of the container keeping the DaemonSet pods running once the
+// node-collector init container is done
+func WithPauseImageRef(imageRef string) CollectorOption {
+	return func(jc *jobCollector) {
+		jc.pauseImageRef = imageRef
+	}
+}
+
 func WithServiceAccount(sa string) CollectorOption {
 	return func(jc *jobCollector) {
 		jc.serviceAccount = sa
@@ -212,6 +279,14 @@ func WithSpecCommands(specCommandIds []string) CollectorOption {
 	}
 }
 
+// WithComplianceSpecLoader select the commands of the compliance spec resolved from the platform mapping,
+// commands are selected by platform when the spec is unknown to the loader
+func WithComplianceSpecLoader(loader SpecLoader) CollectorOption {
+	return func(jc *jobCollector) {
+		jc.specLoader = loader
+	}
+}
+
 func WithEmbeddedCommandFileSystem(commandsFileSystem embed.FS) CollectorOption {
 	return func(c *jobCollector) {
 		c.commandsFileSystem = commandsFileSystem
@@ -229,9 +304,11 @@ func NewCollector(
 	opts ...CollectorOption,
 ) Collector {
 	jc := &jobCollector{
-		cluster:    cluster,
-		timeout:    0,
-		logsReader: NewLogsReader(cluster.GetK8sClientSet()),
+		cluster:           cluster,
+		timeout:           0,
+		logsReader:        NewLogsReader(cluster.GetK8sClientSet()),
+		args:              &lazyCollectorArgs{},
+		createdNamespaces: &namespaceSet{},
 	}
 	for _, opt := range opts {
 		opt(jc)
@@ -240,12 +317,39 @@ func NewCollector(
 }
 
 // AppendLabels Append labels to job
+//
+// Deprecated: it mutates the collector shared by the nodes, pass the options of a node to ApplyAndCollect or Apply instead
 func (jb *jobCollector) AppendLabels(opts ...CollectorOption) {
 	for _, opt := range opts {
 		opt(jb)
 	}
 }
 
+// withOptions returns a copy of the collector with the options applied, the collector is left unchanged
+func (jb *jobCollector) withOptions(opts ...CollectorOption) *jobCollector {
+	if len(opts) == 0 {
+		return jb
+	}
+	c := *jb
+	c.labels = maps.Clone(jb.labels)
+	c.annotation = maps.Clone(jb.annotation)
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// collectorArgs returns the collector args computed for the first node
+func (jb *jobCollector) collectorArgs() (CollectorArgs, error) {
+	if jb.args == nil {
+		return jb.GetCollectorArgs()
+	}
+	jb.args.once.Do(func() {
+		jb.args.ca, jb.args.err = jb.GetCollectorArgs()
+	})
+	return jb.args.ca, jb.args.err
+}
+
 type ObjectRef struct {
 	Kind      string
 	Name      string
@@ -254,23 +358,26 @@ type ObjectRef struct {
 
 // ApplyAndCollect deploy k8s job by template to  specific node  and namespace, it read pod logs
 // cleaning up job and returning it output (for cli use-case)
-func (jb *jobCollector) ApplyAndCollect(ctx context.Context, nodeName string) (string, error) {
+func (jb *jobCollector) ApplyAndCollect(ctx context.Context, nodeName string, opts ...CollectorOption) (string, error) {
+	jb = jb.withOptions(opts...)
 
-	_, err := jb.getTrivyNamespace(ctx)
-	if err != nil {
-		if k8sapierror.IsNotFound(err) {
-			trivyNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: jb.namespace}}
-			_, err = jb.cluster.GetK8sClientSet().CoreV1().Namespaces().Create(ctx, trivyNamespace, metav1.CreateOptions{})
-			if err != nil {
-				return "", err
-			}
-		}
+	if err := checkResultTransport(jb.resultTransport, jb.imageRef); err != nil {
+		return "", err
+	}
+	if err := jb.createTrivyNamespace(ctx); err != nil {
+		return "", err
 	}
 
-	ca, err := jb.GetCollectorArgs()
+	ca, err := jb.collectorArgs()
 	if err != nil {
 		return "", err
 	}
+	jobName := fmt.Sprintf("%s-%s", jb.templateName, ComputeHash(
+		ObjectRef{
+			Kind:      "Node-Info",
+			Name:      nodeName,
+			Namespace: jb.namespace,
+		}))
 	JobOptions := []JobOption{
 		WithTemplate(jb.templateName),
 		WithNamespace(jb.namespace),
@@ -293,50 +400,96 @@ func (jb *jobCollector) ApplyAndCollect(ctx context.Context, nodeName string) (s
 		WithPriorityClassName(jb.priorityClassName),
 		WithResourceRequirements(jb.resourceRequirements),
 		WithUseNodeSelectorParam(true),
-		WithJobName(fmt.Sprintf("%s-%s", jb.templateName, ComputeHash(
-			ObjectRef{
-				Kind:      "Node-Info",
-				Name:      nodeName,
-				Namespace: jb.namespace,
-			}))),
+		WithJobName(jobName),
+		withJobResultTransport(jb.resultTransport, resultConfigMapName(jobName)),
+		WithJobTTLAfterFinished(jb.ttlAfterFinished),
 	}
+	clientset := jb.cluster.GetK8sClientSet()
 	nc, err := jb.loadNodeConfig(ctx, nodeName)
 	if err != nil {
-		return "", fmt.Errorf("loading node config for %q: %w", nodeName, err)
+		return "", &NodeCollectorError{Node: nodeName, Reason: NodeFailureNodeConfig, Err: fmt.Errorf("loading node config for %q: %w", nodeName, err)}
 	}
 	JobOptions = append(JobOptions, WithKubeletConfig(nc))
 	job, err := GetJob(JobOptions...)
 	if err != nil {
-		return "", fmt.Errorf("running node-collector job: %w", err)
+		return "", &NodeCollectorError{Node: nodeName, Reason: NodeFailureJobFailed, Err: fmt.Errorf("running node-collector job: %w", err)}
 	}
 
-	err = New(WithTimeout(jb.timeout)).Run(ctx, NewRunnableJob(jb.cluster.GetK8sClientSet(), job))
-	if err != nil {
-		return "", fmt.Errorf("running node-collector job: %w", err)
+	if jb.resultTransport == resultTransportConfigMap {
+		cleanup, err := jb.createResultConfigMap(ctx, job)
+		if err != nil {
+			return "", &NodeCollectorError{Node: nodeName, Reason: NodeFailureJobFailed, JobName: job.Name, Err: fmt.Errorf("creating result configmap: %w", err)}
+		}
+		defer cleanup()
 	}
+
+	err = New(WithTimeout(jb.timeout)).Run(ctx, NewRunnableJob(clientset, job))
 	defer func() {
+		// failed jobs are deleted too, once their pod was inspected
 		background := metav1.DeletePropagationBackground
-		_ = jb.cluster.GetK8sClientSet().BatchV1().Jobs(job.Namespace).Delete(ctx, job.Name, metav1.DeleteOptions{
+		_ = clientset.BatchV1().Jobs(job.Namespace).Delete(context.WithoutCancel(ctx), job.Name, metav1.DeleteOptions{
 			PropagationPolicy: &background,
 		})
 	}()
+	if err != nil {
+		return "", newNodeCollectorError(ctx, clientset, nodeName, runFailureReason(err), job, fmt.Errorf("running node-collector job: %w", err))
+	}
 
+	output, enveloped, err := jb.readOutput(ctx, job)
+	if err != nil {
+		return "", newNodeCollectorError(ctx, clientset, nodeName, NodeFailureLogs, job, err)
+	}
+	if enveloped {
+		output, err = decodeResultEnvelope(output)
+		if err != nil {
+			return "", newNodeCollectorError(ctx, clientset, nodeName, NodeFailureInvalidOutput, job, err)
+		}
+	}
+	if !json.Valid(output) {
+		return "", &NodeCollectorError{
+			Node:    nodeName,
+			Reason:  NodeFailureInvalidOutput,
+			JobName: job.Name,
+			Logs:    lastLines(string(output), nodeFailureLogLines),
+			Err:     errors.New("node-collector output is not valid JSON"),
+		}
+	}
+	return string(output), nil
+}
+
+// readOutput returns the output of the completed job, enveloped unless it is read from the logs. A
+// termination message truncated by the kubelet falls back to the logs.
+func (jb *jobCollector) readOutput(ctx context.Context, job *batchv1.Job) ([]byte, bool, error) {
+	if jb.resultTransport.enveloped() {
+		result, err := jb.readResult(ctx, job)
+		if err != nil {
+			return nil, false, err
+		}
+		if jb.resultTransport != resultTransportTerminationMessage || !truncatedTerminationMessage(string(result)) {
+			return result, true, nil
+		}
+	}
+	output, err := jb.readJobLogs(ctx, job)
+	return output, false, err
+}
+
+func (jb *jobCollector) readJobLogs(ctx context.Context, job *batchv1.Job) ([]byte, error) {
 	logsStream, err := jb.logsReader.GetLogsByJobAndContainerName(ctx, job, NodeCollectorName)
 	if err != nil {
-		return "", fmt.Errorf("getting logs: %w", err)
+		return nil, fmt.Errorf("getting logs: %w", err)
 	}
 	defer func() {
 		_ = logsStream.Close()
 	}()
 	output, err := io.ReadAll(logsStream)
 	if err != nil {
-		return "", fmt.Errorf("reading logs: %w", err)
+		return nil, fmt.Errorf("reading logs: %w", err)
 	}
-	return string(output), nil
+	return output, nil
 }
 
 func (jb jobCollector) loadNodeConfig(ctx context.Context, nodeName string) (string, error) {
-	data, err := jb.cluster.GetK8sClientSet().RESTClient().Get().AbsPath(fmt.Sprintf("/api/v1/nodes/%s/proxy/configz", nodeName)).DoRaw(ctx)
+	data, err := jb.cluster.GetNodeConfig(ctx, nodeName)
 	if err != nil {
 		return "", err
 	}
@@ -471,6 +624,13 @@ func AddChecksByCheckId(addChecks map[string][]any, commandMap map[string]any) {
 	}
 }
 
+func (jb *jobCollector) loadCommands(addChecks AddChecks) (map[string][]any, map[string]string) {
+	if len(jb.commandPaths) > 0 {
+		return loadCommands(jb.commandPaths, addChecks)
+	}
+	return getEmbeddedCommands(jb.commandsFileSystem, jb.nodeConfigFileSystem, addChecks)
+}
+
 func filterCommandBySpecId(commands map[string][]any, specCommandIds []string) NodeCommands {
 	if len(specCommandIds) == 0 {
 		return NodeCommands{}
@@ -494,8 +654,9 @@ func filterCommandByPlatform(commands map[string][]any, platform string) NodeCom
 }
 
 // Apply deploy k8s job by template to specific node and namespace (for operator use case)
-func (jb *jobCollector) Apply(ctx context.Context, nodeName string) (*batchv1.Job, error) {
-	ca, err := jb.GetCollectorArgs()
+func (jb *jobCollector) Apply(ctx context.Context, nodeName string, opts ...CollectorOption) (*batchv1.Job, error) {
+	jb = jb.withOptions(opts...)
+	ca, err := jb.collectorArgs()
 	if err != nil {
 		return nil, err
 	}
@@ -523,7 +684,8 @@ func (jb *jobCollector) Apply(ctx context.Context, nodeName string) (*batchv1.Jo
 		WithReplaceResourceReq(true),
 		WithJobName(jb.name),
 		WithUseNodeSelectorParam(jb.useNodeSelector),
-		WithResourceRequirements(jb.resourceRequirements)}
+		WithResourceRequirements(jb.resourceRequirements),
+		WithJobTTLAfterFinished(jb.ttlAfterFinished)}
 
 	job, err := GetJob(jobOptions...)
 	if err != nil {
@@ -544,12 +706,43 @@ func (jb *jobCollector) deleteTrivyNamespace(ctx context.Context) {
 	})
 }
 
+// createTrivyNamespace creates the namespace of the collector when it does not exist, it is deleted by Cleanup
+// and labeled so that GarbageCollect deletes it when the scan could not clean up
+func (jb *jobCollector) createTrivyNamespace(ctx context.Context) error {
+	_, err := jb.getTrivyNamespace(ctx)
+	if err == nil || !k8sapierror.IsNotFound(err) {
+		return nil
+	}
+	collectorName := jb.labels[TrivyCollectorName]
+	if collectorName == "" {
+		collectorName = jb.templateName
+	}
+	trivyNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: jb.namespace,
+		Labels: map[string]string{
+			TrivyAutoCreated:   "true",
+			TrivyCollectorName: collectorName,
+		},
+	}}
+	_, err = jb.cluster.GetK8sClientSet().CoreV1().Namespaces().Create(ctx, trivyNamespace, metav1.CreateOptions{})
+	// nodes collected concurrently race to create the namespace
+	if err != nil && !k8sapierror.IsAlreadyExists(err) {
+		return err
+	}
+	if err == nil {
+		jb.createdNamespaces.add(jb.namespace)
+	}
+	return nil
+}
+
 func (jb *jobCollector) getTrivyNamespace(ctx context.Context) (*corev1.Namespace, error) {
 	return jb.cluster.GetK8sClientSet().CoreV1().Namespaces().Get(ctx, jb.namespace, metav1.GetOptions{})
 }
 
+// Cleanup deletes the namespace of the collector when it is the default one or was created by this collector,
+// namespaces left by other scans are deleted by GarbageCollect
 func (jb *jobCollector) Cleanup(ctx context.Context) {
-	if jb.namespace != defaultNamespace {
+	if jb.namespace != defaultNamespace && !jb.createdNamespaces.has(jb.namespace) {
 		return
 	}
 	jb.deleteTrivyNamespace(ctx)
@@ -560,20 +753,27 @@ func (jb *jobCollector) GetCollectorArgs() (CollectorArgs, error) {
 	var configMap map[string]string
 	var commandMap map[string][]any
 	if len(jb.specCommandIds) > 0 {
-		if len(jb.commandPaths) > 0 {
-			commandMap, configMap = loadCommands(jb.commandPaths, AddChecksByCheckId)
-		} else {
-			commandMap, configMap = getEmbeddedCommands(jb.commandsFileSystem, jb.nodeConfigFileSystem, AddChecksByCheckId)
-		}
+		commandMap, configMap = jb.loadCommands(AddChecksByCheckId)
 		nodeCommands = filterCommandBySpecId(commandMap, jb.specCommandIds)
 	} else {
-		if len(jb.commandPaths) > 0 {
-			commandMap, configMap = loadCommands(jb.commandPaths, AddChecksByPlatform)
-		} else {
-			commandMap, configMap = getEmbeddedCommands(jb.commandsFileSystem, jb.nodeConfigFileSystem, AddChecksByPlatform)
-		}
+		commandMap, configMap = jb.loadCommands(AddChecksByPlatform)
 		platform := jb.cluster.Platform()
 		nodeCommands = filterCommandByPlatform(commandMap, platform.Name)
+
+		spec, err := jb.resolveComplianceSpec(configMap, platform)
+		switch {
+		case err == nil:
+			slog.Debug("Selecting node commands of the compliance spec", "platform", platform.Name, "spec", spec.ID)
+			if ids := jb.specCommandIDs(spec.ID); len(ids) > 0 {
+				commandMap, _ = jb.loadCommands(AddChecksByCheckId)
+				nodeCommands = filterCommandBySpecId(commandMap, ids)
+			} else if _, ok := commandMap[platform.Name]; !ok {
+				// platforms without commands of their own use the commands of the spec platform
+				nodeCommands = filterCommandByPlatform(commandMap, spec.Platform)
+			}
+		case !errors.Is(err, errNoPlatformMapping):
+			slog.Warn("Unable to resolve compliance spec, selecting commands by platform", "platform", platform.Name, "error", err)
+		}
 	}
 	if len(nodeCommands.Commands) == 0 {
 		return CollectorArgs{}, fmt.Errorf("no compliance commands found")
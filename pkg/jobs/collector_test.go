@@ -1,11 +1,23 @@
 package jobs
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
 
 	trivy_checks "github.com/aquasecurity/trivy-checks"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/fake"
 )
 
 func TestLoadCheckFilesByID(t *testing.T) {
@@ -273,3 +285,118 @@ func TestFilterCommandsByPlatform(t *testing.T) {
 		})
 	}
 }
+
+func TestCollectorWithOptions(t *testing.T) {
+	jc := &jobCollector{namespace: "trivy-temp"}
+	WithJobLabels(map[string]string{TrivyCollectorName: NodeCollectorName})(jc)
+
+	node := jc.withOptions(WithJobLabels(map[string]string{TrivyResourceName: "node-1"}))
+	other := jc.withOptions(WithJobLabels(map[string]string{TrivyResourceName: "node-2"}))
+
+	assert.Equal(t, map[string]string{TrivyCollectorName: NodeCollectorName}, jc.labels)
+	assert.Equal(t, map[string]string{TrivyCollectorName: NodeCollectorName, TrivyResourceName: "node-1"}, node.labels)
+	assert.Equal(t, map[string]string{TrivyCollectorName: NodeCollectorName, TrivyResourceName: "node-2"}, other.labels)
+	assert.Equal(t, "trivy-temp", node.namespace)
+	assert.Same(t, jc, jc.withOptions())
+}
+
+func TestCleanup(t *testing.T) {
+	tests := []struct {
+		name        string
+		namespace   string
+		labels      map[string]string
+		create      bool
+		wantDeleted bool
+	}{
+		{name: "default namespace", namespace: defaultNamespace, wantDeleted: true},
+		{name: "created by the collector", namespace: "trivy-scan", create: true, wantDeleted: true},
+		// left to GarbageCollect, another scan may still use it
+		{name: "created by another collector", namespace: "trivy-scan", labels: map[string]string{TrivyAutoCreated: "true"}},
+		{name: "existing namespace", namespace: "security"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var objects []runtime.Object
+			if !tt.create {
+				objects = append(objects, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: tt.namespace, Labels: tt.labels}})
+			}
+			cluster, err := fake.NewCluster(objects, fake.WithPlatform(k8s.Platform{Name: "k8s"}))
+			require.NoError(t, err)
+
+			jc := NewCollector(cluster, WithJobNamespace(tt.namespace)).(*jobCollector)
+			// the namespace is created by a per node copy of the collector
+			require.NoError(t, jc.withOptions(WithJobLabels(map[string]string{TrivyResourceName: "node-1"})).createTrivyNamespace(ctx))
+			jc.Cleanup(ctx)
+
+			_, err = cluster.Clientset.CoreV1().Namespaces().Get(ctx, tt.namespace, metav1.GetOptions{})
+			assert.Equal(t, tt.wantDeleted, err != nil)
+		})
+	}
+}
+
+func TestCreateTrivyNamespace(t *testing.T) {
+	ctx := context.Background()
+	cluster, err := fake.NewCluster(nil, fake.WithPlatform(k8s.Platform{Name: "k8s"}))
+	require.NoError(t, err)
+	jc := NewCollector(cluster,
+		WithJobNamespace("trivy-scan"),
+		WithJobTemplateName(NodeCollectorName),
+	).(*jobCollector)
+
+	require.NoError(t, jc.createTrivyNamespace(ctx))
+	ns, err := cluster.Clientset.CoreV1().Namespaces().Get(ctx, "trivy-scan", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{TrivyAutoCreated: "true", TrivyCollectorName: NodeCollectorName}, ns.Labels)
+}
+
+func TestGetCollectorArgsComplianceSpec(t *testing.T) {
+	// the k8s-cis-1.23 spec of the platform mapping only checks CMD-0002
+	loader := specLoaderStub{"k8s-cis-1.23": `
+spec:
+  id: k8s-cis-1.23
+  controls:
+    - id: 1.1.1
+      commands:
+        - id: CMD-0002
+`}
+	tests := []struct {
+		name   string
+		loader SpecLoader
+		want   []string
+	}{
+		{name: "commands of the mapped spec", loader: loader, want: []string{"CMD-0002"}},
+		{name: "commands of the platform without loader", want: []string{"CMD-0001", "CMD-0002"}},
+		{name: "commands of the platform for unknown spec", loader: specLoaderStub{}, want: []string{"CMD-0001", "CMD-0002"}},
+	}
+	// the fixture with the config file names of the commands bundle
+	bundle := t.TempDir()
+	require.NoError(t, os.CopyFS(bundle, os.DirFS("./testdata/fixture")))
+	for from, to := range map[string]string{"kubelet_mapping_cfg.yaml": "kubelet_mapping.yaml", "node_cfg.yaml": "node.yaml"} {
+		config := filepath.Join(bundle, commandsRootFolder, configCommandsFolder)
+		require.NoError(t, os.Rename(filepath.Join(config, from), filepath.Join(config, to)))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, err := fake.NewCluster(nil, fake.WithPlatform(k8s.Platform{Name: "k8s", Version: "1.31"}))
+			require.NoError(t, err)
+			jc := NewCollector(cluster,
+				WithCommandsPath([]string{bundle}),
+				WithComplianceSpecLoader(tt.loader),
+			).(*jobCollector)
+
+			ca, err := jc.GetCollectorArgs()
+			require.NoError(t, err)
+			data, err := decodeAndDecompress(ca.commands)
+			require.NoError(t, err)
+			var commands NodeCommands
+			require.NoError(t, yaml.Unmarshal(data, &commands))
+			var ids []string
+			for _, command := range commands.Commands {
+				ids = append(ids, command.(map[string]any)["id"].(string))
+			}
+			sort.Strings(ids)
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+}
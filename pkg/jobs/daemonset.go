@@ -0,0 +1,365 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// daemonSetNameLabel selects the pods of a node-collector DaemonSet
+	daemonSetNameLabel   = "trivy.daemonset.name"
+	nodeNameEnv          = "NODE_NAME"
+	pauseContainerName   = "pause"
+	defaultPauseImageRef = "registry.k8s.io/pause:3.10"
+)
+
+// daemonSetPollInterval is the interval between two reads of the DaemonSet pods
+var daemonSetPollInterval = 2 * time.Second
+
+// NodeOutput is the node-collector output of a node, Err is set when the node could not be collected
+type NodeOutput struct {
+	Node   string
+	Output string
+	Err    *NodeCollectorError
+}
+
+// DaemonSetCollector collects the node info of many nodes with a single DaemonSet
+type DaemonSetCollector interface {
+	// CollectNodes deploys the node-collector DaemonSet on the nodes and returns their output in the order
+	// of the nodes, the DaemonSet is deleted once every node is collected or failed
+	CollectNodes(ctx context.Context, nodeNames []string) ([]NodeOutput, error)
+	Cleanup(ctx context.Context)
+}
+
+type daemonSetCollector struct {
+	*jobCollector
+	readLogs func(ctx context.Context, pod *corev1.Pod, previous bool) ([]byte, error)
+}
+
+// NewDaemonSetCollector instansiate a collector running the node-collector as the init container of a DaemonSet,
+// the node-collector loads the kubelet config of its node, the service account of the DaemonSet must be
+// allowed to get nodes/proxy
+func NewDaemonSetCollector(cluster k8s.Cluster, opts ...CollectorOption) DaemonSetCollector {
+	dc := &daemonSetCollector{jobCollector: NewCollector(cluster, opts...).(*jobCollector)}
+	dc.readLogs = dc.podLogs
+	return dc
+}
+
+func (dc *daemonSetCollector) CollectNodes(ctx context.Context, nodeNames []string) ([]NodeOutput, error) {
+	if len(nodeNames) == 0 {
+		return []NodeOutput{}, nil
+	}
+	if dc.resultTransport == resultTransportConfigMap {
+		return nil, fmt.Errorf("result transport %q is not supported by the daemonset collector", dc.resultTransport)
+	}
+	if err := checkResultTransport(dc.resultTransport, dc.imageRef); err != nil {
+		return nil, err
+	}
+	if err := dc.createTrivyNamespace(ctx); err != nil {
+		return nil, err
+	}
+	ds, err := dc.daemonSet(nodeNames)
+	if err != nil {
+		return nil, err
+	}
+	clientset := dc.cluster.GetK8sClientSet()
+	ds, err = clientset.AppsV1().DaemonSets(ds.Namespace).Create(ctx, ds, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("creating node-collector daemonset: %w", err)
+	}
+	defer func() {
+		background := metav1.DeletePropagationBackground
+		_ = clientset.AppsV1().DaemonSets(ds.Namespace).Delete(context.WithoutCancel(ctx), ds.Name, metav1.DeleteOptions{
+			PropagationPolicy: &background,
+		})
+	}()
+
+	if dc.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dc.timeout)
+		defer cancel()
+	}
+	outputs := make(map[string]NodeOutput, len(nodeNames))
+	err = wait.PollUntilContextCancel(ctx, daemonSetPollInterval, true, func(ctx context.Context) (bool, error) {
+		return dc.collectPods(ctx, ds, nodeNames, outputs), nil
+	})
+	if err != nil {
+		err = fmt.Errorf("node-collector did not complete on the node: %w", ErrTimeout)
+	}
+
+	result := make([]NodeOutput, 0, len(nodeNames))
+	for _, name := range nodeNames {
+		output, ok := outputs[name]
+		if !ok {
+			output = NodeOutput{Node: name, Err: &NodeCollectorError{Node: name, Reason: NodeFailureTimeout, JobName: ds.Name, Err: err}}
+		}
+		result = append(result, output)
+	}
+	return result, nil
+}
+
+// collectPods records the output or the failure of the nodes whose pod is done, it returns true once
+// every node is recorded
+func (dc *daemonSetCollector) collectPods(ctx context.Context, ds *appsv1.DaemonSet, nodeNames []string, outputs map[string]NodeOutput) bool {
+	clientset := dc.cluster.GetK8sClientSet()
+	// the DaemonSet is read before its pods, the pods of the nodes it counts are listed then
+	current, err := clientset.AppsV1().DaemonSets(ds.Namespace).Get(ctx, ds.Name, metav1.GetOptions{})
+	if err != nil {
+		slog.Debug("Unable to get node-collector daemonset", "daemonset", ds.Name, "error", err)
+		return false
+	}
+	pods, err := clientset.CoreV1().Pods(ds.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(ds.Spec.Selector.MatchLabels).String(),
+	})
+	if err != nil {
+		slog.Debug("Unable to list node-collector daemonset pods", "daemonset", ds.Name, "error", err)
+		return false
+	}
+	podsByNode := make(map[string]*corev1.Pod, len(pods.Items))
+	for i := range pods.Items {
+		podsByNode[daemonPodNode(&pods.Items[i])] = &pods.Items[i]
+	}
+	// once the controller created the pods of every node it schedules, nodes without a pod are not schedulable
+	settled := current.Status.ObservedGeneration >= current.Generation &&
+		current.Status.CurrentNumberScheduled == current.Status.DesiredNumberScheduled
+
+	for _, name := range nodeNames {
+		if _, ok := outputs[name]; ok {
+			continue
+		}
+		pod, ok := podsByNode[name]
+		if !ok {
+			if settled {
+				outputs[name] = NodeOutput{Node: name, Err: &NodeCollectorError{
+					Node:    name,
+					Reason:  NodeFailureUnschedulable,
+					JobName: ds.Name,
+					Err:     errors.New("the daemonset does not schedule a pod on the node, check its taints and labels"),
+				}}
+			}
+			continue
+		}
+		if output, done := dc.podOutput(ctx, ds, name, pod); done {
+			outputs[name] = output
+		}
+	}
+	return len(outputs) == len(nodeNames)
+}
+
+// podOutput returns the output of the node once its node-collector init container terminated
+func (dc *daemonSetCollector) podOutput(ctx context.Context, ds *appsv1.DaemonSet, node string, pod *corev1.Pod) (NodeOutput, bool) {
+	nodeErr := &NodeCollectorError{Node: node, JobName: ds.Name, PodPhase: pod.Status.Phase}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable {
+			nodeErr.Reason = NodeFailureUnschedulable
+			nodeErr.Err = fmt.Errorf("pod %s is unschedulable: %s", pod.Name, condition.Message)
+			return NodeOutput{Node: node, Err: nodeErr}, true
+		}
+	}
+	var status *corev1.ContainerStatus
+	for i := range pod.Status.InitContainerStatuses {
+		if pod.Status.InitContainerStatuses[i].Name == NodeCollectorName {
+			status = &pod.Status.InitContainerStatuses[i]
+		}
+	}
+	if status == nil {
+		return NodeOutput{}, false
+	}
+
+	if terminated := status.State.Terminated; terminated != nil && terminated.ExitCode == 0 {
+		if dc.resultTransport == resultTransportTerminationMessage && !truncatedTerminationMessage(terminated.Message) {
+			output, err := decodeResultEnvelope([]byte(terminated.Message))
+			if err != nil {
+				nodeErr.Reason = NodeFailureInvalidOutput
+				nodeErr.Err = err
+				return NodeOutput{Node: node, Err: nodeErr}, true
+			}
+			return NodeOutput{Node: node, Output: string(output)}, true
+		}
+		output, err := dc.readLogs(ctx, pod, false)
+		if err != nil {
+			nodeErr.Reason = NodeFailureLogs
+			nodeErr.Err = fmt.Errorf("getting logs: %w", err)
+			return NodeOutput{Node: node, Err: nodeErr}, true
+		}
+		if !json.Valid(output) {
+			nodeErr.Reason = NodeFailureInvalidOutput
+			nodeErr.Logs = lastLines(string(output), nodeFailureLogLines)
+			nodeErr.Err = errors.New("node-collector output is not valid JSON")
+			return NodeOutput{Node: node, Err: nodeErr}, true
+		}
+		return NodeOutput{Node: node, Output: string(output)}, true
+	}
+	// the pods of a DaemonSet always restart, a failed init container is run again
+	terminated, previous := status.State.Terminated, false
+	if terminated == nil {
+		terminated, previous = status.LastTerminationState.Terminated, true
+	}
+	if terminated == nil || terminated.ExitCode == 0 {
+		return NodeOutput{}, false
+	}
+	nodeErr.Reason = NodeFailureJobFailed
+	nodeErr.Err = fmt.Errorf("node-collector exited with code %d: %s", terminated.ExitCode, terminated.Reason)
+	if logs, err := dc.readLogs(ctx, pod, previous); err == nil {
+		nodeErr.Logs = lastLines(string(logs), nodeFailureLogLines)
+	}
+	return NodeOutput{Node: node, Err: nodeErr}, true
+}
+
+func (dc *daemonSetCollector) podLogs(ctx context.Context, pod *corev1.Pod, previous bool) ([]byte, error) {
+	return dc.cluster.GetK8sClientSet().CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: NodeCollectorName,
+		Previous:  previous,
+	}).DoRaw(ctx)
+}
+
+// daemonSet builds the DaemonSet of the nodes from the job template, the node-collector runs as init
+// container and a pause container keeps the pod running once it is done
+func (dc *daemonSetCollector) daemonSet(nodeNames []string) (*appsv1.DaemonSet, error) {
+	ca, err := dc.collectorArgs()
+	if err != nil {
+		return nil, err
+	}
+	job, err := GetJob(
+		WithTemplate(dc.templateName),
+		WithNamespace(dc.namespace),
+		WithNodeName(fmt.Sprintf("$(%s)", nodeNameEnv)),
+		WithAnnotation(dc.annotation),
+		WithLabels(dc.labels),
+		withSecurityContext(dc.securityContext),
+		withPodSecurityContext(dc.podSecurityContext),
+		WithNodeCollectorImageRef(dc.imageRef),
+		WithAffinity(dc.affinity),
+		WithTolerations(dc.tolerations),
+		WithJobServiceAccount(dc.serviceAccount),
+		WithK8sNodeCommands(ca.commands),
+		WithK8sKubeletConfigMapping(ca.kubeletConfigMapping),
+		WithK8sNodeConfigData(ca.nodeConfigData),
+		WithPodVolumes(dc.volumes),
+		WithImagePullSecrets(dc.imagePullSecrets),
+		WithContainerVolumeMounts(dc.volumeMounts),
+		WithNodeConfiguration(false),
+		WithPriorityClassName(dc.priorityClassName),
+		WithResourceRequirements(dc.resourceRequirements),
+		withJobResultTransport(dc.resultTransport, ""),
+		WithJobName(fmt.Sprintf("%s-%s", dc.templateName, ComputeHash(
+			ObjectRef{
+				Kind:      "DaemonSet",
+				Name:      strings.Join(nodeNames, ","),
+				Namespace: dc.namespace,
+			}))),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("building node-collector daemonset: %w", err)
+	}
+
+	podSpec := job.Spec.Template.Spec
+	collector := podSpec.Containers[0]
+	collector.Env = append(collector.Env, corev1.EnvVar{
+		Name: nodeNameEnv,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"},
+		},
+	})
+	pauseImageRef := dc.pauseImageRef
+	if pauseImageRef == "" {
+		pauseImageRef = defaultPauseImageRef
+	}
+	podSpec.InitContainers = []corev1.Container{collector}
+	podSpec.Containers = []corev1.Container{{
+		Name:  pauseContainerName,
+		Image: pauseImageRef,
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("10m"),
+				corev1.ResourceMemory: resource.MustParse("10M"),
+			},
+		},
+		SecurityContext: collector.SecurityContext,
+	}}
+	podSpec.RestartPolicy = corev1.RestartPolicyAlways
+	podSpec.Affinity = withNodeNames(podSpec.Affinity, nodeNames)
+
+	selector := map[string]string{daemonSetNameLabel: job.Name}
+	podLabels := maps.Clone(job.Spec.Template.Labels)
+	if podLabels == nil {
+		podLabels = make(map[string]string)
+	}
+	maps.Copy(podLabels, selector)
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name,
+			Namespace: job.Namespace,
+			Labels:    job.Labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      podLabels,
+					Annotations: job.Spec.Template.Annotations,
+				},
+				Spec: podSpec,
+			},
+		},
+	}, nil
+}
+
+// withNodeNames returns a copy of the affinity restricted to the named nodes
+func withNodeNames(affinity *corev1.Affinity, nodeNames []string) *corev1.Affinity {
+	a := affinity.DeepCopy()
+	if a == nil {
+		a = &corev1.Affinity{}
+	}
+	if a.NodeAffinity == nil {
+		a.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	if a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+	required := a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+	for i := range required.NodeSelectorTerms {
+		required.NodeSelectorTerms[i].MatchFields = append(required.NodeSelectorTerms[i].MatchFields, corev1.NodeSelectorRequirement{
+			Key:      metav1.ObjectNameField,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   nodeNames,
+		})
+	}
+	return a
+}
+
+// daemonPodNode returns the node of a DaemonSet pod, pods not scheduled yet target their node by affinity
+func daemonPodNode(pod *corev1.Pod) string {
+	if pod.Spec.NodeName != "" {
+		return pod.Spec.NodeName
+	}
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil ||
+		pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return ""
+	}
+	for _, term := range pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, field := range term.MatchFields {
+			if field.Key == metav1.ObjectNameField && len(field.Values) == 1 {
+				return field.Values[0]
+			}
+		}
+	}
+	return ""
+}
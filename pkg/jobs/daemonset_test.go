@@ -0,0 +1,194 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	trivy_checks "github.com/aquasecurity/trivy-checks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/fake"
+)
+
+// daemonPod returns the pod of the DaemonSet on the node with the node-collector init container status
+func daemonPod(ds *appsv1.DaemonSet, node string, phase corev1.PodPhase, status *corev1.ContainerStatus, conditions ...corev1.PodCondition) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ds.Namespace, Name: ds.Name + "-" + node, Labels: ds.Spec.Template.Labels},
+		Spec:       *ds.Spec.Template.Spec.DeepCopy(),
+		Status:     corev1.PodStatus{Phase: phase, Conditions: conditions},
+	}
+	pod.Spec.Affinity = withNodeNames(nil, []string{node})
+	if status != nil {
+		status.Name = NodeCollectorName
+		pod.Status.InitContainerStatuses = []corev1.ContainerStatus{*status}
+		pod.Spec.NodeName = node
+	}
+	return pod
+}
+
+func TestDaemonSetCollector(t *testing.T) {
+	daemonSetPollInterval = 10 * time.Millisecond
+
+	cluster, err := fake.NewCluster(nil, fake.WithPlatform(k8s.Platform{Name: "k8s"}))
+	require.NoError(t, err)
+	// the pods the DaemonSet controller would create for the nodes
+	cluster.Clientset.PrependReactor("create", "daemonsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		ds := action.(k8stesting.CreateAction).GetObject().(*appsv1.DaemonSet)
+		pods := []*corev1.Pod{
+			daemonPod(ds, "node-1", corev1.PodRunning, &corev1.ContainerStatus{
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0, Reason: "Completed"}},
+			}),
+			daemonPod(ds, "node-2", corev1.PodPending, &corev1.ContainerStatus{
+				State:                corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"}},
+			}),
+			daemonPod(ds, "node-3", corev1.PodPending, nil, corev1.PodCondition{
+				Type:    corev1.PodScheduled,
+				Status:  corev1.ConditionFalse,
+				Reason:  corev1.PodReasonUnschedulable,
+				Message: "0/4 nodes are available: 1 Insufficient cpu.",
+			}),
+			daemonPod(ds, "node-5", corev1.PodRunning, &corev1.ContainerStatus{
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0, Reason: "Completed"}},
+			}),
+		}
+		for _, pod := range pods {
+			// the tracker is used directly, the clientset is locked while the reactor runs
+			require.NoError(t, cluster.Clientset.Tracker().Create(corev1.SchemeGroupVersion.WithResource("pods"), pod, ds.Namespace))
+		}
+		return false, nil, nil
+	})
+
+	dc := NewDaemonSetCollector(cluster,
+		WithJobTemplateName(NodeCollectorName),
+		WithJobNamespace("trivy-temp"),
+		WithJobTolerations([]corev1.Toleration{{Operator: corev1.TolerationOpExists}}),
+		WithTimetout(time.Second),
+		WithEmbeddedCommandFileSystem(trivy_checks.EmbeddedK8sCommandsFileSystem),
+		WithEmbeddedNodeConfigFilesystem(trivy_checks.EmbeddedConfigCommandsFileSystem),
+	).(*daemonSetCollector)
+	dc.readLogs = func(_ context.Context, pod *corev1.Pod, previous bool) ([]byte, error) {
+		switch pod.Spec.NodeName {
+		case "node-1":
+			return []byte(`{"type":"master"}`), nil
+		case "node-2":
+			assert.True(t, previous)
+			return []byte("error: open /etc/kubernetes: permission denied\n"), nil
+		}
+		return []byte("not json"), nil
+	}
+
+	outputs, err := dc.CollectNodes(context.Background(), []string{"node-1", "node-2", "node-3", "node-4", "node-5"})
+	require.NoError(t, err)
+	require.Len(t, outputs, 5)
+
+	assert.Equal(t, NodeOutput{Node: "node-1", Output: `{"type":"master"}`}, outputs[0])
+	wantErrs := []struct {
+		reason NodeFailureReason
+		err    string
+		logs   []string
+	}{
+		{reason: NodeFailureJobFailed, err: "node-collector exited with code 1: Error", logs: []string{"error: open /etc/kubernetes: permission denied"}},
+		{reason: NodeFailureUnschedulable, err: "0/4 nodes are available: 1 Insufficient cpu."},
+		{reason: NodeFailureUnschedulable, err: "the daemonset does not schedule a pod on the node"},
+		{reason: NodeFailureInvalidOutput, err: "not valid JSON", logs: []string{"not json"}},
+	}
+	for i, want := range wantErrs {
+		output := outputs[i+1]
+		require.NotNil(t, output.Err, output.Node)
+		assert.Equal(t, output.Node, output.Err.Node)
+		assert.Equal(t, want.reason, output.Err.Reason, output.Node)
+		assert.ErrorContains(t, output.Err, want.err)
+		assert.Equal(t, want.logs, output.Err.Logs)
+	}
+
+	// the DaemonSet is torn down
+	daemonSets, err := cluster.Clientset.AppsV1().DaemonSets("trivy-temp").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, daemonSets.Items)
+}
+
+func TestDaemonSetCollectorTimeout(t *testing.T) {
+	daemonSetPollInterval = 10 * time.Millisecond
+
+	cluster, err := fake.NewCluster(nil, fake.WithPlatform(k8s.Platform{Name: "k8s"}))
+	require.NoError(t, err)
+	// the controller has not observed the DaemonSet yet
+	cluster.Clientset.PrependReactor("create", "daemonsets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*appsv1.DaemonSet).Generation = 1
+		return false, nil, nil
+	})
+	dc := NewDaemonSetCollector(cluster,
+		WithJobTemplateName(NodeCollectorName),
+		WithJobNamespace("trivy-temp"),
+		WithTimetout(50*time.Millisecond),
+		WithEmbeddedCommandFileSystem(trivy_checks.EmbeddedK8sCommandsFileSystem),
+		WithEmbeddedNodeConfigFilesystem(trivy_checks.EmbeddedConfigCommandsFileSystem),
+	)
+
+	outputs, err := dc.CollectNodes(context.Background(), []string{"node-1", "node-2"})
+	require.NoError(t, err)
+	for i, output := range outputs {
+		assert.Equal(t, fmt.Sprintf("node-%d", i+1), output.Node)
+		require.NotNil(t, output.Err)
+		assert.Equal(t, NodeFailureTimeout, output.Err.Reason)
+		assert.ErrorIs(t, output.Err, ErrTimeout)
+	}
+}
+
+func TestDaemonSetSpec(t *testing.T) {
+	cluster, err := fake.NewCluster(nil, fake.WithPlatform(k8s.Platform{Name: "k8s"}))
+	require.NoError(t, err)
+	affinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"system"}}},
+		}}},
+	}}
+	dc := NewDaemonSetCollector(cluster,
+		WithJobTemplateName(NodeCollectorName),
+		WithJobNamespace("trivy-temp"),
+		WithJobLabels(map[string]string{TrivyCollectorName: NodeCollectorName}),
+		WithJobAffinity(affinity),
+		WithServiceAccount("node-collector"),
+		WithPauseImageRef("mirror.gcr.io/pause:3.10"),
+		WithEmbeddedCommandFileSystem(trivy_checks.EmbeddedK8sCommandsFileSystem),
+		WithEmbeddedNodeConfigFilesystem(trivy_checks.EmbeddedConfigCommandsFileSystem),
+	).(*daemonSetCollector)
+
+	ds, err := dc.daemonSet([]string{"node-1", "node-2"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "trivy-temp", ds.Namespace)
+	assert.Equal(t, map[string]string{TrivyCollectorName: NodeCollectorName}, ds.Labels)
+	assert.Equal(t, map[string]string{daemonSetNameLabel: ds.Name}, ds.Spec.Selector.MatchLabels)
+	assert.Equal(t, map[string]string{"app": NodeCollectorName, daemonSetNameLabel: ds.Name}, ds.Spec.Template.Labels)
+
+	spec := ds.Spec.Template.Spec
+	assert.Equal(t, corev1.RestartPolicyAlways, spec.RestartPolicy)
+	assert.Equal(t, "node-collector", spec.ServiceAccountName)
+	require.Len(t, spec.InitContainers, 1)
+	require.Len(t, spec.Containers, 1)
+	assert.Equal(t, "mirror.gcr.io/pause:3.10", spec.Containers[0].Image)
+
+	collector := spec.InitContainers[0]
+	assert.Equal(t, NodeCollectorName, collector.Name)
+	assert.Contains(t, collector.Args, "$(NODE_NAME)")
+	assert.NotContains(t, collector.Args, "--kubelet-config")
+	assert.Equal(t, "spec.nodeName", collector.Env[len(collector.Env)-1].ValueFrom.FieldRef.FieldPath)
+
+	// the DaemonSet is restricted to the nodes, the affinity of the collector is kept
+	terms := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	require.Len(t, terms, 1)
+	assert.Equal(t, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions, terms[0].MatchExpressions)
+	assert.Equal(t, []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"node-1", "node-2"}}}, terms[0].MatchFields)
+	assert.Empty(t, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchFields)
+}
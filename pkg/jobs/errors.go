@@ -0,0 +1,238 @@
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// NodeFailureReason tells why the collection of a node failed
+type NodeFailureReason string
+
+const (
+	// NodeFailureNodeConfig the kubelet configz of the node could not be loaded
+	NodeFailureNodeConfig NodeFailureReason = "NodeConfig"
+	// NodeFailureJobFailed the node-collector failed or its job could not be created
+	NodeFailureJobFailed NodeFailureReason = "JobFailed"
+	// NodeFailureTimeout the job did not complete in time
+	NodeFailureTimeout NodeFailureReason = "Timeout"
+	// NodeFailureLogs the output of the job could not be read
+	NodeFailureLogs NodeFailureReason = "Logs"
+	// NodeFailureInvalidOutput the output of the job is not valid JSON
+	NodeFailureInvalidOutput NodeFailureReason = "InvalidOutput"
+	// NodeFailureUnschedulable the pod of the DaemonSet cannot be scheduled on the node
+	NodeFailureUnschedulable NodeFailureReason = "Unschedulable"
+)
+
+const (
+	// nodeFailureLogLines is the number of log lines kept in a NodeCollectorError
+	nodeFailureLogLines = 20
+	// nodeFailureDiagnosticsTimeout bounds the requests reading the pod of a failed job
+	nodeFailureDiagnosticsTimeout = 10 * time.Second
+)
+
+// NodeCollectorError is the failure of the node-collector job of a node
+type NodeCollectorError struct {
+	Node   string
+	Reason NodeFailureReason
+	// JobName is the name of the job or DaemonSet of the node-collector
+	JobName string
+	// PodPhase is the phase of the job pod, empty when the pod was not found
+	PodPhase corev1.PodPhase
+	// Logs holds the last log lines of the node-collector container
+	Logs []string
+	Err  error
+}
+
+func (e *NodeCollectorError) Error() string {
+	return fmt.Sprintf("node %s: %s: %v", e.Node, e.Reason, e.Err)
+}
+
+func (e *NodeCollectorError) Unwrap() error {
+	return e.Err
+}
+
+// AsNodeCollectorError returns the NodeCollectorError of err, if any
+func AsNodeCollectorError(err error) (*NodeCollectorError, bool) {
+	var nodeErr *NodeCollectorError
+	ok := errors.As(err, &nodeErr)
+	return nodeErr, ok
+}
+
+// runFailureReason returns the reason of a job run error
+func runFailureReason(err error) NodeFailureReason {
+	if errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return NodeFailureTimeout
+	}
+	if errors.Is(err, ErrUnschedulable) {
+		return NodeFailureUnschedulable
+	}
+	return NodeFailureJobFailed
+}
+
+// newNodeCollectorError returns the error of the node job with the phase and the last log lines of its pod,
+// they are read with a fresh context since the context of the job may be done
+func newNodeCollectorError(ctx context.Context, clientset kubernetes.Interface, node string, reason NodeFailureReason, job *batchv1.Job, err error) *NodeCollectorError {
+	nodeErr := &NodeCollectorError{Node: node, Reason: reason, Err: err}
+	if job == nil {
+		return nodeErr
+	}
+	nodeErr.JobName = job.Name
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), nodeFailureDiagnosticsTimeout)
+	defer cancel()
+	pod, err := (&logsReader{clientset: clientset}).getPodByJob(ctx, job)
+	if err != nil || pod == nil {
+		return nodeErr
+	}
+	nodeErr.PodPhase = pod.Status.Phase
+	data, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: NodeCollectorName,
+		TailLines: ptr.To[int64](nodeFailureLogLines),
+	}).DoRaw(ctx)
+	if err != nil {
+		return nodeErr
+	}
+	nodeErr.Logs = lastLines(string(data), nodeFailureLogLines)
+	return nodeErr
+}
+
+// lastLines returns the last n non empty lines of s
+func lastLines(s string, n int) []string {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+var (
+	// ErrImagePull is returned when the image of the job pod cannot be pulled
+	ErrImagePull = errors.New("image cannot be pulled")
+	// ErrUnschedulable is returned when the job pod cannot be scheduled
+	ErrUnschedulable = errors.New("pod cannot be scheduled")
+	// ErrCreateContainerConfig is returned when the container of the job pod cannot be configured,
+	// e.g. a missing Secret or ConfigMap
+	ErrCreateContainerConfig = errors.New("container cannot be configured")
+	// ErrAdmissionDenied is returned when the job pod is denied by an admission controller or webhook
+	ErrAdmissionDenied = errors.New("pod denied by admission")
+	// ErrOOMKilled is returned when the container of the job pod is killed for exceeding its memory limit
+	ErrOOMKilled = errors.New("container OOM killed")
+)
+
+// PodError is the failure of the pod of a job, Err is one of the pod errors or the failure of the job
+type PodError struct {
+	Err       error
+	Pod       string
+	Container string
+	// Reason is the reason of the container state, the pod condition or the event
+	Reason  string
+	Message string
+	// ExitCode and TerminationMessage are set for terminated containers
+	ExitCode           int32
+	TerminationMessage string
+	// Events holds the messages of the warning events of the pod
+	Events []string
+}
+
+func (e *PodError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	if e.Pod != "" {
+		fmt.Fprintf(&b, ": pod %s", e.Pod)
+	}
+	if e.Container != "" {
+		fmt.Fprintf(&b, " container %s", e.Container)
+	}
+	if e.Reason != "" {
+		fmt.Fprintf(&b, ": %s", e.Reason)
+	}
+	if e.ExitCode != 0 {
+		fmt.Fprintf(&b, " (exit code %d)", e.ExitCode)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	if e.TerminationMessage != "" {
+		fmt.Fprintf(&b, ": %s", e.TerminationMessage)
+	}
+	return b.String()
+}
+
+func (e *PodError) Unwrap() error {
+	return e.Err
+}
+
+// unschedulableGracePeriod is the time an unschedulable job pod is given to be scheduled, e.g. once the
+// cluster autoscaler added a node, before it fails the job
+var unschedulableGracePeriod = 2 * time.Minute
+
+// stuckPodError returns the error of a job pod that cannot make progress, nil while it may still complete.
+// Transient states such as ErrImagePull are retried by the kubelet, an unschedulable pod is stuck once it
+// stayed unschedulable for the grace period, the time left is returned to check the pod again.
+func stuckPodError(pod *corev1.Pod, now time.Time) (*PodError, time.Duration) {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse && condition.Reason == corev1.PodReasonUnschedulable {
+			if left := condition.LastTransitionTime.Add(unschedulableGracePeriod).Sub(now); left > 0 {
+				return nil, left
+			}
+			return &PodError{Err: ErrUnschedulable, Pod: pod.Name, Reason: condition.Reason, Message: condition.Message}, 0
+		}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; waiting != nil {
+			var err error
+			switch waiting.Reason {
+			case "ImagePullBackOff", "InvalidImageName":
+				err = ErrImagePull
+			case "CreateContainerConfigError":
+				err = ErrCreateContainerConfig
+			}
+			if err != nil {
+				return &PodError{Err: err, Pod: pod.Name, Container: status.Name, Reason: waiting.Reason, Message: waiting.Message}, 0
+			}
+		}
+		if terminated := status.State.Terminated; terminated != nil && terminated.Reason == "OOMKilled" {
+			return &PodError{
+				Err:                ErrOOMKilled,
+				Pod:                pod.Name,
+				Container:          status.Name,
+				Reason:             terminated.Reason,
+				ExitCode:           terminated.ExitCode,
+				TerminationMessage: terminated.Message,
+			}, 0
+		}
+	}
+	return nil, 0
+}
+
+// admissionDeniedError returns the error of a job warning event telling its pod was denied by an admission
+// webhook, a ValidatingAdmissionPolicy or PodSecurity admission, nil otherwise. Other creation failures, such
+// as an exceeded quota, may resolve themselves and are left to the job timeout.
+func admissionDeniedError(event *corev1.Event) *PodError {
+	if event.Reason != "FailedCreate" {
+		return nil
+	}
+	message := strings.ToLower(event.Message)
+	webhookDenied := strings.Contains(message, "admission webhook") && strings.Contains(message, "denied the request")
+	policyDenied := strings.Contains(message, "validatingadmissionpolicy") && strings.Contains(message, "denied request")
+	if !webhookDenied && !policyDenied && !strings.Contains(message, "violates podsecurity") {
+		return nil
+	}
+	return &PodError{Err: ErrAdmissionDenied, Reason: event.Reason, Message: event.Message, Events: []string{event.Message}}
+}
@@ -0,0 +1,194 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewNodeCollectorError(t *testing.T) {
+	selector := map[string]string{"batch.kubernetes.io/controller-uid": "0f2c"}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "trivy-temp", Name: "node-collector-6c4db57695"},
+		Spec:       batchv1.JobSpec{Selector: &metav1.LabelSelector{MatchLabels: selector}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "trivy-temp", Name: "node-collector-6c4db57695-x7k2p", Labels: selector},
+		Status:     corev1.PodStatus{Phase: corev1.PodFailed},
+	}
+	runErr := fmt.Errorf("running node-collector job: %w", ErrTimeout)
+
+	tests := []struct {
+		name    string
+		objects []*batchv1.Job
+		pods    []*corev1.Pod
+		job     *batchv1.Job
+		want    *NodeCollectorError
+	}{
+		{
+			name:    "pod phase and logs",
+			objects: []*batchv1.Job{job},
+			pods:    []*corev1.Pod{pod},
+			job:     job,
+			want: &NodeCollectorError{
+				Node:     "node-1",
+				Reason:   NodeFailureTimeout,
+				JobName:  job.Name,
+				PodPhase: corev1.PodFailed,
+				Logs:     []string{"fake logs"},
+				Err:      runErr,
+			},
+		},
+		{
+			name:    "pod not found",
+			objects: []*batchv1.Job{job},
+			job:     job,
+			want:    &NodeCollectorError{Node: "node-1", Reason: NodeFailureTimeout, JobName: job.Name, Err: runErr},
+		},
+		{
+			name: "job not created",
+			want: &NodeCollectorError{Node: "node-1", Reason: NodeFailureTimeout, Err: runErr},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewClientset()
+			for _, j := range tt.objects {
+				_, _ = clientset.BatchV1().Jobs(j.Namespace).Create(context.Background(), j, metav1.CreateOptions{})
+			}
+			for _, p := range tt.pods {
+				_, _ = clientset.CoreV1().Pods(p.Namespace).Create(context.Background(), p, metav1.CreateOptions{})
+			}
+			// the diagnostics are read even though the job context is done
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			got := newNodeCollectorError(ctx, clientset, "node-1", runFailureReason(runErr), tt.job, runErr)
+			assert.Equal(t, tt.want, got)
+			assert.True(t, errors.Is(got, ErrTimeout))
+			assert.Equal(t, "node node-1: Timeout: running node-collector job: runner received timeout", got.Error())
+		})
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	var b strings.Builder
+	for i := 1; i <= 30; i++ {
+		fmt.Fprintf(&b, "line %d\n\n", i)
+	}
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want []string
+	}{
+		{name: "empty", s: "", n: 3, want: []string{}},
+		{name: "fewer lines", s: "a\nb\n", n: 3, want: []string{"a", "b"}},
+		{name: "last lines", s: b.String(), n: 3, want: []string{"line 28", "line 29", "line 30"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, lastLines(tt.s, tt.n))
+		})
+	}
+}
+
+func TestStuckPodError(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	waiting := func(reason string) corev1.PodStatus {
+		return corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name: NodeCollectorName, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}},
+		}}}
+	}
+	unschedulable := func(since time.Duration) corev1.PodStatus {
+		return corev1.PodStatus{Conditions: []corev1.PodCondition{{
+			Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable,
+			LastTransitionTime: metav1.NewTime(now.Add(-since)),
+		}}}
+	}
+	tests := []struct {
+		name     string
+		status   corev1.PodStatus
+		wantErr  error
+		wantWait time.Duration
+	}{
+		{name: "image pull back-off", status: waiting("ImagePullBackOff"), wantErr: ErrImagePull},
+		{name: "invalid image name", status: waiting("InvalidImageName"), wantErr: ErrImagePull},
+		{name: "container config", status: waiting("CreateContainerConfigError"), wantErr: ErrCreateContainerConfig},
+		{name: "image pull is retried", status: waiting("ErrImagePull")},
+		{name: "container creation is retried", status: waiting("CreateContainerError")},
+		{name: "container creating", status: waiting("ContainerCreating")},
+		{name: "unschedulable in the grace period", status: unschedulable(30 * time.Second), wantWait: unschedulableGracePeriod - 30*time.Second},
+		{name: "unschedulable after the grace period", status: unschedulable(unschedulableGracePeriod), wantErr: ErrUnschedulable},
+		{name: "scheduled", status: corev1.PodStatus{Conditions: []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionTrue}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			podErr, wait := stuckPodError(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "node-collector-x7k2p"}, Status: tt.status}, now)
+			assert.Equal(t, tt.wantWait, wait)
+			if tt.wantErr == nil {
+				assert.Nil(t, podErr)
+				return
+			}
+			assert.ErrorIs(t, podErr, tt.wantErr)
+		})
+	}
+}
+
+func TestAdmissionDeniedError(t *testing.T) {
+	tests := []struct {
+		name    string
+		reason  string
+		message string
+		denied  bool
+	}{
+		{
+			name:    "admission webhook",
+			reason:  "FailedCreate",
+			message: `Error creating: admission webhook "validation.gatekeeper.sh" denied the request: privileged pods are not allowed`,
+			denied:  true,
+		},
+		{
+			name:    "pod security",
+			reason:  "FailedCreate",
+			message: `Error creating: pods "node-collector-x7k2p" is forbidden: violates PodSecurity "restricted:latest": hostPID=true`,
+			denied:  true,
+		},
+		{
+			name:    "validating admission policy",
+			reason:  "FailedCreate",
+			message: `Error creating: pods "node-collector-x7k2p" is forbidden: ValidatingAdmissionPolicy 'no-host-pid' with binding 'no-host-pid' denied request: hostPID is not allowed`,
+			denied:  true,
+		},
+		{
+			name:    "exceeded quota",
+			reason:  "FailedCreate",
+			message: `Error creating: pods "node-collector-x7k2p" is forbidden: exceeded quota: compute, requested: pods=1, used: pods=10, limited: pods=10`,
+		},
+		{
+			name:    "other reason",
+			reason:  "BackoffLimitExceeded",
+			message: `admission webhook "validation.gatekeeper.sh" denied the request`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			podErr := admissionDeniedError(&corev1.Event{Reason: tt.reason, Message: tt.message})
+			if !tt.denied {
+				assert.Nil(t, podErr)
+				return
+			}
+			assert.ErrorIs(t, podErr, ErrAdmissionDenied)
+			assert.Equal(t, tt.message, podErr.Message)
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	k8sapierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+)
+
+const defaultGCOlderThan = time.Hour
+
+type garbageCollector struct {
+	clientset     kubernetes.Interface
+	olderThan     time.Duration
+	dryRun        bool
+	collectorName string
+}
+
+type GCOption func(*garbageCollector)
+
+// WithGCOlderThan set the age of the resources to collect, 1 hour by default
+func WithGCOlderThan(olderThan time.Duration) GCOption {
+	return func(gc *garbageCollector) {
+		gc.olderThan = olderThan
+	}
+}
+
+// WithGCDryRun returns the resources to collect without deleting them
+func WithGCDryRun(dryRun bool) GCOption {
+	return func(gc *garbageCollector) {
+		gc.dryRun = dryRun
+	}
+}
+
+// WithGCCollectorName only collect the resources of the collector, e.g. node-collector
+func WithGCCollectorName(name string) GCOption {
+	return func(gc *garbageCollector) {
+		gc.collectorName = name
+	}
+}
+
+// gcResource lists and deletes the resources of a kind
+type gcResource struct {
+	kind   string
+	list   func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error)
+	delete func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error
+}
+
+// GarbageCollect deletes the Jobs, DaemonSets, result ConfigMaps and RBAC resources and the namespaces created by
+// collectors across namespaces and older than the threshold, left behind by a scanner that did not clean up.
+// A namespace is deleted only when the pods it holds all belong to the collected Jobs and DaemonSets.
+// It returns the collected resources, deleted or to delete with WithGCDryRun.
+func GarbageCollect(ctx context.Context, cluster k8s.Cluster, opts ...GCOption) ([]ObjectRef, error) {
+	gc := &garbageCollector{
+		clientset: cluster.GetK8sClientSet(),
+		olderThan: defaultGCOlderThan,
+	}
+	for _, opt := range opts {
+		opt(gc)
+	}
+	selector, err := gc.selector()
+	if err != nil {
+		return nil, err
+	}
+	listOptions := metav1.ListOptions{LabelSelector: selector}
+
+	var refs []ObjectRef
+	var errs []error
+	// owners holds the uid of the collected Jobs and DaemonSets, the owners of the pods they leave behind
+	owners := make(map[types.UID]bool)
+	for _, resource := range gc.namespacedResources() {
+		objects, err := resource.list(ctx, listOptions)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("listing %s: %w", resource.kind, err))
+			continue
+		}
+		for _, obj := range gc.expired(objects) {
+			ref := ObjectRef{Kind: resource.kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+			if err := gc.delete(ctx, resource, ref, obj.GetUID()); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if resource.kind == "Job" || resource.kind == "DaemonSet" {
+				owners[obj.GetUID()] = true
+			}
+			refs = append(refs, ref)
+		}
+	}
+
+	namespaces, err := gc.clientset.CoreV1().Namespaces().List(ctx, listOptions)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("listing Namespace: %w", err))
+		return refs, errors.Join(errs...)
+	}
+	resource := gc.namespaceResource()
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		if !gc.isExpired(ns) {
+			continue
+		}
+		inUse, err := gc.namespaceInUse(ctx, ns.Name, owners)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if inUse {
+			continue
+		}
+		ref := ObjectRef{Kind: resource.kind, Name: ns.Name}
+		if err := gc.delete(ctx, resource, ref, ns.UID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	return refs, errors.Join(errs...)
+}
+
+// selector matches the resources created by collectors, or by the collector when it is set
+func (gc *garbageCollector) selector() (string, error) {
+	autoCreated, err := labels.NewRequirement(TrivyAutoCreated, selection.Equals, []string{"true"})
+	if err != nil {
+		return "", err
+	}
+	collector, err := labels.NewRequirement(TrivyCollectorName, selection.Exists, nil)
+	if gc.collectorName != "" {
+		collector, err = labels.NewRequirement(TrivyCollectorName, selection.Equals, []string{gc.collectorName})
+	}
+	if err != nil {
+		return "", err
+	}
+	return labels.NewSelector().Add(*autoCreated, *collector).String(), nil
+}
+
+func (gc *garbageCollector) isExpired(obj metav1.Object) bool {
+	return obj.GetCreationTimestamp().Time.Before(time.Now().Add(-gc.olderThan))
+}
+
+func (gc *garbageCollector) expired(objects []metav1.Object) []metav1.Object {
+	var expired []metav1.Object
+	for _, obj := range objects {
+		if gc.isExpired(obj) {
+			expired = append(expired, obj)
+		}
+	}
+	return expired
+}
+
+// namespaceInUse returns true when a pod of the namespace is not controlled by a collected Job or DaemonSet
+func (gc *garbageCollector) namespaceInUse(ctx context.Context, namespace string, owners map[types.UID]bool) (bool, error) {
+	pods, err := gc.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, fmt.Errorf("listing pods of namespace %s: %w", namespace, err)
+	}
+	for _, pod := range pods.Items {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || !owners[owner.UID] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// delete deletes the resource when it still is the listed one, a resource already deleted is not an error
+func (gc *garbageCollector) delete(ctx context.Context, resource gcResource, ref ObjectRef, uid types.UID) error {
+	if gc.dryRun {
+		return nil
+	}
+	background := metav1.DeletePropagationBackground
+	err := resource.delete(ctx, ref.Namespace, ref.Name, metav1.DeleteOptions{
+		Preconditions:     metav1.NewUIDPreconditions(string(uid)),
+		PropagationPolicy: &background,
+	})
+	if err != nil && !k8sapierror.IsNotFound(err) {
+		return fmt.Errorf("deleting %s %s: %w", ref.Kind, path.Join(ref.Namespace, ref.Name), err)
+	}
+	return nil
+}
+
+// namespacedResources returns the kinds created by collectors in their namespace, the workloads first
+func (gc *garbageCollector) namespacedResources() []gcResource {
+	cs := gc.clientset
+	return []gcResource{
+		{
+			kind: "Job",
+			list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+				l, err := cs.BatchV1().Jobs(metav1.NamespaceAll).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				return objects(l.Items), nil
+			},
+			delete: func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+				return cs.BatchV1().Jobs(namespace).Delete(ctx, name, opts)
+			},
+		},
+		{
+			kind: "DaemonSet",
+			list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+				l, err := cs.AppsV1().DaemonSets(metav1.NamespaceAll).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				return objects(l.Items), nil
+			},
+			delete: func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+				return cs.AppsV1().DaemonSets(namespace).Delete(ctx, name, opts)
+			},
+		},
+		{
+			kind: "RoleBinding",
+			list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+				l, err := cs.RbacV1().RoleBindings(metav1.NamespaceAll).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				return objects(l.Items), nil
+			},
+			delete: func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+				return cs.RbacV1().RoleBindings(namespace).Delete(ctx, name, opts)
+			},
+		},
+		{
+			kind: "Role",
+			list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+				l, err := cs.RbacV1().Roles(metav1.NamespaceAll).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				return objects(l.Items), nil
+			},
+			delete: func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+				return cs.RbacV1().Roles(namespace).Delete(ctx, name, opts)
+			},
+		},
+		{
+			kind: "ServiceAccount",
+			list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+				l, err := cs.CoreV1().ServiceAccounts(metav1.NamespaceAll).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				return objects(l.Items), nil
+			},
+			delete: func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+				return cs.CoreV1().ServiceAccounts(namespace).Delete(ctx, name, opts)
+			},
+		},
+		{
+			kind: "ConfigMap",
+			list: func(ctx context.Context, opts metav1.ListOptions) ([]metav1.Object, error) {
+				l, err := cs.CoreV1().ConfigMaps(metav1.NamespaceAll).List(ctx, opts)
+				if err != nil {
+					return nil, err
+				}
+				return objects(l.Items), nil
+			},
+			delete: func(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+				return cs.CoreV1().ConfigMaps(namespace).Delete(ctx, name, opts)
+			},
+		},
+	}
+}
+
+func (gc *garbageCollector) namespaceResource() gcResource {
+	return gcResource{
+		kind: "Namespace",
+		delete: func(ctx context.Context, _, name string, opts metav1.DeleteOptions) error {
+			return gc.clientset.CoreV1().Namespaces().Delete(ctx, name, opts)
+		},
+	}
+}
+
+// objects returns the items of a list as metav1.Object
+func objects[T any, PT interface {
+	*T
+	metav1.Object
+}](items []T) []metav1.Object {
+	objs := make([]metav1.Object, 0, len(items))
+	for i := range items {
+		objs = append(objs, PT(&items[i]))
+	}
+	return objs
+}
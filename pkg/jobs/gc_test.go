@@ -0,0 +1,136 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/fake"
+)
+
+func gcMeta(namespace, name, collector string, age time.Duration) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{
+		Namespace:         namespace,
+		Name:              name,
+		UID:               types.UID(namespace + "/" + name),
+		CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+	}
+	if collector != "" {
+		meta.Labels = map[string]string{TrivyAutoCreated: "true", TrivyCollectorName: collector}
+	}
+	return meta
+}
+
+func gcPod(namespace, name string, owner metav1.Object, kind string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: gcMeta(namespace, name, "", 0)}
+	if owner != nil {
+		pod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(owner, batchv1.SchemeGroupVersion.WithKind(kind))}
+	}
+	return pod
+}
+
+func gcObjects() []runtime.Object {
+	job := &batchv1.Job{ObjectMeta: gcMeta("trivy-temp", "node-collector-1", NodeCollectorName, 2*time.Hour)}
+	ds := &appsv1.DaemonSet{ObjectMeta: gcMeta("trivy-scan", "node-collector-ds", NodeCollectorName, 2*time.Hour)}
+	return []runtime.Object{
+		&corev1.Namespace{ObjectMeta: gcMeta("", "trivy-temp", NodeCollectorName, 2*time.Hour)},
+		&corev1.Namespace{ObjectMeta: gcMeta("", "trivy-scan", NodeCollectorName, 2*time.Hour)},
+		// the namespace holds a pod of a job of another scanner
+		&corev1.Namespace{ObjectMeta: gcMeta("", "trivy-busy", NodeCollectorName, 2*time.Hour)},
+		&corev1.Namespace{ObjectMeta: gcMeta("", "default", "", 2*time.Hour)},
+		job,
+		gcPod("trivy-temp", "node-collector-1-abcde", job, "Job"),
+		ds,
+		gcPod("trivy-scan", "node-collector-ds-abcde", ds, "DaemonSet"),
+		gcPod("trivy-busy", "scan-abcde", nil, ""),
+		&corev1.ConfigMap{ObjectMeta: gcMeta("trivy-temp", "node-collector-1-result", NodeCollectorName, 2*time.Hour)},
+		&corev1.ServiceAccount{ObjectMeta: gcMeta("trivy-temp", "node-collector-1-result", NodeCollectorName, 2*time.Hour)},
+		&rbacv1.Role{ObjectMeta: gcMeta("trivy-temp", "node-collector-1-result", NodeCollectorName, 2*time.Hour)},
+		&rbacv1.RoleBinding{ObjectMeta: gcMeta("trivy-temp", "node-collector-1-result", NodeCollectorName, 2*time.Hour)},
+		// a running scan
+		&batchv1.Job{ObjectMeta: gcMeta("trivy-temp", "node-collector-2", NodeCollectorName, time.Minute)},
+		// not created by a collector
+		&batchv1.Job{ObjectMeta: gcMeta("default", "backup", "", 2*time.Hour)},
+		&batchv1.Job{ObjectMeta: gcMeta("default", "scan", "other-collector", 2*time.Hour)},
+	}
+}
+
+func TestGarbageCollect(t *testing.T) {
+	collected := []ObjectRef{
+		{Kind: "Job", Namespace: "default", Name: "scan"},
+		{Kind: "Job", Namespace: "trivy-temp", Name: "node-collector-1"},
+		{Kind: "DaemonSet", Namespace: "trivy-scan", Name: "node-collector-ds"},
+		{Kind: "RoleBinding", Namespace: "trivy-temp", Name: "node-collector-1-result"},
+		{Kind: "Role", Namespace: "trivy-temp", Name: "node-collector-1-result"},
+		{Kind: "ServiceAccount", Namespace: "trivy-temp", Name: "node-collector-1-result"},
+		{Kind: "ConfigMap", Namespace: "trivy-temp", Name: "node-collector-1-result"},
+		{Kind: "Namespace", Name: "trivy-scan"},
+		{Kind: "Namespace", Name: "trivy-temp"},
+	}
+	tests := []struct {
+		name        string
+		opts        []GCOption
+		want        []ObjectRef
+		wantDeleted bool
+	}{
+		{
+			name:        "collect",
+			want:        collected,
+			wantDeleted: true,
+		},
+		{
+			name: "dry run",
+			opts: []GCOption{WithGCDryRun(true)},
+			want: collected,
+		},
+		{
+			name: "younger than the threshold",
+			opts: []GCOption{WithGCOlderThan(3 * time.Hour)},
+		},
+		{
+			name: "node-collector",
+			opts: []GCOption{WithGCCollectorName(NodeCollectorName), WithGCDryRun(true)},
+			want: collected[1:],
+		},
+		{
+			name: "another collector",
+			opts: []GCOption{WithGCCollectorName("other-collector")},
+			want: []ObjectRef{{Kind: "Job", Namespace: "default", Name: "scan"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cluster, err := fake.NewCluster(gcObjects(), fake.WithPlatform(k8s.Platform{Name: "k8s"}))
+			require.NoError(t, err)
+
+			got, err := GarbageCollect(ctx, cluster, tt.opts...)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			_, err = cluster.Clientset.BatchV1().Jobs("trivy-temp").Get(ctx, "node-collector-1", metav1.GetOptions{})
+			assert.Equal(t, tt.wantDeleted, err != nil)
+			_, err = cluster.Clientset.CoreV1().Namespaces().Get(ctx, "trivy-temp", metav1.GetOptions{})
+			assert.Equal(t, tt.wantDeleted, err != nil)
+
+			// the running scan, the namespace in use and the resources of others are kept
+			_, err = cluster.Clientset.BatchV1().Jobs("trivy-temp").Get(ctx, "node-collector-2", metav1.GetOptions{})
+			assert.NoError(t, err)
+			_, err = cluster.Clientset.CoreV1().Namespaces().Get(ctx, "trivy-busy", metav1.GetOptions{})
+			assert.NoError(t, err)
+			_, err = cluster.Clientset.BatchV1().Jobs("default").Get(ctx, "backup", metav1.GetOptions{})
+			assert.NoError(t, err)
+		})
+	}
+}
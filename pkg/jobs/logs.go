@@ -63,6 +63,10 @@ func (r *logsReader) getPodByJob(ctx context.Context, job *batchv1.Job) (*corev1
 	if err != nil {
 		return nil, err
 	}
+	// the selector is defaulted by the job controller
+	if refreshedJob.Spec.Selector == nil {
+		return nil, nil
+	}
 	matchingLabelKey := "controller-uid"
 	matchingLabelValue := refreshedJob.Spec.Selector.MatchLabels[matchingLabelKey]
 	if len(matchingLabelValue) == 0 {
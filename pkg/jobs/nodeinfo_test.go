@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/nodeinfo"
+)
+
+// TestNodeInfoFixtures checks the node info fixtures hold a result for each command of their platform
+func TestNodeInfoFixtures(t *testing.T) {
+	commands, _ := loadCommands([]string{"./testdata/fixture"}, AddChecksByPlatform)
+	tests := []struct {
+		platform  string
+		fixture   string
+		wantType  string
+		wantErrs  map[string]string
+		wantValue map[string][]string
+	}{
+		{
+			platform:  "k8s",
+			fixture:   "k8s_node_info.json",
+			wantType:  "master",
+			wantErrs:  map[string]string{},
+			wantValue: map[string][]string{"kubeletConfFilePermissions": {"600"}},
+		},
+		{
+			platform:  "aks",
+			fixture:   "aks_node_info.json",
+			wantType:  "worker",
+			wantErrs:  map[string]string{"kubeletConfFilePermissions": "stat: cannot statx '/var/lib/kubelet/kubeconfig': No such file or directory"},
+			wantValue: map[string][]string{"kubeletConfFilePermissions": {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "fixture", "output", tt.fixture))
+			require.NoError(t, err)
+			nodeInfo, err := nodeinfo.Parse(data)
+			require.NoError(t, err)
+
+			nodeCommands := filterCommandByPlatform(commands, tt.platform)
+			require.NotEmpty(t, nodeCommands.Commands)
+			for _, command := range nodeCommands.Commands {
+				key := command.(map[string]any)["key"].(string)
+				assert.Contains(t, nodeInfo.Info, key)
+			}
+			assert.Equal(t, tt.wantType, nodeInfo.Type)
+			assert.Equal(t, tt.wantErrs, nodeInfo.Errors())
+			for key, want := range tt.wantValue {
+				assert.Equal(t, want, nodeInfo.Info[key].Strings())
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"gopkg.in/yaml.v3"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+)
+
+// platform mapping file names of the embedded and the command path bundles
+var platformMappingFiles = []string{"platform_mapping.yaml", "platform_mapping_cfg.yaml"}
+
+// ErrNoComplianceSpec is returned when no version_mapping rule matches the cluster
+var ErrNoComplianceSpec = errors.New("no compliance spec matches the cluster version")
+
+// errNoPlatformMapping the command bundle has no platform mapping
+var errNoPlatformMapping = errors.New("missing platform mapping")
+
+// VersionMapping selects the compliance spec of the cluster versions matching `op cluster_version`
+type VersionMapping struct {
+	Op             string `yaml:"op"`
+	ClusterVersion string `yaml:"cluster_version"`
+	Spec           string `yaml:"spec"`
+}
+
+// PlatformMapping is the platform_mapping config of the command bundle
+type PlatformMapping struct {
+	VersionMapping map[string][]VersionMapping `yaml:"version_mapping"`
+}
+
+// ComplianceSpec is the compliance spec resolved for a cluster
+type ComplianceSpec struct {
+	ID string
+	// Platform is the version_mapping platform of the spec, its commands are collected
+	Platform string
+}
+
+// ParsePlatformMapping decodes a platform_mapping config, the specs of the rules are normalized to
+// the compliance spec IDs
+func ParsePlatformMapping(data []byte) (*PlatformMapping, error) {
+	var mapping PlatformMapping
+	if err := yaml.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("decoding platform mapping: %w", err)
+	}
+	for _, rules := range mapping.VersionMapping {
+		for i := range rules {
+			rules[i].Spec = complianceSpecID(rules[i].Spec)
+		}
+	}
+	return &mapping, nil
+}
+
+// complianceSpecID returns the compliance spec ID of a version_mapping spec, the node-collector mapping
+// names some specs with a zero patch version, e.g. k8s-cis-1.23.0 for the k8s-cis-1.23 spec
+func complianceSpecID(spec string) string {
+	i := strings.LastIndex(spec, "-")
+	if i < 0 {
+		return spec
+	}
+	version := strings.Split(spec[i+1:], ".")
+	if len(version) != 3 || version[2] != "0" {
+		return spec
+	}
+	return spec[:i+1] + version[0] + "." + version[1]
+}
+
+// ResolveComplianceSpec returns the spec of the platform rules matching the cluster version, the rule with
+// the tightest range wins, see ruleDistance. Platforms without rules use the k8s rules. OpenShift rules are expressed
+// in OpenShift versions (the platform version), other platforms are matched with the server version.
+func (m *PlatformMapping) ResolveComplianceSpec(platform k8s.Platform, serverVersion string) (ComplianceSpec, error) {
+	name := platform.Name
+	rules, ok := m.VersionMapping[name]
+	if !ok {
+		name = "k8s"
+		rules = m.VersionMapping[name]
+	}
+
+	version := serverVersion
+	if platform.Name == "ocp" || version == "" {
+		version = platform.Version
+	}
+	clusterVersion, err := parseVersion(version)
+	if err != nil {
+		return ComplianceSpec{}, fmt.Errorf("parsing cluster version %q: %w", version, err)
+	}
+
+	var spec ComplianceSpec
+	var specDistance [3]uint64
+	for _, rule := range rules {
+		ruleVersion, err := parseVersion(rule.ClusterVersion)
+		if err != nil {
+			return ComplianceSpec{}, fmt.Errorf("parsing %s cluster_version %q: %w", name, rule.ClusterVersion, err)
+		}
+		matched, err := compareVersions(clusterVersion, rule.Op, ruleVersion)
+		if err != nil {
+			return ComplianceSpec{}, fmt.Errorf("%s spec %s: %w", name, rule.Spec, err)
+		}
+		if !matched {
+			continue
+		}
+		distance := ruleDistance(rule.Op, clusterVersion, ruleVersion)
+		if spec.ID == "" || slices.Compare(distance[:], specDistance[:]) < 0 {
+			spec = ComplianceSpec{ID: rule.Spec, Platform: name}
+			specDistance = distance
+		}
+	}
+	if spec.ID == "" {
+		return ComplianceSpec{}, fmt.Errorf("%w: %s %s", ErrNoComplianceSpec, platform.Name, version)
+	}
+	return spec, nil
+}
+
+// ruleDistance returns how far the cluster_version of a matching rule is from the cluster version, the closest
+// bound is the tightest range: the highest of the `>=` versions and the lowest of the `<` versions.
+// `=` rules are the tightest and `!=` rules the loosest, equal distances keep the first rule.
+func ruleDistance(op string, v, ruleVersion semver.Version) [3]uint64 {
+	switch op {
+	case "=", "==":
+		return [3]uint64{}
+	case "!=":
+		return [3]uint64{math.MaxUint64, math.MaxUint64, math.MaxUint64}
+	}
+	diff := func(a, b uint64) uint64 {
+		if a > b {
+			return a - b
+		}
+		return b - a
+	}
+	return [3]uint64{diff(v.Major, ruleVersion.Major), diff(v.Minor, ruleVersion.Minor), diff(v.Patch, ruleVersion.Patch)}
+}
+
+// parseVersion parses versions such as `1.21`, `v1.27.3-eks-a5565ad` or `4.14.3`,
+// pre-release and build suffixes of the distributions are ignored
+func parseVersion(v string) (semver.Version, error) {
+	version, err := semver.ParseTolerant(strings.TrimSpace(v))
+	if err != nil {
+		return semver.Version{}, err
+	}
+	version.Pre = nil
+	version.Build = nil
+	return version, nil
+}
+
+func compareVersions(v semver.Version, op string, other semver.Version) (bool, error) {
+	switch op {
+	case ">=":
+		return v.GTE(other), nil
+	case ">":
+		return v.GT(other), nil
+	case "<=":
+		return v.LTE(other), nil
+	case "<":
+		return v.LT(other), nil
+	case "=", "==":
+		return v.EQ(other), nil
+	case "!=":
+		return v.NE(other), nil
+	default:
+		return false, fmt.Errorf("unsupported op %q", op)
+	}
+}
+
+// resolveComplianceSpec resolves the spec of the cluster from the platform mapping of the command bundle configs
+func (jb *jobCollector) resolveComplianceSpec(configMap map[string]string, platform k8s.Platform) (ComplianceSpec, error) {
+	for _, file := range platformMappingFiles {
+		encoded, ok := configMap[file]
+		if !ok {
+			continue
+		}
+		data, err := decodeAndDecompress(encoded)
+		if err != nil {
+			return ComplianceSpec{}, err
+		}
+		mapping, err := ParsePlatformMapping(data)
+		if err != nil {
+			return ComplianceSpec{}, err
+		}
+		return mapping.ResolveComplianceSpec(platform, jb.cluster.GetClusterVersion())
+	}
+	return ComplianceSpec{}, errNoPlatformMapping
+}
+
+// SpecLoader returns compliance specs by ID, such as the trivy-checks compliance loader
+type SpecLoader interface {
+	GetSpecByName(name string) string
+}
+
+type complianceSpecCommands struct {
+	Spec struct {
+		Controls []struct {
+			Commands []struct {
+				ID string `yaml:"id"`
+			} `yaml:"commands"`
+		} `yaml:"controls"`
+	} `yaml:"spec"`
+}
+
+// specCommandIDs returns the node commands of the compliance spec, nil when the spec is unknown
+func (jb *jobCollector) specCommandIDs(specID string) []string {
+	if jb.specLoader == nil {
+		return nil
+	}
+	data := jb.specLoader.GetSpecByName(specID)
+	if data == "" {
+		return nil
+	}
+	ids, err := parseSpecCommandIDs([]byte(data))
+	if err != nil {
+		slog.Warn("Unable to parse compliance spec", "spec", specID, "error", err)
+		return nil
+	}
+	return ids
+}
+
+func parseSpecCommandIDs(data []byte) ([]string, error) {
+	var spec complianceSpecCommands
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, control := range spec.Spec.Controls {
+		for _, command := range control.Commands {
+			if _, ok := seen[command.ID]; ok || command.ID == "" {
+				continue
+			}
+			seen[command.ID] = struct{}{}
+			ids = append(ids, command.ID)
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,182 @@
+package jobs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+)
+
+func TestResolveComplianceSpec(t *testing.T) {
+	data, err := os.ReadFile("./testdata/fixture/commands/config/platform_mapping_cfg.yaml")
+	require.NoError(t, err)
+	mapping, err := ParsePlatformMapping(data)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		mapping       *PlatformMapping
+		platform      k8s.Platform
+		serverVersion string
+		want          ComplianceSpec
+		wantErr       string
+	}{
+		{
+			name:          "k8s",
+			mapping:       mapping,
+			platform:      k8s.Platform{Name: "k8s", Version: "1.29"},
+			serverVersion: "v1.29.1",
+			want:          ComplianceSpec{ID: "k8s-cis-1.23", Platform: "k8s"},
+		},
+		{
+			name:          "eks with distribution suffix",
+			mapping:       mapping,
+			platform:      k8s.Platform{Name: "eks", Version: "1.27"},
+			serverVersion: "v1.27.3-eks-a5565ad",
+			want:          ComplianceSpec{ID: "eks-cis-1.2", Platform: "eks"},
+		},
+		{
+			name:          "openshift matched with the platform version",
+			mapping:       mapping,
+			platform:      k8s.Platform{Name: "ocp", Version: "4.14"},
+			serverVersion: "v1.27.6+b49f9d1",
+			want:          ComplianceSpec{ID: "rh-cis-1.0", Platform: "ocp"},
+		},
+		{
+			name:          "platform without rules uses the k8s rules",
+			mapping:       mapping,
+			platform:      k8s.Platform{Name: "k3s", Version: "1.27"},
+			serverVersion: "v1.27.4+k3s1",
+			want:          ComplianceSpec{ID: "k8s-cis-1.23", Platform: "k8s"},
+		},
+		{
+			name:          "cluster version below every rule",
+			mapping:       mapping,
+			platform:      k8s.Platform{Name: "gke", Version: "1.20"},
+			serverVersion: "v1.20.15-gke.100",
+			wantErr:       ErrNoComplianceSpec.Error(),
+		},
+		{
+			name: "highest matching cluster version wins",
+			mapping: &PlatformMapping{VersionMapping: map[string][]VersionMapping{"k8s": {
+				{Op: ">=", ClusterVersion: "1.21", Spec: "k8s-cis-1.23.0"},
+				{Op: ">=", ClusterVersion: "1.28", Spec: "k8s-cis-1.28.0"},
+				{Op: "<", ClusterVersion: "1.21", Spec: "k8s-cis-1.20.0"},
+			}}},
+			platform:      k8s.Platform{Name: "k8s", Version: "1.29"},
+			serverVersion: "v1.29.1",
+			want:          ComplianceSpec{ID: "k8s-cis-1.28.0", Platform: "k8s"},
+		},
+		{
+			name: "lowest matching upper bound wins",
+			mapping: &PlatformMapping{VersionMapping: map[string][]VersionMapping{"k8s": {
+				{Op: "<", ClusterVersion: "1.30", Spec: "k8s-cis-1.24"},
+				{Op: "<", ClusterVersion: "1.25", Spec: "k8s-cis-1.20"},
+				{Op: "<", ClusterVersion: "1.27", Spec: "k8s-cis-1.23"},
+			}}},
+			platform:      k8s.Platform{Name: "k8s", Version: "1.24"},
+			serverVersion: "v1.24.17",
+			want:          ComplianceSpec{ID: "k8s-cis-1.20", Platform: "k8s"},
+		},
+		{
+			name: "tightest of lower and upper bounds wins",
+			mapping: &PlatformMapping{VersionMapping: map[string][]VersionMapping{"k8s": {
+				{Op: ">=", ClusterVersion: "1.21", Spec: "k8s-cis-1.23"},
+				{Op: "<", ClusterVersion: "1.27", Spec: "k8s-cis-1.24"},
+				{Op: "!=", ClusterVersion: "1.22", Spec: "k8s-cis-1.20"},
+			}}},
+			platform:      k8s.Platform{Name: "k8s", Version: "1.26"},
+			serverVersion: "v1.26.3",
+			want:          ComplianceSpec{ID: "k8s-cis-1.24", Platform: "k8s"},
+		},
+		{
+			name: "unsupported op",
+			mapping: &PlatformMapping{VersionMapping: map[string][]VersionMapping{"k8s": {
+				{Op: "~>", ClusterVersion: "1.21", Spec: "k8s-cis-1.23.0"},
+			}}},
+			platform:      k8s.Platform{Name: "k8s", Version: "1.29"},
+			serverVersion: "v1.29.1",
+			wantErr:       `unsupported op "~>"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.mapping.ResolveComplianceSpec(tt.platform, tt.serverVersion)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestComplianceSpecID(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{spec: "k8s-cis-1.23.0", want: "k8s-cis-1.23"},
+		{spec: "k8s-cis-1.23", want: "k8s-cis-1.23"},
+		{spec: "k8s-cis-1.23.1", want: "k8s-cis-1.23.1"},
+		{spec: "rh-cis-1.0", want: "rh-cis-1.0"},
+		{spec: "custom", want: "custom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			assert.Equal(t, tt.want, complianceSpecID(tt.spec))
+		})
+	}
+}
+
+type specLoaderStub map[string]string
+
+func (s specLoaderStub) GetSpecByName(name string) string {
+	return s[name]
+}
+
+func TestSpecCommandIDs(t *testing.T) {
+	loader := specLoaderStub{"k8s-cis-1.23": `
+spec:
+  id: k8s-cis-1.23
+  platform: k8s
+  controls:
+    - id: 1.1.1
+      commands:
+        - id: CMD-0001
+    - id: 1.1.2
+      commands:
+        - id: CMD-0001
+        - id: CMD-0002
+    - id: 5.1.1
+`}
+	tests := []struct {
+		name   string
+		loader SpecLoader
+		specID string
+		want   []string
+	}{
+		{name: "spec id", loader: loader, specID: "k8s-cis-1.23", want: []string{"CMD-0001", "CMD-0002"}},
+		{name: "unknown spec", loader: loader, specID: "eks-cis-1.2", want: nil},
+		{name: "no loader", specID: "k8s-cis-1.23", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jb := &jobCollector{specLoader: tt.loader}
+			assert.Equal(t, tt.want, jb.specCommandIDs(tt.specID))
+		})
+	}
+}
+
+func TestDecodeAndDecompress(t *testing.T) {
+	data := []byte("version_mapping:\n  k8s: []\n")
+	encoded, err := compressAndEncode(data)
+	require.NoError(t, err)
+	got, err := decodeAndDecompress(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
@@ -2,14 +2,17 @@ package jobs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
@@ -18,10 +21,12 @@ import (
 
 var defaultResyncDuration = 30 * time.Minute
 
+// jobNameLabel is set by the job controller on the pods of a job
+const jobNameLabel = "job-name"
+
 type runnableJob struct {
-	clientset  kubernetes.Interface
-	logsReader LogsReader
-	job        *batchv1.Job // job to be run
+	clientset kubernetes.Interface
+	job       *batchv1.Job // job to be run
 }
 
 // NewRunnableJob constructs a new Runnable task defined as Kubernetes
@@ -30,92 +35,220 @@ func NewRunnableJob(
 	job *batchv1.Job,
 ) Runnable {
 	return &runnableJob{
-		clientset:  clientset,
-		logsReader: NewLogsReader(clientset),
-		job:        job,
+		clientset: clientset,
+		job:       job,
 	}
 }
 
 // Run runs synchronously the task as Kubernetes job.
-// This method blocks and waits for the job completion or failure.
+// This method blocks and waits for the job completion or failure, or for the context to be done.
+// The informers watching the job are stopped before it returns.
 func (r *runnableJob) Run(ctx context.Context) error {
 	var err error
 	r.job, err = r.clientset.BatchV1().Jobs(r.job.Namespace).Create(ctx, r.job, metav1.CreateOptions{})
 	if err != nil {
 		return err
 	}
-	informerFactory := informers.NewSharedInformerFactoryWithOptions(
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// only the first terminal outcome is kept, the handlers never block
+	complete := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case complete <- err:
+		default:
+		}
+	}
+
+	jobsFactory := informers.NewSharedInformerFactoryWithOptions(
+		r.clientset,
+		defaultResyncDuration,
+		informers.WithNamespace(r.job.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector(metav1.ObjectNameField, r.job.Name).String()
+			if len(r.job.Labels) > 0 {
+				options.LabelSelector = labels.SelectorFromSet(r.job.Labels).String()
+			}
+		}),
+	)
+	eventsFactory := informers.NewSharedInformerFactoryWithOptions(
+		r.clientset,
+		defaultResyncDuration,
+		informers.WithNamespace(r.job.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("involvedObject.uid", string(r.job.UID)).String()
+		}),
+	)
+	podsFactory := informers.NewSharedInformerFactoryWithOptions(
 		r.clientset,
 		defaultResyncDuration,
 		informers.WithNamespace(r.job.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labels.SelectorFromSet(labels.Set{jobNameLabel: r.job.Name}).String()
+		}),
 	)
-	jobsInformer := informerFactory.Batch().V1().Jobs()
-	complete := make(chan error)
+	defer func() {
+		cancel()
+		// Shutdown waits for the informer goroutines to return
+		jobsFactory.Shutdown()
+		eventsFactory.Shutdown()
+		podsFactory.Shutdown()
+	}()
 
-	_, err = jobsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		UpdateFunc: func(_, newObj interface{}) {
-			newJob, ok := newObj.(*batchv1.Job)
-			if !ok {
+	onJob := func(obj interface{}) {
+		job, ok := obj.(*batchv1.Job)
+		if !ok || r.job.UID != job.UID {
+			return
+		}
+		for _, condition := range job.Status.Conditions {
+			switch condition.Type {
+			case batchv1.JobComplete, batchv1.JobSuccessCriteriaMet:
+				report(nil)
 				return
-			}
-			if r.job.UID != newJob.UID {
+			case batchv1.JobFailed:
+				report(fmt.Errorf("job failed: %s: %s", condition.Reason, condition.Message))
 				return
 			}
-			for _, condition := range newJob.Status.Conditions {
-				switch condition.Type {
-				case batchv1.JobComplete, batchv1.JobSuccessCriteriaMet:
-					complete <- nil
-					return
-				case batchv1.JobFailed:
-					complete <- fmt.Errorf("job failed: %s: %s", condition.Reason, condition.Message)
-					return
-				}
-			}
+		}
+	}
+	// the job may complete before the informer lists it
+	_, err = jobsFactory.Batch().V1().Jobs().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onJob,
+		UpdateFunc: func(_, newObj interface{}) {
+			onJob(newObj)
 		},
 	})
 	if err != nil {
 		return err
 	}
-	eventsInformer := informerFactory.Core().V1().Events()
-	_, err = eventsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	_, err = eventsFactory.Core().V1().Events().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			event := obj.(*corev1.Event)
-			if event.InvolvedObject.UID != r.job.UID {
+			event, ok := obj.(*corev1.Event)
+			if !ok || event.InvolvedObject.UID != r.job.UID {
 				return
 			}
-
-			if event.Type == corev1.EventTypeWarning {
-				complete <- fmt.Errorf("warning event received: %s (%s)", event.Message, event.Reason)
+			if event.Type != corev1.EventTypeWarning {
+				return
+			}
+			if podErr := admissionDeniedError(event); podErr != nil {
+				report(podErr)
 				return
 			}
+			report(fmt.Errorf("warning event received: %s (%s)", event.Message, event.Reason))
 		},
 	})
 	if err != nil {
 		return err
 	}
-	informerFactory.Start(wait.NeverStop)
-	informerFactory.WaitForCacheSync(wait.NeverStop)
-
-	err = <-complete
-
+	// pods stuck in a state they do not recover from fail the job before its deadline,
+	// pods which may still recover are checked again once their grace period is over
+	podInformer := podsFactory.Core().V1().Pods().Informer()
+	var recheckMu sync.Mutex
+	var recheck *time.Timer
+	var stopped bool
+	defer func() {
+		recheckMu.Lock()
+		defer recheckMu.Unlock()
+		stopped = true
+		if recheck != nil {
+			recheck.Stop()
+		}
+	}()
+	var onPod func(obj interface{})
+	onPod = func(obj interface{}) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok || !metav1.IsControlledBy(pod, r.job) {
+			return
+		}
+		podErr, wait := stuckPodError(pod, time.Now())
+		if podErr != nil {
+			report(podErr)
+			return
+		}
+		if wait <= 0 {
+			return
+		}
+		recheckMu.Lock()
+		defer recheckMu.Unlock()
+		if stopped {
+			return
+		}
+		if recheck != nil {
+			recheck.Stop()
+		}
+		recheck = time.AfterFunc(wait, func() {
+			if obj, exists, err := podInformer.GetStore().Get(pod); err == nil && exists {
+				onPod(obj)
+			}
+		})
+	}
+	_, err = podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onPod,
+		UpdateFunc: func(_, newObj interface{}) {
+			onPod(newObj)
+		},
+	})
 	if err != nil {
-		r.logTerminatedContainersErrors(ctx)
+		return err
 	}
+	jobsFactory.Start(ctx.Done())
+	eventsFactory.Start(ctx.Done())
+	podsFactory.Start(ctx.Done())
 
-	return err
-}
-
-func (r *runnableJob) logTerminatedContainersErrors(ctx context.Context) {
-	statuses, err := r.logsReader.GetTerminatedContainersStatusesByJob(ctx, r.job)
+	select {
+	case err = <-complete:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error while getting terminated containers statuses for job %q", r.job.Namespace+"/"+r.job.Name))
+		return r.podError(ctx, err)
 	}
+	return nil
+}
 
-	for _, status := range statuses {
-		if status.ExitCode == 0 {
-			continue
+// podError adds the exit code and termination message of the failed container and the warning events
+// of the pod to the job failure
+func (r *runnableJob) podError(ctx context.Context, err error) error {
+	var podErr *PodError
+	if !errors.As(err, &podErr) {
+		podErr = &PodError{Err: err}
+		pod, podErrLookup := (&logsReader{clientset: r.clientset}).getPodByJob(ctx, r.job)
+		if podErrLookup != nil {
+			slog.Error(fmt.Sprintf("Error while getting terminated containers statuses for job %q", r.job.Namespace+"/"+r.job.Name), "error", podErrLookup)
+		}
+		if pod != nil {
+			podErr.Pod = pod.Name
+		}
+		for name, status := range GetTerminatedContainersStatusesByPod(pod) {
+			if status.ExitCode == 0 {
+				continue
+			}
+			podErr.Container = name
+			podErr.Reason = status.Reason
+			podErr.ExitCode = status.ExitCode
+			podErr.TerminationMessage = status.Message
+			if status.Reason == "OOMKilled" {
+				podErr.Err = fmt.Errorf("%w: %w", ErrOOMKilled, err)
+			}
+			break
+		}
+	}
+	if podErr.Pod == "" {
+		return podErr
+	}
+	events, eventsErr := r.clientset.CoreV1().Events(r.job.Namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.Set{"involvedObject.kind": "Pod", "involvedObject.name": podErr.Pod}.String(),
+	})
+	if eventsErr != nil {
+		return podErr
+	}
+	for _, event := range events.Items {
+		if event.Type == corev1.EventTypeWarning {
+			podErr.Events = append(podErr.Events, event.Message)
 		}
 	}
+	return podErr
 }
 
 func GetActiveDeadlineSeconds(d time.Duration) *int64 {
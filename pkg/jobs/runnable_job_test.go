@@ -0,0 +1,254 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newRunnableJobTest(uid types.UID, conditions ...batchv1.JobCondition) (*fake.Clientset, *batchv1.Job) {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "trivy-temp",
+			Name:      "node-collector-" + string(uid),
+			UID:       uid,
+			Labels:    map[string]string{TrivyCollectorName: NodeCollectorName},
+		},
+	}
+	clientset := fake.NewClientset()
+	// the job controller sets the status of the job once it is created
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		created := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		created.Status.Conditions = conditions
+		return false, nil, nil
+	})
+	return clientset, job
+}
+
+func TestRunnableJob(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	tests := []struct {
+		name       string
+		conditions []batchv1.JobCondition
+		events     []*corev1.Event
+		wantErr    string
+	}{
+		{
+			name:       "complete",
+			conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}},
+		},
+		{
+			name:       "failed",
+			conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "DeadlineExceeded", Message: "Job was active longer than specified deadline"}},
+			wantErr:    "job failed: DeadlineExceeded: Job was active longer than specified deadline",
+		},
+		{
+			name: "first outcome",
+			events: []*corev1.Event{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "trivy-temp", Name: "warning-1"}, Type: corev1.EventTypeWarning, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "trivy-temp", Name: "warning-2"}, Type: corev1.EventTypeWarning, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: "trivy-temp", Name: "warning-3"}, Type: corev1.EventTypeWarning, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"},
+			},
+			wantErr: "warning event received: Job has reached the specified backoff limit (BackoffLimitExceeded)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset, job := newRunnableJobTest("0f2c", tt.conditions...)
+			for _, event := range tt.events {
+				event.InvolvedObject.UID = job.UID
+				_, err := clientset.CoreV1().Events(event.Namespace).Create(context.Background(), event, metav1.CreateOptions{})
+				require.NoError(t, err)
+			}
+			err := NewRunnableJob(clientset, job).Run(context.Background())
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRunnableJobCancel(t *testing.T) {
+	ignore := goleak.IgnoreCurrent()
+	defer goleak.VerifyNone(t, ignore)
+
+	for i := 0; i < 20; i++ {
+		clientset, job := newRunnableJobTest(types.UID(fmt.Sprint(i)))
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := New(WithTimeout(time.Minute)).Run(ctx, NewRunnableJob(clientset, job))
+		cancel()
+		// the deadline of the caller is earlier than the timeout of the runner
+		assert.Equal(t, context.DeadlineExceeded, err)
+	}
+	// the runner returns once the context is done, the job informers stop right after
+	assert.Eventually(t, func() bool {
+		return goleak.Find(ignore) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+// jobPod returns the pod of the job with the container status
+func jobPod(job *batchv1.Job, status corev1.ContainerStatus, conditions ...corev1.PodCondition) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       job.Namespace,
+			Name:            job.Name + "-x7k2p",
+			UID:             "pod-" + job.UID,
+			Labels:          map[string]string{jobNameLabel: job.Name},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(job, batchv1.SchemeGroupVersion.WithKind("Job"))},
+		},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodPending,
+			Conditions:        conditions,
+			ContainerStatuses: []corev1.ContainerStatus{status},
+		},
+	}
+}
+
+func TestRunnableJobStuckPod(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	tests := []struct {
+		name       string
+		status     corev1.ContainerStatus
+		conditions []corev1.PodCondition
+		jobEvents  []*corev1.Event
+		jobFailed  bool
+		podEvents  []string
+		wantErr    error
+		wantPodErr PodError
+	}{
+		{
+			name: "image pull",
+			status: corev1.ContainerStatus{Name: NodeCollectorName, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+				Reason: "ImagePullBackOff", Message: `Back-off pulling image "ghcr.io/aquasecurity/node-collector:0.3.0"`,
+			}}},
+			podEvents: []string{"Failed to pull image: unauthorized"},
+			wantErr:   ErrImagePull,
+			wantPodErr: PodError{Container: NodeCollectorName, Reason: "ImagePullBackOff", Message: `Back-off pulling image "ghcr.io/aquasecurity/node-collector:0.3.0"`,
+				Events: []string{"Failed to pull image: unauthorized"}},
+		},
+		{
+			name: "container config",
+			status: corev1.ContainerStatus{Name: NodeCollectorName, State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+				Reason: "CreateContainerConfigError", Message: `secret "registry" not found`,
+			}}},
+			wantErr:    ErrCreateContainerConfig,
+			wantPodErr: PodError{Container: NodeCollectorName, Reason: "CreateContainerConfigError", Message: `secret "registry" not found`},
+		},
+		{
+			name: "unschedulable",
+			// fails once the grace period is over, without further update of the pod
+			conditions: []corev1.PodCondition{{
+				Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable,
+				Message: "0/3 nodes are available: 3 node(s) had untolerated taint", LastTransitionTime: metav1.Now(),
+			}},
+			wantErr:    ErrUnschedulable,
+			wantPodErr: PodError{Reason: corev1.PodReasonUnschedulable, Message: "0/3 nodes are available: 3 node(s) had untolerated taint"},
+		},
+		{
+			name: "OOM killed",
+			status: corev1.ContainerStatus{Name: NodeCollectorName, State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				Reason: "OOMKilled", ExitCode: 137, Message: "out of memory",
+			}}},
+			wantErr:    ErrOOMKilled,
+			wantPodErr: PodError{Container: NodeCollectorName, Reason: "OOMKilled", ExitCode: 137, TerminationMessage: "out of memory"},
+		},
+		{
+			name: "failed container",
+			status: corev1.ContainerStatus{Name: NodeCollectorName, State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				Reason: "Error", ExitCode: 2, Message: "unknown flag: --result-transport",
+			}}},
+			jobFailed:  true,
+			wantPodErr: PodError{Container: NodeCollectorName, Reason: "Error", ExitCode: 2, TerminationMessage: "unknown flag: --result-transport"},
+		},
+		{
+			name: "admission denied",
+			jobEvents: []*corev1.Event{{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "trivy-temp", Name: "failed-create"},
+				Type:       corev1.EventTypeWarning,
+				Reason:     "FailedCreate",
+				Message:    `Error creating: admission webhook "validation.gatekeeper.sh" denied the request: privileged pods are not allowed`,
+			}},
+			wantErr: ErrAdmissionDenied,
+			wantPodErr: PodError{Reason: "FailedCreate", Message: `Error creating: admission webhook "validation.gatekeeper.sh" denied the request: privileged pods are not allowed`,
+				Events: []string{`Error creating: admission webhook "validation.gatekeeper.sh" denied the request: privileged pods are not allowed`}},
+		},
+	}
+	gracePeriod := unschedulableGracePeriod
+	unschedulableGracePeriod = 200 * time.Millisecond
+	defer func() { unschedulableGracePeriod = gracePeriod }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conditions []batchv1.JobCondition
+			if tt.jobFailed {
+				conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded", Message: "Job has reached the specified backoff limit"}}
+			}
+			clientset, job := newRunnableJobTest("0f2c", conditions...)
+			job.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"batch.kubernetes.io/controller-uid": string(job.UID)}}
+			ctx := context.Background()
+			if tt.jobEvents == nil {
+				pod := jobPod(job, tt.status, tt.conditions...)
+				pod.Labels["batch.kubernetes.io/controller-uid"] = string(job.UID)
+				_, err := clientset.CoreV1().Pods(job.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+				require.NoError(t, err)
+				tt.wantPodErr.Pod = pod.Name
+				for i, message := range tt.podEvents {
+					event := &corev1.Event{
+						ObjectMeta:     metav1.ObjectMeta{Namespace: job.Namespace, Name: fmt.Sprintf("pod-event-%d", i)},
+						InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod.Name, UID: pod.UID},
+						Type:           corev1.EventTypeWarning,
+						Message:        message,
+					}
+					_, err := clientset.CoreV1().Events(job.Namespace).Create(ctx, event, metav1.CreateOptions{})
+					require.NoError(t, err)
+				}
+			}
+			for _, event := range tt.jobEvents {
+				event.InvolvedObject.UID = job.UID
+				_, err := clientset.CoreV1().Events(event.Namespace).Create(ctx, event, metav1.CreateOptions{})
+				require.NoError(t, err)
+			}
+
+			// fails well before the deadline of the job
+			err := New(WithTimeout(10*time.Second)).Run(ctx, NewRunnableJob(clientset, job))
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+			var podErr *PodError
+			require.ErrorAs(t, err, &podErr)
+			podErr.Err = nil
+			assert.Equal(t, tt.wantPodErr, *podErr)
+		})
+	}
+}
+
+func TestPodErrorMessage(t *testing.T) {
+	err := &PodError{
+		Err:                ErrOOMKilled,
+		Pod:                "node-collector-0f2c-x7k2p",
+		Container:          NodeCollectorName,
+		Reason:             "OOMKilled",
+		ExitCode:           137,
+		TerminationMessage: "out of memory",
+	}
+	assert.EqualError(t, err, "container OOM killed: pod node-collector-0f2c-x7k2p container node-collector: OOMKilled (exit code 137): out of memory")
+	assert.Equal(t, NodeFailureJobFailed, runFailureReason(err))
+	assert.Equal(t, NodeFailureUnschedulable, runFailureReason(&PodError{Err: ErrUnschedulable}))
+}
@@ -35,7 +35,6 @@ type Runner interface {
 // New constructs a new ready-to-use Runner for running a Runnable task.
 func New(opts ...RunnerOption) Runner {
 	r := &runner{
-		complete:        make(chan error),
 		timeoutDuration: 0,
 	}
 	for _, opt := range opts {
@@ -53,41 +52,38 @@ func WithTimeout(timeout time.Duration) RunnerOption {
 }
 
 type runner struct {
-	// complete channel reports that processing is done
-	complete chan error
 	// timeout duration
 	timeoutDuration time.Duration
 }
 
-// Run runs the specified task and monitors channel events.
+// Run runs the specified task and waits for its result. The context of the task is cancelled when Run
+// returns, on timeout or cancellation the task is expected to stop on its own.
+// The task runs until the earlier of the timeout and the deadline of the caller, ErrTimeout is returned
+// when the timeout is reached first and the error of the caller context otherwise.
 func (r *runner) Run(ctx context.Context, task Runnable) error {
-	go func() {
-		r.complete <- task.Run(ctx)
-	}()
+	parent := ctx
+	var cancel context.CancelFunc
 	if r.timeoutDuration > 0 {
-		return r.runWithTimeout(ctx)
-	}
-	return r.runAndWaitForever()
-
-}
-
-func (r *runner) runAndWaitForever() error {
-	return <-r.complete
-}
-
-func (r *runner) runWithTimeout(ctx context.Context) error {
-	// context timeout also can be set on caller side
-	if _, ok := ctx.Deadline(); !ok {
-		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, r.timeoutDuration)
-		defer cancel()
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
 	}
+	defer cancel()
+
+	// buffered so that the task goroutine exits even when nobody waits for its result
+	complete := make(chan error, 1)
+	go func() {
+		complete <- task.Run(ctx)
+	}()
 	select {
 	// Signaled when processing is done.
-	case err := <-r.complete:
+	case err := <-complete:
 		return err
-	// Signaled when we run out of time.
+	// Signaled when we run out of time or the caller gives up.
 	case <-ctx.Done():
+		if err := parent.Err(); err != nil {
+			return err
+		}
 		return ErrTimeout
 	}
 }
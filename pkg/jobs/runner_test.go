@@ -0,0 +1,106 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestRunner(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	// blocking waits for the cancellation of its context
+	blocking := RunnableFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	tests := []struct {
+		name     string
+		opts     []RunnerOption
+		cancel   time.Duration
+		deadline time.Duration
+		task     Runnable
+		wantErr  error
+	}{
+		{
+			name: "completed",
+			task: RunnableFunc(func(context.Context) error { return nil }),
+		},
+		{
+			name:    "failed",
+			task:    RunnableFunc(func(context.Context) error { return errors.New("job failed") }),
+			wantErr: errors.New("job failed"),
+		},
+		{
+			name:    "timeout",
+			opts:    []RunnerOption{WithTimeout(10 * time.Millisecond)},
+			task:    blocking,
+			wantErr: ErrTimeout,
+		},
+		{
+			name:     "timeout before the deadline of the caller",
+			opts:     []RunnerOption{WithTimeout(10 * time.Millisecond)},
+			deadline: time.Minute,
+			task:     blocking,
+			wantErr:  ErrTimeout,
+		},
+		{
+			name:     "deadline of the caller before the timeout",
+			opts:     []RunnerOption{WithTimeout(time.Minute)},
+			deadline: 10 * time.Millisecond,
+			task:     blocking,
+			wantErr:  context.DeadlineExceeded,
+		},
+		{
+			name:    "cancelled",
+			cancel:  10 * time.Millisecond,
+			task:    blocking,
+			wantErr: context.Canceled,
+		},
+		{
+			name: "task ignoring its context",
+			opts: []RunnerOption{WithTimeout(10 * time.Millisecond)},
+			task: RunnableFunc(func(context.Context) error {
+				time.Sleep(50 * time.Millisecond)
+				return nil
+			}),
+			wantErr: ErrTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.deadline > 0 {
+				ctx, cancel = context.WithTimeout(ctx, tt.deadline)
+				defer cancel()
+			}
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+			err := New(tt.opts...).Run(ctx, tt.task)
+			assert.Equal(t, tt.wantErr, err)
+		})
+	}
+	// the goroutine of the task ignoring its context exits once it returns
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestRunnerNoLeak(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	started := make(chan struct{}, 100)
+	for i := 0; i < 100; i++ {
+		err := New(WithTimeout(time.Millisecond)).Run(context.Background(), RunnableFunc(func(ctx context.Context) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		}))
+		assert.Equal(t, ErrTimeout, err)
+	}
+	assert.Len(t, started, 100)
+}
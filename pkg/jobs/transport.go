@@ -0,0 +1,253 @@
+package jobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// resultTransport defines how the node-collector hands its output back. The transports other than the logs
+// are not exposed until a node-collector release implements --result-transport and the result envelope.
+type resultTransport string
+
+const (
+	// resultTransportLogs read the output from the container logs, the default
+	resultTransportLogs resultTransport = "logs"
+	// resultTransportTerminationMessage read the enveloped output from the termination message of the
+	// container, the kubelet limits it to 4096 bytes
+	resultTransportTerminationMessage resultTransport = "termination-message"
+	// resultTransportConfigMap read the enveloped output from a ConfigMap the node-collector writes with a
+	// short-lived token only allowed to update that ConfigMap
+	resultTransportConfigMap resultTransport = "configmap"
+)
+
+// nodeCollectorImageRef is the node-collector image of the job template, it does not implement
+// --result-transport and only sends its output by the logs
+const nodeCollectorImageRef = "ghcr.io/aquasecurity/node-collector:0.3.0"
+
+const (
+	resultEnvelopeEncoding = "bzip2+base64"
+	resultConfigMapKey     = "result"
+	resultTokenVolume      = "result-token"
+	terminationMessagePath = "/dev/termination-log"
+	// terminationMessageMaxLength is the size the kubelet truncates the termination message to
+	terminationMessageMaxLength = 4096
+	// resultTokenExpiration is the lifetime of the token writing the result ConfigMap in seconds,
+	// the minimum accepted by the API server
+	resultTokenExpiration   int64 = 600
+	serviceAccountMountPath       = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+var (
+	// errResultChecksum is returned when the output does not match the checksum of its envelope
+	errResultChecksum = errors.New("node-collector output checksum mismatch")
+	// errResultTransportUnsupported is returned for a transport other than the logs with the node-collector
+	// image of the template, which does not implement it
+	errResultTransportUnsupported = errors.New("result transport not supported by the node-collector image")
+)
+
+// resultEnvelope wraps the node-collector output sent by the termination message and ConfigMap transports
+type resultEnvelope struct {
+	// Encoding is the encoding of Data, bzip2 compressed and base64 encoded like the collector args
+	Encoding string `json:"encoding"`
+	// Checksum is the hex encoded sha256 of the output
+	Checksum string `json:"sha256"`
+	Data     string `json:"data"`
+}
+
+// newResultEnvelope compress the output and compute its checksum
+func newResultEnvelope(output []byte) (*resultEnvelope, error) {
+	data, err := compressAndEncode(output)
+	if err != nil {
+		return nil, err
+	}
+	checksum := sha256.Sum256(output)
+	return &resultEnvelope{
+		Encoding: resultEnvelopeEncoding,
+		Checksum: hex.EncodeToString(checksum[:]),
+		Data:     data,
+	}, nil
+}
+
+// decodeResultEnvelope returns the output of the JSON encoded envelope once its checksum is verified
+func decodeResultEnvelope(b []byte) ([]byte, error) {
+	var envelope resultEnvelope
+	if err := json.Unmarshal(b, &envelope); err != nil {
+		return nil, fmt.Errorf("decoding result envelope: %w", err)
+	}
+	if envelope.Encoding != resultEnvelopeEncoding {
+		return nil, fmt.Errorf("unsupported result envelope encoding %q", envelope.Encoding)
+	}
+	output, err := decodeAndDecompress(envelope.Data)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing result envelope: %w", err)
+	}
+	checksum := sha256.Sum256(output)
+	if hex.EncodeToString(checksum[:]) != envelope.Checksum {
+		return nil, errResultChecksum
+	}
+	return output, nil
+}
+
+// checkResultTransport returns an error for a transport the node-collector image does not implement, the
+// transports other than the logs need the image of a node-collector release implementing --result-transport
+func checkResultTransport(transport resultTransport, imageRef string) error {
+	if !transport.enveloped() {
+		return nil
+	}
+	if imageRef == "" || imageRef == nodeCollectorImageRef {
+		return fmt.Errorf("%w: %q needs a node-collector image implementing --result-transport, %s does not",
+			errResultTransportUnsupported, transport, nodeCollectorImageRef)
+	}
+	return nil
+}
+
+// enveloped returns true when the output sent by the transport is a resultEnvelope
+func (t resultTransport) enveloped() bool {
+	return t != "" && t != resultTransportLogs
+}
+
+// truncatedTerminationMessage returns true when the kubelet may have truncated the termination message, the
+// output is then read from the logs
+func truncatedTerminationMessage(message string) bool {
+	return len(message) >= terminationMessageMaxLength
+}
+
+// resultConfigMapName returns the name of the ConfigMap and of the service account writing the result of the job
+func resultConfigMapName(jobName string) string {
+	return jobName + "-result"
+}
+
+// readResult returns the enveloped output of the completed job sent by the transport of the collector
+func (jb *jobCollector) readResult(ctx context.Context, job *batchv1.Job) ([]byte, error) {
+	switch jb.resultTransport {
+	case resultTransportTerminationMessage:
+		statuses, err := jb.logsReader.GetTerminatedContainersStatusesByJob(ctx, job)
+		if err != nil {
+			return nil, fmt.Errorf("getting termination message: %w", err)
+		}
+		terminated, ok := statuses[NodeCollectorName]
+		if !ok || terminated.Message == "" {
+			return nil, errors.New("node-collector wrote no termination message")
+		}
+		return []byte(terminated.Message), nil
+	case resultTransportConfigMap:
+		cm, err := jb.cluster.GetK8sClientSet().CoreV1().ConfigMaps(job.Namespace).Get(ctx, resultConfigMapName(job.Name), metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("getting result configmap: %w", err)
+		}
+		result, ok := cm.Data[resultConfigMapKey]
+		if !ok {
+			return nil, errors.New("node-collector wrote no result to the configmap")
+		}
+		return []byte(result), nil
+	}
+	return nil, fmt.Errorf("unsupported result transport %q", jb.resultTransport)
+}
+
+// createResultConfigMap creates the ConfigMap the job writes its result to and a role only allowed to update
+// it, bound to the service account of the job pod, created along when it is the one set by applyResultTransport.
+// The returned func deletes them.
+func (jb *jobCollector) createResultConfigMap(ctx context.Context, job *batchv1.Job) (func(), error) {
+	name := resultConfigMapName(job.Name)
+	meta := metav1.ObjectMeta{Name: name, Namespace: jb.namespace, Labels: jb.labels}
+	serviceAccount := job.Spec.Template.Spec.ServiceAccountName
+	if serviceAccount == name {
+		serviceAccount = ""
+	}
+	clientset := jb.cluster.GetK8sClientSet()
+	cleanup := func() {
+		ctx := context.WithoutCancel(ctx)
+		_ = clientset.RbacV1().RoleBindings(jb.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		_ = clientset.RbacV1().Roles(jb.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		if serviceAccount == "" {
+			_ = clientset.CoreV1().ServiceAccounts(jb.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		}
+		_ = clientset.CoreV1().ConfigMaps(jb.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	}
+
+	if _, err := clientset.CoreV1().ConfigMaps(jb.namespace).Create(ctx, &corev1.ConfigMap{ObjectMeta: meta}, metav1.CreateOptions{}); err != nil {
+		return nil, err
+	}
+	subject := serviceAccount
+	if subject == "" {
+		subject = name
+		sa := &corev1.ServiceAccount{ObjectMeta: meta, AutomountServiceAccountToken: ptr.To(false)}
+		if _, err := clientset.CoreV1().ServiceAccounts(jb.namespace).Create(ctx, sa, metav1.CreateOptions{}); err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+	role := &rbacv1.Role{
+		ObjectMeta: meta,
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			ResourceNames: []string{name},
+			Verbs:         []string{"get", "update", "patch"},
+		}},
+	}
+	if _, err := clientset.RbacV1().Roles(jb.namespace).Create(ctx, role, metav1.CreateOptions{}); err != nil {
+		cleanup()
+		return nil, err
+	}
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: meta,
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: subject, Namespace: jb.namespace}},
+	}
+	if _, err := clientset.RbacV1().RoleBindings(jb.namespace).Create(ctx, binding, metav1.CreateOptions{}); err != nil {
+		cleanup()
+		return nil, err
+	}
+	return cleanup, nil
+}
+
+// applyResultTransport sets the args of the transport on the node-collector container and what the pod needs
+// to send its result
+func applyResultTransport(job *batchv1.Job, transport resultTransport, configMap string) {
+	podSpec := &job.Spec.Template.Spec
+	container := &podSpec.Containers[0]
+	switch transport {
+	case resultTransportTerminationMessage:
+		container.Args = append(container.Args, "--result-transport", string(transport))
+		container.TerminationMessagePath = terminationMessagePath
+		container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	case resultTransportConfigMap:
+		container.Args = append(container.Args, "--result-transport", string(transport), "--result-configmap", configMap)
+		// the token of the service account is projected with a short expiration instead of the automounted one,
+		// the service account created with the ConfigMap is used when the collector has none
+		if podSpec.ServiceAccountName == "" {
+			podSpec.ServiceAccountName = configMap
+		}
+		podSpec.AutomountServiceAccountToken = ptr.To(false)
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: resultTokenVolume,
+			VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+				{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Path: "token", ExpirationSeconds: ptr.To(resultTokenExpiration)}},
+				{ConfigMap: &corev1.ConfigMapProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "kube-root-ca.crt"},
+					Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+				}},
+				{DownwardAPI: &corev1.DownwardAPIProjection{Items: []corev1.DownwardAPIVolumeFile{{
+					Path:     "namespace",
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+				}}}},
+			}}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      resultTokenVolume,
+			MountPath: serviceAccountMountPath,
+			ReadOnly:  true,
+		})
+	}
+}
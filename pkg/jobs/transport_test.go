@@ -0,0 +1,277 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/fake"
+)
+
+func TestResultEnvelope(t *testing.T) {
+	output := []byte(`{"apiVersion":"v1","kind":"NodeInfo","type":"master"}`)
+	envelope, err := newResultEnvelope(output)
+	require.NoError(t, err)
+	encoded, err := json.Marshal(envelope)
+	require.NoError(t, err)
+
+	tampered := *envelope
+	tampered.Data, err = compressAndEncode([]byte(`{"type":"worker"}`))
+	require.NoError(t, err)
+	tamperedEncoded, err := json.Marshal(tampered)
+	require.NoError(t, err)
+
+	unsupported := *envelope
+	unsupported.Encoding = "gzip"
+	unsupportedEncoded, err := json.Marshal(unsupported)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr string
+	}{
+		{name: "round trip", data: encoded, want: output},
+		{name: "checksum mismatch", data: tamperedEncoded, wantErr: errResultChecksum.Error()},
+		{name: "unsupported encoding", data: unsupportedEncoded, wantErr: `unsupported result envelope encoding "gzip"`},
+		{name: "truncated", data: encoded[:len(encoded)/2], wantErr: "decoding result envelope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeResultEnvelope(tt.data)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestJobResultTransport(t *testing.T) {
+	tests := []struct {
+		name           string
+		transport      resultTransport
+		serviceAccount string
+		wantArgs       []string
+		check          func(t *testing.T, spec corev1.PodSpec)
+	}{
+		{
+			name:      "logs",
+			transport: resultTransportLogs,
+			check: func(t *testing.T, spec corev1.PodSpec) {
+				assert.Empty(t, spec.ServiceAccountName)
+				assert.Empty(t, spec.Containers[0].TerminationMessagePolicy)
+			},
+		},
+		{
+			name:      "termination message",
+			transport: resultTransportTerminationMessage,
+			wantArgs:  []string{"--result-transport", "termination-message"},
+			check: func(t *testing.T, spec corev1.PodSpec) {
+				assert.Equal(t, corev1.TerminationMessageReadFile, spec.Containers[0].TerminationMessagePolicy)
+				assert.Equal(t, "/dev/termination-log", spec.Containers[0].TerminationMessagePath)
+			},
+		},
+		{
+			name:      "configmap",
+			transport: resultTransportConfigMap,
+			wantArgs:  []string{"--result-transport", "configmap", "--result-configmap", "node-collector-123-result"},
+			check: func(t *testing.T, spec corev1.PodSpec) {
+				assert.Equal(t, "node-collector-123-result", spec.ServiceAccountName)
+				assert.False(t, *spec.AutomountServiceAccountToken)
+				volume := spec.Volumes[len(spec.Volumes)-1]
+				assert.Equal(t, "result-token", volume.Name)
+				assert.Equal(t, int64(600), *volume.Projected.Sources[0].ServiceAccountToken.ExpirationSeconds)
+				mount := spec.Containers[0].VolumeMounts[len(spec.Containers[0].VolumeMounts)-1]
+				assert.Equal(t, corev1.VolumeMount{Name: "result-token", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount", ReadOnly: true}, mount)
+			},
+		},
+		{
+			name:           "configmap with service account",
+			transport:      resultTransportConfigMap,
+			serviceAccount: "node-collector",
+			wantArgs:       []string{"--result-transport", "configmap", "--result-configmap", "node-collector-123-result"},
+			check: func(t *testing.T, spec corev1.PodSpec) {
+				assert.Equal(t, "node-collector", spec.ServiceAccountName)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job, err := GetJob(
+				WithTemplate(NodeCollectorName),
+				WithNodeConfiguration(true),
+				WithJobServiceAccount(tt.serviceAccount),
+				withJobResultTransport(tt.transport, resultConfigMapName("node-collector-123")),
+			)
+			require.NoError(t, err)
+			args := job.Spec.Template.Spec.Containers[0].Args
+			if len(tt.wantArgs) == 0 {
+				assert.Equal(t, []string{"k8s"}, args)
+			} else {
+				assert.Equal(t, tt.wantArgs, args[len(args)-len(tt.wantArgs):])
+			}
+			tt.check(t, job.Spec.Template.Spec)
+		})
+	}
+}
+
+// terminationLogsReader returns the termination message and the logs of the node-collector container
+type terminationLogsReader struct {
+	message string
+	logs    string
+}
+
+func (r terminationLogsReader) GetLogsByJobAndContainerName(context.Context, *batchv1.Job, string) (io.ReadCloser, error) {
+	if r.logs == "" {
+		return nil, errPodControlledByJobNotFound
+	}
+	return io.NopCloser(strings.NewReader(r.logs)), nil
+}
+
+func (r terminationLogsReader) GetTerminatedContainersStatusesByJob(context.Context, *batchv1.Job) (map[string]*corev1.ContainerStateTerminated, error) {
+	return map[string]*corev1.ContainerStateTerminated{NodeCollectorName: {Message: r.message}}, nil
+}
+
+func TestReadResult(t *testing.T) {
+	ctx := context.Background()
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "trivy-temp", Name: "node-collector-123"}}
+
+	t.Run("termination message", func(t *testing.T) {
+		jc := &jobCollector{resultTransport: resultTransportTerminationMessage, logsReader: terminationLogsReader{message: `{"sha256":"00"}`}}
+		got, err := jc.readResult(ctx, job)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(`{"sha256":"00"}`), got)
+
+		jc.logsReader = terminationLogsReader{}
+		_, err = jc.readResult(ctx, job)
+		assert.EqualError(t, err, "node-collector wrote no termination message")
+	})
+
+	t.Run("truncated termination message", func(t *testing.T) {
+		jc := &jobCollector{resultTransport: resultTransportTerminationMessage, logsReader: terminationLogsReader{
+			message: `{"encoding":"bzip2+base64","data":"` + strings.Repeat("A", terminationMessageMaxLength),
+			logs:    `{"type":"master"}`,
+		}}
+		got, enveloped, err := jc.readOutput(ctx, job)
+		require.NoError(t, err)
+		assert.False(t, enveloped)
+		assert.Equal(t, []byte(`{"type":"master"}`), got)
+	})
+
+	t.Run("configmap with service account", func(t *testing.T) {
+		cluster, err := fake.NewCluster(nil)
+		require.NoError(t, err)
+		jc := &jobCollector{cluster: cluster, namespace: "trivy-temp", resultTransport: resultTransportConfigMap}
+		job := job.DeepCopy()
+		job.Spec.Template.Spec.ServiceAccountName = "node-collector"
+
+		cleanup, err := jc.createResultConfigMap(ctx, job)
+		require.NoError(t, err)
+		defer cleanup()
+		binding, err := cluster.Clientset.RbacV1().RoleBindings("trivy-temp").Get(ctx, "node-collector-123-result", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "node-collector", binding.Subjects[0].Name)
+		accounts, err := cluster.Clientset.CoreV1().ServiceAccounts("trivy-temp").List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, accounts.Items)
+	})
+
+	t.Run("configmap", func(t *testing.T) {
+		cluster, err := fake.NewCluster(nil)
+		require.NoError(t, err)
+		jc := &jobCollector{cluster: cluster, namespace: "trivy-temp", resultTransport: resultTransportConfigMap}
+		job := job.DeepCopy()
+		job.Spec.Template.Spec.ServiceAccountName = "node-collector-123-result"
+
+		cleanup, err := jc.createResultConfigMap(ctx, job)
+		require.NoError(t, err)
+		role, err := cluster.Clientset.RbacV1().Roles("trivy-temp").Get(ctx, "node-collector-123-result", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"node-collector-123-result"}, role.Rules[0].ResourceNames)
+		binding, err := cluster.Clientset.RbacV1().RoleBindings("trivy-temp").Get(ctx, "node-collector-123-result", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "node-collector-123-result", binding.Subjects[0].Name)
+
+		_, err = jc.readResult(ctx, job)
+		assert.EqualError(t, err, "node-collector wrote no result to the configmap")
+
+		// the node-collector writes its result
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "trivy-temp", Name: "node-collector-123-result"},
+			Data:       map[string]string{"result": `{"sha256":"00"}`},
+		}
+		_, err = cluster.Clientset.CoreV1().ConfigMaps("trivy-temp").Update(ctx, cm, metav1.UpdateOptions{})
+		require.NoError(t, err)
+		got, err := jc.readResult(ctx, job)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(`{"sha256":"00"}`), got)
+
+		cleanup()
+		for _, list := range []func() (int, error){
+			func() (int, error) {
+				l, err := cluster.Clientset.CoreV1().ConfigMaps("trivy-temp").List(ctx, metav1.ListOptions{})
+				return len(l.Items), err
+			},
+			func() (int, error) {
+				l, err := cluster.Clientset.CoreV1().ServiceAccounts("trivy-temp").List(ctx, metav1.ListOptions{})
+				return len(l.Items), err
+			},
+			func() (int, error) {
+				l, err := cluster.Clientset.RbacV1().Roles("trivy-temp").List(ctx, metav1.ListOptions{})
+				return len(l.Items), err
+			},
+			func() (int, error) {
+				l, err := cluster.Clientset.RbacV1().RoleBindings("trivy-temp").List(ctx, metav1.ListOptions{})
+				return len(l.Items), err
+			},
+		} {
+			n, err := list()
+			require.NoError(t, err)
+			assert.Zero(t, n)
+		}
+	})
+}
+
+func TestCheckResultTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport resultTransport
+		imageRef  string
+		wantErr   bool
+	}{
+		{name: "logs", transport: resultTransportLogs},
+		{name: "default"},
+		{name: "template image", transport: resultTransportTerminationMessage, wantErr: true},
+		{name: "pinned image", transport: resultTransportConfigMap, imageRef: nodeCollectorImageRef, wantErr: true},
+		{name: "other image", transport: resultTransportConfigMap, imageRef: "ghcr.io/aquasecurity/node-collector:dev"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkResultTransport(tt.transport, tt.imageRef)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errResultTransportUnsupported)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestNodeCollectorImageRef(t *testing.T) {
+	// the result transports are checked against the image of the template
+	job, err := GetJob(WithTemplate(NodeCollectorName))
+	require.NoError(t, err)
+	assert.Equal(t, nodeCollectorImageRef, job.Spec.Template.Spec.Containers[0].Image)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"hash"
 	"hash/fnv"
+	"io"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/dsnet/compress/bzip2"
@@ -49,3 +50,16 @@ func compressAndEncode(data []byte) (string, error) {
 	_ = w.Close()
 	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
 }
+
+func decodeAndDecompress(data string) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	r, err := bzip2.NewReader(bytes.NewReader(compressed), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
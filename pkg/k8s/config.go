@@ -0,0 +1,210 @@
+package k8s
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/disk"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// defaultDiscoveryCacheTTL is the TTL of the discovery cache, as kubectl
+const defaultDiscoveryCacheTTL = 6 * time.Hour
+
+var unsafeCachePathChars = regexp.MustCompile(`[^\w.-]`)
+
+// serviceAccountNamespaceFile is the namespace of the service account token mounted in pods
+var serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// NewClusterFromRESTConfig instansiate a cluster from the REST config, e.g. of an operator manager.
+// The options overriding the server, credentials, TLS and transport settings are applied to a copy of
+// the config, the kubeconfig and context options are ignored. The current namespace is the one of
+// WithNamespace, of the service account when running in-cluster, default otherwise.
+// The cluster is named after the API server host.
+func NewClusterFromRESTConfig(cfg *rest.Config, opts ...ClusterOption) (Cluster, error) {
+	o := newClusterOptions(opts...)
+	config := o.restConfig(cfg)
+
+	restMapper, err := o.newRESTMapper(config)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newClusterForConfig(config, restMapper, true)
+	if err != nil {
+		return nil, err
+	}
+	c.currentNamespace = inClusterNamespace()
+	if o.configFlags.Namespace != nil && *o.configFlags.Namespace != "" {
+		c.currentNamespace = *o.configFlags.Namespace
+	}
+	c.clusterName = hostName(config.Host)
+	if err := o.applyCredentials(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewInClusterCluster instansiate a cluster from the service account mounted in the pod, see NewClusterFromRESTConfig
+func NewInClusterCluster(opts ...ClusterOption) (Cluster, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewClusterFromRESTConfig(cfg, opts...)
+}
+
+// NewClusterFromKubeconfigBytes instansiate a cluster from a raw kubeconfig, context selects
+// the kubeconfig context, the current context is used when empty
+func NewClusterFromKubeconfigBytes(kubeconfig []byte, context string, opts ...ClusterOption) (Cluster, error) {
+	o := newClusterOptions(opts...)
+	rawConfig, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	if o.configFlags.Namespace != nil {
+		overrides.Context.Namespace = *o.configFlags.Namespace
+	}
+	clientConfig := clientcmd.NewNonInteractiveClientConfig(*rawConfig, context, overrides, nil)
+	cfg, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	config := o.restConfig(cfg)
+
+	restMapper, err := o.newRESTMapper(config)
+	if err != nil {
+		return nil, err
+	}
+	c, err := getCluster(clientConfig, config, restMapper, context, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.applyCredentials(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ListContexts returns the sorted context names of the kubeconfig, of the KUBECONFIG files or
+// ~/.kube/config when kubeConfig is empty
+func ListContexts(kubeConfig string) ([]string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeConfig != "" {
+		rules.ExplicitPath = kubeConfig
+	}
+	config, err := rules.Load()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	contexts := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+	return contexts, nil
+}
+
+// restConfig returns a copy of the config with the options applied, as ConfigFlags does for kubeconfigs
+func (o *clusterOptions) restConfig(cfg *rest.Config) *rest.Config {
+	config := rest.CopyConfig(cfg)
+	cf := o.configFlags
+	if cf.APIServer != nil && *cf.APIServer != "" {
+		config.Host = *cf.APIServer
+	}
+	if cf.BearerToken != nil && *cf.BearerToken != "" {
+		config.BearerToken = *cf.BearerToken
+		config.BearerTokenFile = ""
+	}
+	if cf.Impersonate != nil && *cf.Impersonate != "" {
+		config.Impersonate.UserName = *cf.Impersonate
+	}
+	if cf.ImpersonateGroup != nil && len(*cf.ImpersonateGroup) > 0 {
+		config.Impersonate.Groups = *cf.ImpersonateGroup
+	}
+	if cf.CAFile != nil && *cf.CAFile != "" {
+		config.TLSClientConfig.CAFile = *cf.CAFile
+		config.TLSClientConfig.CAData = nil
+	}
+	if cf.TLSServerName != nil && *cf.TLSServerName != "" {
+		config.TLSClientConfig.ServerName = *cf.TLSServerName
+	}
+	if cf.WrapConfigFn != nil {
+		config = cf.WrapConfigFn(config)
+	}
+	return config
+}
+
+// newRESTMapper returns a RESTMapper of the live discovery, of the disk cache when WithDiscoveryCache is used
+func (o *clusterOptions) newRESTMapper(config *rest.Config) (meta.RESTMapper, error) {
+	var cachedClient discovery.CachedDiscoveryInterface
+	if o.discoveryCacheDir != "" {
+		client, err := newDiskCachedDiscoveryClient(config, o.discoveryCacheDir, o.discoveryCacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		cachedClient = client
+	} else {
+		discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		cachedClient = memory.NewMemCacheClient(discoveryClient)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedClient)
+	return restmapper.NewShortcutExpander(mapper, cachedClient, nil), nil
+}
+
+// newDiskCachedDiscoveryClient returns a discovery client cached in <dir>/discovery/<host>/<server version>,
+// a server upgrade starts a new cache
+func newDiskCachedDiscoveryClient(config *rest.Config, dir string, ttl time.Duration) (discovery.CachedDiscoveryInterface, error) {
+	if ttl <= 0 {
+		ttl = defaultDiscoveryCacheTTL
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	sv, err := discoveryClient.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	discoveryCacheDir := filepath.Join(dir, "discovery", cachePathSegment(hostName(config.Host)), cachePathSegment(sv.GitVersion))
+	return disk.NewCachedDiscoveryClientForConfig(config, discoveryCacheDir, filepath.Join(dir, "http"), ttl)
+}
+
+// cachePathSegment replaces the characters which are not safe in a file name
+func cachePathSegment(s string) string {
+	return unsafeCachePathChars.ReplaceAllString(s, "_")
+}
+
+// inClusterNamespace returns the namespace of the pod, default when not running in-cluster
+func inClusterNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return "default"
+}
+
+func hostName(host string) string {
+	if u, err := url.Parse(host); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return host
+}
@@ -0,0 +1,228 @@
+package k8s
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// discoveryServer serves the version and the legacy discovery of pods, deployments and the added resources
+type discoveryServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[string]any
+	requests  map[string]int
+}
+
+func newDiscoveryServer(t *testing.T) *discoveryServer {
+	s := &discoveryServer{
+		requests: make(map[string]int),
+		responses: map[string]any{
+			"/version": version.Info{GitVersion: "v1.29.1", Major: "1", Minor: "29"},
+			"/api":     metav1.APIVersions{Versions: []string{"v1"}},
+			"/apis": metav1.APIGroupList{Groups: []metav1.APIGroup{{
+				Name:             "apps",
+				Versions:         []metav1.GroupVersionForDiscovery{{GroupVersion: "apps/v1", Version: "v1"}},
+				PreferredVersion: metav1.GroupVersionForDiscovery{GroupVersion: "apps/v1", Version: "v1"},
+			}}},
+			"/api/v1": metav1.APIResourceList{GroupVersion: "v1", APIResources: []metav1.APIResource{
+				{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: metav1.Verbs{"get", "list"}},
+			}},
+			"/apis/apps/v1": metav1.APIResourceList{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, ShortNames: []string{"deploy"}, Verbs: metav1.Verbs{"get", "list"}},
+			}},
+		},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		response, ok := s.responses[r.URL.Path]
+		s.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *discoveryServer) setGitVersion(gitVersion string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses["/version"] = version.Info{GitVersion: gitVersion}
+}
+
+// addGroupResource adds a group with a single version and namespaced resource
+func (s *discoveryServer) addGroupResource(group, groupVersion, resource, kind string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gv := metav1.GroupVersionForDiscovery{GroupVersion: group + "/" + groupVersion, Version: groupVersion}
+	groups := s.responses["/apis"].(metav1.APIGroupList)
+	groups.Groups = append(groups.Groups, metav1.APIGroup{Name: group, Versions: []metav1.GroupVersionForDiscovery{gv}, PreferredVersion: gv})
+	s.responses["/apis"] = groups
+	s.responses["/apis/"+gv.GroupVersion] = metav1.APIResourceList{GroupVersion: gv.GroupVersion, APIResources: []metav1.APIResource{
+		{Name: resource, Kind: kind, Namespaced: true, Verbs: metav1.Verbs{"get", "list"}},
+	}}
+}
+
+// discoveryRequests returns the number of requests of the group discovery
+func (s *discoveryServer) discoveryRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests["/api"] + s.requests["/apis"]
+}
+
+func TestNewClusterFromRESTConfig(t *testing.T) {
+	server := newDiscoveryServer(t)
+	namespaceFile := filepath.Join(t.TempDir(), "namespace")
+	require.NoError(t, os.WriteFile(namespaceFile, []byte("trivy-system\n"), 0o600))
+
+	tests := []struct {
+		name          string
+		namespaceFile string
+		opts          []ClusterOption
+		wantNamespace string
+	}{
+		{name: "in-cluster namespace", namespaceFile: namespaceFile, wantNamespace: "trivy-system"},
+		{name: "namespace option", namespaceFile: namespaceFile, opts: []ClusterOption{WithNamespace("team-a")}, wantNamespace: "team-a"},
+		{name: "out of cluster", namespaceFile: filepath.Join(t.TempDir(), "missing"), wantNamespace: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("POD_NAMESPACE", "")
+			serviceAccountNamespaceFile = tt.namespaceFile
+			t.Cleanup(func() { serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace" })
+
+			c, err := NewClusterFromRESTConfig(&rest.Config{Host: server.URL}, tt.opts...)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantNamespace, c.GetCurrentNamespace())
+			assert.Equal(t, "1.29.1", c.GetClusterVersion())
+			gvr, err := c.GetGVR("deploy")
+			require.NoError(t, err)
+			assert.Equal(t, schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, gvr)
+
+			name, gitVersion, err := c.(*cluster).ClusterNameVersion()
+			require.NoError(t, err)
+			assert.Equal(t, strings.TrimPrefix(server.URL, "http://"), name)
+			assert.Equal(t, "v1.29.1", gitVersion)
+		})
+	}
+}
+
+func TestNewClusterFromKubeconfigBytes(t *testing.T) {
+	server := newDiscoveryServer(t)
+	config := clientcmdapi.NewConfig()
+	config.CurrentContext = "dev"
+	config.Clusters["dev-cluster"] = &clientcmdapi.Cluster{Server: server.URL}
+	config.Clusters["prod-cluster"] = &clientcmdapi.Cluster{Server: server.URL}
+	config.AuthInfos["user"] = &clientcmdapi.AuthInfo{Token: "the-token"}
+	config.Contexts["dev"] = &clientcmdapi.Context{Cluster: "dev-cluster", AuthInfo: "user"}
+	config.Contexts["prod"] = &clientcmdapi.Context{Cluster: "prod-cluster", AuthInfo: "user", Namespace: "apps"}
+	kubeconfig, err := clientcmd.Write(*config)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		kubeconfig    []byte
+		context       string
+		opts          []ClusterOption
+		wantContext   string
+		wantNamespace string
+		wantCluster   string
+		wantErr       string
+	}{
+		{name: "current context", kubeconfig: kubeconfig, wantContext: "dev", wantNamespace: "default", wantCluster: "dev-cluster"},
+		{name: "context", kubeconfig: kubeconfig, context: "prod", wantContext: "prod", wantNamespace: "apps", wantCluster: "prod-cluster"},
+		{name: "namespace option", kubeconfig: kubeconfig, context: "prod", opts: []ClusterOption{WithNamespace("team-a")}, wantContext: "prod", wantNamespace: "team-a", wantCluster: "prod-cluster"},
+		{name: "missing context", kubeconfig: kubeconfig, context: "staging", wantErr: `context "staging" does not exist`},
+		{name: "invalid kubeconfig", kubeconfig: []byte("{"), wantErr: "loading kubeconfig"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewClusterFromKubeconfigBytes(tt.kubeconfig, tt.context, tt.opts...)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantContext, c.GetCurrentContext())
+			assert.Equal(t, tt.wantNamespace, c.GetCurrentNamespace())
+			gvr, err := c.GetGVR("pods")
+			require.NoError(t, err)
+			assert.Equal(t, schema.GroupVersionResource{Version: "v1", Resource: "pods"}, gvr)
+
+			name, gitVersion, err := c.(*cluster).ClusterNameVersion()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCluster, name)
+			assert.Equal(t, "v1.29.1", gitVersion)
+		})
+	}
+}
+
+func TestDiscoveryCache(t *testing.T) {
+	server := newDiscoveryServer(t)
+	cacheDir := t.TempDir()
+	newCluster := func() Cluster {
+		c, err := NewClusterFromRESTConfig(&rest.Config{Host: server.URL}, WithDiscoveryCache(cacheDir, time.Hour))
+		require.NoError(t, err)
+		return c
+	}
+
+	// first run fills the cache
+	_, err := newCluster().GetGVR("deployments")
+	require.NoError(t, err)
+	requests := server.discoveryRequests()
+	assert.Positive(t, requests)
+
+	// next runs are served by the cache
+	c := newCluster()
+	_, err = c.GetGVR("deployments")
+	require.NoError(t, err)
+	assert.Equal(t, requests, server.discoveryRequests())
+
+	// unknown resources refresh the discovery
+	server.addGroupResource("example.com", "v1", "widgets", "Widget")
+	gvr, err := c.GetGVR("widgets")
+	require.NoError(t, err)
+	assert.Equal(t, schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}, gvr)
+	requests = server.discoveryRequests()
+
+	// a fresh discovery is not refreshed again for unknown resources
+	_, err = c.GetGVR("gadgets")
+	require.Error(t, err)
+	assert.Equal(t, requests, server.discoveryRequests())
+
+	// a server upgrade starts a new cache
+	server.setGitVersion("v1.30.0")
+	_, err = newCluster().GetGVR("widgets")
+	require.NoError(t, err)
+	assert.Greater(t, server.discoveryRequests(), requests)
+	entries, err := os.ReadDir(filepath.Join(cacheDir, "discovery", cachePathSegment(strings.TrimPrefix(server.URL, "http://"))))
+	require.NoError(t, err)
+	var versions []string
+	for _, entry := range entries {
+		versions = append(versions, entry.Name())
+	}
+	assert.Equal(t, []string{"v1.29.1", "v1.30.0"}, versions)
+}
@@ -0,0 +1,86 @@
+package credentialprovider
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	configKind = "CredentialProviderConfig"
+
+	// supported CredentialProviderRequest/Response API versions
+	apiVersionV1       = "credentialprovider.kubelet.k8s.io/v1"
+	apiVersionV1beta1  = "credentialprovider.kubelet.k8s.io/v1beta1"
+	apiVersionV1alpha1 = "credentialprovider.kubelet.k8s.io/v1alpha1"
+)
+
+var supportedAPIVersions = []string{apiVersionV1, apiVersionV1beta1, apiVersionV1alpha1}
+
+// Config is the kubelet CredentialProviderConfig, passed to the kubelet with --image-credential-provider-config.
+// See https://kubernetes.io/docs/tasks/administer-cluster/kubelet-credential-provider/
+type Config struct {
+	Kind       string           `json:"kind"`
+	APIVersion string           `json:"apiVersion"`
+	Providers  []ProviderConfig `json:"providers"`
+}
+
+// ProviderConfig describes a credential provider plugin binary
+type ProviderConfig struct {
+	// Name is the name of the plugin binary in the plugins directory
+	Name string `json:"name"`
+	// MatchImages are the image patterns the plugin is invoked for
+	MatchImages []string `json:"matchImages"`
+	// DefaultCacheDuration is used when the plugin response does not set a cache duration
+	DefaultCacheDuration *metav1.Duration `json:"defaultCacheDuration,omitempty"`
+	// APIVersion is the CredentialProviderRequest/Response version spoken by the plugin
+	APIVersion string   `json:"apiVersion"`
+	Args       []string `json:"args,omitempty"`
+	Env        []EnvVar `json:"env,omitempty"`
+}
+
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// LoadConfig reads and validates a CredentialProviderConfig file
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ReadConfig(b)
+}
+
+// ReadConfig decodes and validates a CredentialProviderConfig
+func ReadConfig(data []byte) (*Config, error) {
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("decoding credential provider config: %w", err)
+	}
+	if config.Kind != configKind {
+		return nil, fmt.Errorf("unexpected credential provider config kind %q", config.Kind)
+	}
+	if len(config.Providers) == 0 {
+		return nil, fmt.Errorf("credential provider config has no providers")
+	}
+	for _, p := range config.Providers {
+		if p.Name == "" {
+			return nil, fmt.Errorf("credential provider name is required")
+		}
+		if len(p.MatchImages) == 0 {
+			return nil, fmt.Errorf("credential provider %q: matchImages is required", p.Name)
+		}
+		if !slices.Contains(supportedAPIVersions, p.APIVersion) {
+			return nil, fmt.Errorf("credential provider %q: unsupported apiVersion %q", p.Name, p.APIVersion)
+		}
+		if p.DefaultCacheDuration != nil && p.DefaultCacheDuration.Duration < 0 {
+			return nil, fmt.Errorf("credential provider %q: defaultCacheDuration must not be negative", p.Name)
+		}
+	}
+	return &config, nil
+}
@@ -0,0 +1,240 @@
+package credentialprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+)
+
+const (
+	defaultExecTimeout = time.Minute
+
+	cacheKeyTypeImage    = "Image"
+	cacheKeyTypeRegistry = "Registry"
+	cacheKeyTypeGlobal   = "Global"
+)
+
+type request struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Image      string `json:"image"`
+}
+
+type response struct {
+	APIVersion    string                  `json:"apiVersion"`
+	Kind          string                  `json:"kind"`
+	CacheKeyType  string                  `json:"cacheKeyType"`
+	CacheDuration *metav1.Duration        `json:"cacheDuration,omitempty"`
+	Auth          map[string]authResponse `json:"auth"`
+}
+
+type authResponse struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type cacheEntry struct {
+	auths     map[string]docker.Auth
+	expiresAt time.Time
+}
+
+// Provider runs kubelet credential provider plugins using the
+// credentialprovider.kubelet.k8s.io CredentialProviderRequest/Response exec protocol
+type Provider struct {
+	config  *Config
+	binDir  string
+	timeout time.Duration
+	// now is replaced in tests
+	now func() time.Time
+
+	mu sync.Mutex
+	// cache holds plugin responses by provider name and cache key
+	cache map[string]map[string]cacheEntry
+}
+
+type ProviderOption func(*Provider)
+
+// WithExecTimeout sets the timeout of a single plugin execution, default 1m
+func WithExecTimeout(timeout time.Duration) ProviderOption {
+	return func(p *Provider) {
+		p.timeout = timeout
+	}
+}
+
+// NewProvider instansiate a new credential provider runner for the plugins of binDir
+func NewProvider(config *Config, binDir string, opts ...ProviderOption) *Provider {
+	p := &Provider{
+		config:  config,
+		binDir:  binDir,
+		timeout: defaultExecTimeout,
+		now:     time.Now,
+		cache:   make(map[string]map[string]cacheEntry),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Provide returns the credentials of the plugins matching the image, keyed by registry pattern
+// as returned by the plugins. Responses are cached for their duration.
+func (p *Provider) Provide(ctx context.Context, image string) (map[string]docker.Auth, error) {
+	repository, err := docker.GetRepositoryFromImageRef(image)
+	if err != nil {
+		return nil, err
+	}
+	registry, err := docker.GetServerFromImageRef(image)
+	if err != nil {
+		return nil, err
+	}
+
+	auths := make(map[string]docker.Auth)
+	var errs []error
+	for _, provider := range p.config.Providers {
+		if !matchImages(provider.MatchImages, repository) {
+			continue
+		}
+		providerAuths, ok := p.cached(provider.Name, repository, registry)
+		if !ok {
+			providerAuths, err = p.exec(ctx, provider, repository, registry)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		for pattern, auth := range providerAuths {
+			if _, ok := auths[pattern]; !ok {
+				auths[pattern] = auth
+			}
+		}
+	}
+	return auths, errors.Join(errs...)
+}
+
+func matchImages(patterns []string, repository string) bool {
+	for _, pattern := range patterns {
+		matched, err := docker.URLsMatchStr(pattern, repository)
+		if err != nil {
+			slog.Warn("Invalid credential provider matchImages pattern", "pattern", pattern, "error", err)
+			continue
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Provider) cached(provider, repository, registry string) (map[string]docker.Auth, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	for _, key := range []string{cacheKey(cacheKeyTypeImage, repository, registry), cacheKey(cacheKeyTypeRegistry, repository, registry), cacheKey(cacheKeyTypeGlobal, repository, registry)} {
+		entry, ok := p.cache[provider][key]
+		if !ok {
+			continue
+		}
+		if now.After(entry.expiresAt) {
+			delete(p.cache[provider], key)
+			continue
+		}
+		return entry.auths, true
+	}
+	return nil, false
+}
+
+func cacheKey(keyType, repository, registry string) string {
+	switch keyType {
+	case cacheKeyTypeImage:
+		return cacheKeyTypeImage + ":" + repository
+	case cacheKeyTypeRegistry:
+		return cacheKeyTypeRegistry + ":" + registry
+	default:
+		return cacheKeyTypeGlobal
+	}
+}
+
+func (p *Provider) exec(ctx context.Context, provider ProviderConfig, repository, registry string) (map[string]docker.Auth, error) {
+	req, err := json.Marshal(request{
+		APIVersion: provider.APIVersion,
+		Kind:       "CredentialProviderRequest",
+		Image:      repository,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, filepath.Join(p.binDir, provider.Name), provider.Args...)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second
+	cmd.Env = os.Environ()
+	for _, env := range provider.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", env.Name, env.Value))
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running credential provider %q: %w: %s", provider.Name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("decoding credential provider %q response: %w", provider.Name, err)
+	}
+	if resp.Kind != "CredentialProviderResponse" || resp.APIVersion != provider.APIVersion {
+		return nil, fmt.Errorf("credential provider %q returned unexpected %s %s", provider.Name, resp.APIVersion, resp.Kind)
+	}
+
+	auths := make(map[string]docker.Auth, len(resp.Auth))
+	for pattern, auth := range resp.Auth {
+		auths[pattern] = docker.Auth{
+			Auth:     docker.NewBasicAuth(auth.Username, auth.Password),
+			Username: auth.Username,
+			Password: auth.Password,
+		}
+	}
+
+	duration := time.Duration(0)
+	if provider.DefaultCacheDuration != nil {
+		duration = provider.DefaultCacheDuration.Duration
+	}
+	if resp.CacheDuration != nil {
+		duration = resp.CacheDuration.Duration
+	}
+	if duration > 0 {
+		switch resp.CacheKeyType {
+		case cacheKeyTypeImage, cacheKeyTypeRegistry, cacheKeyTypeGlobal:
+			p.mu.Lock()
+			if p.cache[provider.Name] == nil {
+				p.cache[provider.Name] = make(map[string]cacheEntry)
+			}
+			p.cache[provider.Name][cacheKey(resp.CacheKeyType, repository, registry)] = cacheEntry{
+				auths:     auths,
+				expiresAt: p.now().Add(duration),
+			}
+			p.mu.Unlock()
+		default:
+			slog.Warn("Credential provider returned an unknown cacheKeyType, response is not cached", "provider", provider.Name, "cacheKeyType", resp.CacheKeyType)
+		}
+	}
+	return auths, nil
+}
@@ -0,0 +1,183 @@
+package credentialprovider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+)
+
+// stubPlugin records the requests in $CALLS and returns credentials for ecr registries,
+// the cache key type and duration are taken from its arguments
+const stubPlugin = `#!/bin/sh
+request=$(cat)
+echo "$request" >> "$CALLS"
+cat <<EOF
+{"apiVersion":"credentialprovider.kubelet.k8s.io/v1","kind":"CredentialProviderResponse","cacheKeyType":"$1","cacheDuration":"$2","auth":{"*.dkr.ecr.*.amazonaws.com":{"username":"AWS","password":"$PASSWORD"}}}
+EOF
+`
+
+const testConfig = `
+apiVersion: kubelet.config.k8s.io/v1
+kind: CredentialProviderConfig
+providers:
+  - name: ecr-credential-provider
+    matchImages:
+      - "*.dkr.ecr.*.amazonaws.com"
+      - "*.dkr.ecr.*.amazonaws.com.cn"
+    defaultCacheDuration: "12h"
+    apiVersion: credentialprovider.kubelet.k8s.io/v1
+    args: [%s, %s]
+    env:
+      - name: PASSWORD
+        value: ecr-token
+`
+
+func newTestProvider(t *testing.T, cacheKeyType, cacheDuration string) (*Provider, string) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ecr-credential-provider"), []byte(stubPlugin), 0o755))
+	calls := filepath.Join(dir, "calls")
+	t.Setenv("CALLS", calls)
+
+	config, err := ReadConfig([]byte(strings.NewReplacer("%s, %s", cacheKeyType+", \""+cacheDuration+"\"").Replace(testConfig)))
+	require.NoError(t, err)
+	return NewProvider(config, dir), calls
+}
+
+func countCalls(t *testing.T, calls string) int {
+	b, err := os.ReadFile(calls)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	require.NoError(t, err)
+	return strings.Count(string(b), "CredentialProviderRequest")
+}
+
+func TestProvide(t *testing.T) {
+	provider, calls := newTestProvider(t, "Registry", "1h")
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	want := map[string]docker.Auth{
+		"*.dkr.ecr.*.amazonaws.com": {Auth: docker.NewBasicAuth("AWS", "ecr-token"), Username: "AWS", Password: "ecr-token"},
+	}
+
+	auths, err := provider.Provide(ctx, "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app:1.0")
+	require.NoError(t, err)
+	assert.Equal(t, want, auths)
+	assert.Equal(t, 1, countCalls(t, calls))
+
+	b, err := os.ReadFile(calls)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"apiVersion":"credentialprovider.kubelet.k8s.io/v1","kind":"CredentialProviderRequest","image":"123456789012.dkr.ecr.eu-west-1.amazonaws.com/app"}`, string(b))
+
+	// same registry is served from cache
+	auths, err = provider.Provide(ctx, "123456789012.dkr.ecr.eu-west-1.amazonaws.com/other@sha256:18e61c783b41758dd391ab901366ec3546b26fae00eef7e223d1f94da808e02f")
+	require.NoError(t, err)
+	assert.Equal(t, want, auths)
+	assert.Equal(t, 1, countCalls(t, calls))
+
+	// images not matching are not sent to the plugin
+	auths, err = provider.Provide(ctx, "nginx:1.25")
+	require.NoError(t, err)
+	assert.Empty(t, auths)
+	assert.Equal(t, 1, countCalls(t, calls))
+
+	// expired entries are refreshed
+	now = now.Add(2 * time.Hour)
+	_, err = provider.Provide(ctx, "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app:1.0")
+	require.NoError(t, err)
+	assert.Equal(t, 2, countCalls(t, calls))
+}
+
+func TestProvideCacheKeyType(t *testing.T) {
+	tests := []struct {
+		name          string
+		cacheKeyType  string
+		cacheDuration string
+		images        []string
+		wantCalls     int
+	}{
+		{
+			name:          "image cache key",
+			cacheKeyType:  "Image",
+			cacheDuration: "1h",
+			images:        []string{"1.dkr.ecr.eu-west-1.amazonaws.com/app:1.0", "1.dkr.ecr.eu-west-1.amazonaws.com/app:2.0", "1.dkr.ecr.eu-west-1.amazonaws.com/other:1.0"},
+			wantCalls:     2,
+		},
+		{
+			name:          "global cache key",
+			cacheKeyType:  "Global",
+			cacheDuration: "1h",
+			images:        []string{"1.dkr.ecr.eu-west-1.amazonaws.com/app:1.0", "2.dkr.ecr.us-east-1.amazonaws.com/other:1.0"},
+			wantCalls:     1,
+		},
+		{
+			name:          "zero duration disables cache",
+			cacheKeyType:  "Global",
+			cacheDuration: "0s",
+			images:        []string{"1.dkr.ecr.eu-west-1.amazonaws.com/app:1.0", "1.dkr.ecr.eu-west-1.amazonaws.com/app:1.0"},
+			wantCalls:     2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, calls := newTestProvider(t, tt.cacheKeyType, tt.cacheDuration)
+			for _, image := range tt.images {
+				_, err := provider.Provide(context.Background(), image)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantCalls, countCalls(t, calls))
+		})
+	}
+}
+
+func TestProvideMissingPlugin(t *testing.T) {
+	config, err := ReadConfig([]byte(strings.NewReplacer("%s, %s", "Global, \"1h\"").Replace(testConfig)))
+	require.NoError(t, err)
+	_, err = NewProvider(config, t.TempDir()).Provide(context.Background(), "1.dkr.ecr.eu-west-1.amazonaws.com/app:1.0")
+	assert.ErrorContains(t, err, `running credential provider "ecr-credential-provider"`)
+}
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:    "wrong kind",
+			config:  "kind: KubeletConfiguration\n",
+			wantErr: "unexpected credential provider config kind",
+		},
+		{
+			name:    "no providers",
+			config:  "kind: CredentialProviderConfig\nproviders: []\n",
+			wantErr: "has no providers",
+		},
+		{
+			name:    "missing matchImages",
+			config:  "kind: CredentialProviderConfig\nproviders:\n  - name: gcp\n    apiVersion: credentialprovider.kubelet.k8s.io/v1\n",
+			wantErr: "matchImages is required",
+		},
+		{
+			name:    "unsupported apiVersion",
+			config:  "kind: CredentialProviderConfig\nproviders:\n  - name: gcp\n    matchImages: [gcr.io]\n    apiVersion: credentialprovider.kubelet.k8s.io/v2\n",
+			wantErr: "unsupported apiVersion",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadConfig([]byte(tt.config))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
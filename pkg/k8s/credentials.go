@@ -0,0 +1,493 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sapierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/credentialprovider"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+)
+
+// CredentialSource tells where a registry credential was found
+type CredentialSource string
+
+const (
+	// CredentialSourcePodSpec an image pull secret listed in the pod spec
+	CredentialSourcePodSpec CredentialSource = "PodSpec"
+	// CredentialSourceServiceAccount an image pull secret of the pod service account
+	CredentialSourceServiceAccount CredentialSource = "ServiceAccount"
+	// CredentialSourceCredentialProvider a kubelet credential provider plugin
+	CredentialSourceCredentialProvider CredentialSource = "CredentialProvider"
+)
+
+// CredentialRef locates a registry credential without holding it
+type CredentialRef struct {
+	Source CredentialSource
+	// Namespace and SecretName of the image pull secret, empty for credential providers
+	Namespace  string
+	SecretName string
+	// Key is the secret data key holding the docker config
+	Key string
+	// Server is the registry key the credential applies to, empty for references built without
+	// reading the secret which are resolved with the credential of the secret matching Image
+	Server string
+	// Image the reference was recorded for when Server is empty
+	Image string
+	// ServiceAccount referencing the secret when the source is CredentialSourceServiceAccount
+	ServiceAccount string
+}
+
+// Credential is a registry credential with its provenance
+type Credential struct {
+	docker.Auth
+	CredentialRef
+}
+
+// RegistryCredentials holds the credentials of each registry key in the order the kubelet tries them:
+// pod spec secrets, then service account secrets, then credential providers
+type RegistryCredentials map[string][]Credential
+
+// Auths returns the preferred credential of every registry key
+func (c RegistryCredentials) Auths() map[string]docker.Auth {
+	auths := make(map[string]docker.Auth, len(c))
+	for key, creds := range c {
+		if len(creds) > 0 {
+			auths[key] = creds[0].Auth
+		}
+	}
+	return auths
+}
+
+// JoinedAuths returns a single credential per registry key, usernames and passwords of several
+// credentials are joined with commas in the former order: service account secrets, then pod spec
+// secrets, then credential providers.
+// Deprecated: kept for the consumers of AuthByResource and Artifact.Credentials, use the credential lists instead.
+func (c RegistryCredentials) JoinedAuths() map[string]docker.Auth {
+	auths := make(map[string]docker.Auth, len(c))
+	for key, creds := range c {
+		creds = append([]Credential{}, creds...)
+		sort.SliceStable(creds, func(i, j int) bool {
+			return joinedAuthsRank(creds[i].Source) < joinedAuthsRank(creds[j].Source)
+		})
+		for i, cred := range creds {
+			if i == 0 {
+				auths[key] = cred.Auth
+				continue
+			}
+			a := auths[key]
+			auths[key] = docker.Auth{
+				Username: fmt.Sprintf("%s,%s", a.Username, cred.Username),
+				Password: fmt.Sprintf("%s,%s", a.Password, cred.Password),
+			}
+		}
+	}
+	return auths
+}
+
+// joinedAuthsRank returns the rank of the credential source in JoinedAuths
+func joinedAuthsRank(source CredentialSource) int {
+	switch source {
+	case CredentialSourceServiceAccount:
+		return 0
+	case CredentialSourceCredentialProvider:
+		return 2
+	}
+	return 1
+}
+
+func (c RegistryCredentials) add(key string, cred Credential) {
+	c[key] = append(c[key], cred)
+}
+
+// CredentialsFromAuths wraps registry auths which have no provenance
+func CredentialsFromAuths(auths map[string]docker.Auth) RegistryCredentials {
+	creds := make(RegistryCredentials, len(auths))
+	for key, auth := range auths {
+		creds.add(key, Credential{Auth: auth, CredentialRef: CredentialRef{Server: key}})
+	}
+	return creds
+}
+
+// MatchCredentials returns every credential matching the image, most specific registry key first
+// and in the kubelet order within a key
+func MatchCredentials(imageRef string, creds RegistryCredentials) ([]Credential, error) {
+	keys := make([]string, 0, len(creds))
+	for key := range creds {
+		keys = append(keys, key)
+	}
+	matched, err := matchRegistryKeys(imageRef, keys)
+	if err != nil {
+		return nil, err
+	}
+	var result []Credential
+	for _, key := range matched {
+		result = append(result, creds[key]...)
+	}
+	return result, nil
+}
+
+// matchRegistryKeys returns the registry keys matching the image in the kubelet keyring order,
+// keys are tried in reverse lexical order so the most specific key comes first
+func matchRegistryKeys(imageRef string, keys []string) ([]string, error) {
+	repository, err := docker.GetRepositoryFromImageRef(imageRef)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]string, 0, len(keys))
+	registryKeys := make(map[string]string, len(keys))
+	for _, key := range keys {
+		registryKey, err := docker.GetRegistryKeyFromDockerAuthKey(key)
+		if err != nil {
+			slog.Warn("Invalid registry key, skipping", "key", key, "error", err)
+			continue
+		}
+		candidates = append(candidates, key)
+		registryKeys[key] = registryKey
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if registryKeys[candidates[i]] != registryKeys[candidates[j]] {
+			return registryKeys[candidates[i]] > registryKeys[candidates[j]]
+		}
+		return candidates[i] > candidates[j]
+	})
+
+	var matched []string
+	for _, key := range candidates {
+		ok, err := docker.URLsMatchStr(registryKeys[key], repository)
+		if err != nil {
+			slog.Warn("Invalid registry key, skipping", "key", key, "error", err)
+			continue
+		}
+		if ok {
+			matched = append(matched, key)
+		}
+	}
+	return matched, nil
+}
+
+// serviceAccountGetter fetches the service accounts of pod specs
+type serviceAccountGetter interface {
+	getServiceAccount(ctx context.Context, ns, name string) (*corev1.ServiceAccount, error)
+}
+
+// pullSecretsGetter fetches the service accounts and image pull secrets of pod specs
+type pullSecretsGetter interface {
+	serviceAccountGetter
+	getSecrets(ctx context.Context, ns string, refs []corev1.LocalObjectReference) ([]*corev1.Secret, error)
+}
+
+// credentialRefsGetter fetches the service accounts of pod specs and the types of the dockerconfig secrets
+// by name, nil types when the secret metadata cannot be listed
+type credentialRefsGetter interface {
+	serviceAccountGetter
+	getSecretTypes(ctx context.Context, ns string) (map[string]corev1.SecretType, error)
+}
+
+func (r *cluster) getServiceAccount(ctx context.Context, ns, name string) (*corev1.ServiceAccount, error) {
+	return r.clientset.CoreV1().ServiceAccounts(ns).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (r *cluster) getSecrets(ctx context.Context, ns string, refs []corev1.LocalObjectReference) ([]*corev1.Secret, error) {
+	return r.ListByLocalObjectReferences(ctx, refs, ns)
+}
+
+func (r *cluster) getSecretTypes(ctx context.Context, ns string) (map[string]corev1.SecretType, error) {
+	if r.metadataClient == nil {
+		return nil, nil
+	}
+	return listSecretTypes(ctx, r.metadataClient, ns)
+}
+
+// listSecretTypes returns the types of the dockerconfig secrets of the namespace by name from a LIST of
+// their metadata only, nil when listing is forbidden
+func listSecretTypes(ctx context.Context, client metadata.Interface, ns string) (map[string]corev1.SecretType, error) {
+	types := make(map[string]corev1.SecretType)
+	for _, secretType := range dockerConfigSecretTypes {
+		list, err := client.Resource(corev1.SchemeGroupVersion.WithResource("secrets")).Namespace(ns).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("type", string(secretType)).String(),
+		})
+		if err != nil {
+			if k8sapierror.IsForbidden(err) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("listing secret metadata: %s: %w", ns, err)
+		}
+		for _, item := range list.Items {
+			types[item.Name] = secretType
+		}
+	}
+	return types, nil
+}
+
+// ListCredentialsByPodSpec returns the registry credentials of the pod spec with their provenance
+func (r *cluster) ListCredentialsByPodSpec(ctx context.Context, spec *corev1.PodSpec, ns string) (RegistryCredentials, error) {
+	return credentialsByPodSpec(ctx, r, r.credentialHelper, r.credentialProvider, spec, ns)
+}
+
+// podSpecPullSecrets returns the references of the image pull secrets of the pod spec in the kubelet order,
+// pod spec secrets then service account secrets, the secrets are not read
+func podSpecPullSecrets(ctx context.Context, getter serviceAccountGetter, spec *corev1.PodSpec, ns string) ([]CredentialRef, error) {
+	if spec == nil {
+		return nil, nil
+	}
+
+	var refs []CredentialRef
+	seen := make(map[string]struct{})
+	for _, ref := range spec.ImagePullSecrets {
+		if _, ok := seen[ref.Name]; !ok && ref.Name != "" {
+			seen[ref.Name] = struct{}{}
+			refs = append(refs, CredentialRef{Source: CredentialSourcePodSpec, Namespace: ns, SecretName: ref.Name})
+		}
+	}
+
+	serviceAccountName := spec.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = serviceAccountDefault
+	}
+	sa, err := getter.getServiceAccount(ctx, ns, serviceAccountName)
+	if err != nil && !k8sapierror.IsNotFound(err) && !k8sapierror.IsForbidden(err) {
+		return nil, fmt.Errorf("getting service account by name: %s/%s: %w", ns, serviceAccountName, err)
+	}
+	if err == nil && sa != nil {
+		for _, ref := range sa.ImagePullSecrets {
+			if _, ok := seen[ref.Name]; !ok && ref.Name != "" {
+				seen[ref.Name] = struct{}{}
+				refs = append(refs, CredentialRef{Source: CredentialSourceServiceAccount, Namespace: ns, SecretName: ref.Name, ServiceAccount: sa.Name})
+			}
+		}
+	}
+	return refs, nil
+}
+
+// credentialRefsByPodSpec returns the references of the image pull secrets of the pod spec without reading
+// the secrets, secrets missing from the dockerconfig secret metadata are left out when it can be listed
+func credentialRefsByPodSpec(ctx context.Context, getter credentialRefsGetter, spec *corev1.PodSpec, ns string) ([]CredentialRef, error) {
+	refs, err := podSpecPullSecrets(ctx, getter, spec, ns)
+	if err != nil || len(refs) == 0 {
+		return refs, err
+	}
+	types, err := getter.getSecretTypes(ctx, ns)
+	if err != nil {
+		return nil, err
+	}
+	if types == nil {
+		return refs, nil
+	}
+	known := make([]CredentialRef, 0, len(refs))
+	for _, ref := range refs {
+		secretType, ok := types[ref.SecretName]
+		if !ok {
+			continue
+		}
+		ref.Key = secretTypeDataKey(secretType)
+		known = append(known, ref)
+	}
+	return known, nil
+}
+
+func credentialsByPodSpec(ctx context.Context, getter pullSecretsGetter, helper *docker.CredentialHelper, provider *credentialprovider.Provider,
+	spec *corev1.PodSpec, ns string) (RegistryCredentials, error) {
+	creds := make(RegistryCredentials)
+	if spec == nil {
+		return creds, nil
+	}
+
+	pullSecrets, err := podSpecPullSecrets(ctx, getter, spec, ns)
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[string]CredentialRef, len(pullSecrets))
+	refs := make([]corev1.LocalObjectReference, 0, len(pullSecrets))
+	for _, ref := range pullSecrets {
+		sources[ref.SecretName] = ref
+		refs = append(refs, corev1.LocalObjectReference{Name: ref.SecretName})
+	}
+
+	secrets, err := getter.getSecrets(ctx, ns, refs)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		auths, err := readSecretAuths(ctx, secret, helper)
+		if err != nil {
+			return nil, err
+		}
+		// keep the order of the secret keys stable for equal registry keys
+		keys := make([]string, 0, len(auths))
+		for key := range auths {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			ref := sources[secret.Name]
+			ref.Namespace = secret.Namespace
+			ref.Key = secretDataKey(secret)
+			ref.Server = key
+			creds.add(key, Credential{Auth: auths[key], CredentialRef: ref})
+		}
+	}
+
+	if provider != nil {
+		appendCredentialProviderCredentials(ctx, provider, spec, creds)
+	}
+	return creds, nil
+}
+
+// appendCredentialProviderCredentials adds the credentials of the kubelet credential provider plugins
+// for the pod spec images, after the image pull secrets
+func appendCredentialProviderCredentials(ctx context.Context, provider *credentialprovider.Provider, spec *corev1.PodSpec, creds RegistryCredentials) {
+	provided := make(map[string]struct{})
+	for _, image := range podSpecImages(spec) {
+		providerAuths, err := provider.Provide(ctx, image)
+		if err != nil {
+			slog.Warn("Unable to get credentials from credential provider", "image", image, "error", err)
+		}
+		for key, auth := range providerAuths {
+			if _, ok := provided[key]; ok {
+				continue
+			}
+			provided[key] = struct{}{}
+			creds.add(key, Credential{Auth: auth, CredentialRef: CredentialRef{Source: CredentialSourceCredentialProvider, Server: key}})
+		}
+	}
+}
+
+// CredentialsByResource returns the registry credentials of the resource pod spec with their provenance
+func (r *cluster) CredentialsByResource(resource unstructured.Unstructured) (RegistryCredentials, error) {
+	podSpec, err := getWorkloadPodSpec(resource)
+	if err != nil {
+		return nil, err
+	}
+	return r.ListCredentialsByPodSpec(context.Background(), podSpec, resource.GetNamespace())
+}
+
+// CredentialRefsByResource returns the references of the image pull secrets of the resource pod spec,
+// the secrets are not read
+func (r *cluster) CredentialRefsByResource(resource unstructured.Unstructured) ([]CredentialRef, error) {
+	podSpec, err := getWorkloadPodSpec(resource)
+	if err != nil {
+		return nil, err
+	}
+	return credentialRefsByPodSpec(context.Background(), r, podSpec, resource.GetNamespace())
+}
+
+func secretDataKey(secret *corev1.Secret) string {
+	return secretTypeDataKey(secret.Type)
+}
+
+func secretTypeDataKey(secretType corev1.SecretType) string {
+	if secretType == corev1.SecretTypeDockercfg {
+		return corev1.DockerConfigKey
+	}
+	return corev1.DockerConfigJsonKey
+}
+
+// CredentialResolver resolves credential references, callers resolve them just before pulling images
+type CredentialResolver interface {
+	Resolve(ctx context.Context, ref CredentialRef) (docker.Auth, error)
+}
+
+type secretCredentialResolver struct {
+	clientset kubernetes.Interface
+	helper    *docker.CredentialHelper
+}
+
+// NewSecretCredentialResolver instansiate a resolver reading the referenced image pull secrets,
+// credential helpers of the secrets are resolved when a helper runner is given
+func NewSecretCredentialResolver(clientset kubernetes.Interface, helper *docker.CredentialHelper) CredentialResolver {
+	return &secretCredentialResolver{clientset: clientset, helper: helper}
+}
+
+func (r *secretCredentialResolver) Resolve(ctx context.Context, ref CredentialRef) (docker.Auth, error) {
+	if ref.SecretName == "" {
+		return docker.Auth{}, fmt.Errorf("credential reference for %q has no secret", ref.Server)
+	}
+	secret, err := r.clientset.CoreV1().Secrets(ref.Namespace).Get(ctx, ref.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return docker.Auth{}, fmt.Errorf("getting secret by name: %s/%s: %w", ref.Namespace, ref.SecretName, err)
+	}
+	return resolveSecretRef(ctx, secret, r.helper, ref)
+}
+
+// resolveSecretRef returns the credential of the secret the reference points to, the most specific
+// credential matching the image for references without server
+func resolveSecretRef(ctx context.Context, secret *corev1.Secret, helper *docker.CredentialHelper, ref CredentialRef) (docker.Auth, error) {
+	if ref.Key != "" && ref.Key != secretDataKey(secret) {
+		return docker.Auth{}, fmt.Errorf("secret %s/%s has no %s key", ref.Namespace, ref.SecretName, ref.Key)
+	}
+	auths, err := readSecretAuths(ctx, secret, helper)
+	if err != nil {
+		return docker.Auth{}, err
+	}
+	server := ref.Server
+	if server == "" {
+		keys := make([]string, 0, len(auths))
+		for key := range auths {
+			keys = append(keys, key)
+		}
+		matched, err := matchRegistryKeys(ref.Image, keys)
+		if err != nil {
+			return docker.Auth{}, err
+		}
+		if len(matched) == 0 {
+			return docker.Auth{}, fmt.Errorf("secret %s/%s has no credentials for %q", ref.Namespace, ref.SecretName, ref.Image)
+		}
+		server = matched[0]
+	}
+	auth, ok := auths[server]
+	if !ok {
+		return docker.Auth{}, fmt.Errorf("secret %s/%s has no credentials for %q", ref.Namespace, ref.SecretName, server)
+	}
+	return auth, nil
+}
+
+// ResolveCredentials resolves the credential references of each image with their provenance,
+// credentials are keyed by the server of the reference or else by the image repository.
+// References which cannot be resolved are skipped.
+func ResolveCredentials(ctx context.Context, resolver CredentialResolver, imageRefs map[string][]CredentialRef) RegistryCredentials {
+	creds := make(RegistryCredentials, len(imageRefs))
+	resolved := make(map[string]struct{})
+	for image, refs := range imageRefs {
+		for _, ref := range refs {
+			key := ref.Server
+			if key == "" {
+				repository, err := docker.GetRepositoryFromImageRef(image)
+				if err != nil {
+					slog.Warn("Unable to parse image reference, skipping", "image", image, "error", err)
+					break
+				}
+				key = repository
+			}
+			// images of the same repository share the credentials of a secret
+			id := key + "|" + ref.Namespace + "/" + ref.SecretName
+			if _, ok := resolved[id]; ok {
+				continue
+			}
+			resolved[id] = struct{}{}
+
+			auth, err := resolver.Resolve(ctx, ref)
+			if err != nil {
+				slog.Warn("Unable to resolve credential reference", "secret", ref.Namespace+"/"+ref.SecretName, "image", image, "error", err)
+				continue
+			}
+			creds.add(key, Credential{Auth: auth, CredentialRef: ref})
+		}
+	}
+	return creds
+}
+
+// CredentialResolver returns a resolver reading the image pull secrets of the cluster
+func (r *cluster) CredentialResolver() CredentialResolver {
+	return NewSecretCredentialResolver(r.clientset, r.credentialHelper)
+}
@@ -0,0 +1,280 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sapierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/credentialprovider"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+)
+
+// CredentialsCacheStats holds the lookups of a CredentialsCache
+type CredentialsCacheStats struct {
+	// Hits lookups of service accounts and secrets served from memory
+	Hits int
+	// Misses lookups which needed a request to the API server
+	Misses int
+	// Lists LIST requests of service accounts and secrets
+	Lists int
+}
+
+// namespaceObjects holds the service accounts, dockerconfig secrets and dockerconfig secret types of a namespace,
+// each listed on first use
+type namespaceObjects struct {
+	serviceAccounts lazyList[*corev1.ServiceAccount]
+	secrets         lazyList[*corev1.Secret]
+	secretTypes     lazyList[corev1.SecretType]
+}
+
+// lazyList holds objects by name listed on first use, a nil map when listing was forbidden.
+// The map is not modified once loaded and a failed LIST is retried on the next lookup.
+type lazyList[T any] struct {
+	mu     sync.Mutex
+	loaded bool
+	items  map[string]T
+}
+
+func (l *lazyList[T]) get(list func() (map[string]T, error)) (map[string]T, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.loaded {
+		return l.items, nil
+	}
+	items, err := list()
+	if err != nil {
+		return nil, err
+	}
+	l.items, l.loaded = items, true
+	return items, nil
+}
+
+// dockerConfigSecretTypes are the types of the secrets holding registry credentials, secrets are listed by type
+var dockerConfigSecretTypes = []corev1.SecretType{corev1.SecretTypeDockerConfigJson, corev1.SecretTypeDockercfg}
+
+// CredentialsCache resolves the registry credentials and credential references of resources for a scan
+type CredentialsCache interface {
+	// CredentialsByResource return the ordered registry credentials of the resource pod spec
+	CredentialsByResource(resource unstructured.Unstructured) (RegistryCredentials, error)
+	// CredentialRefsByResource return the references of the image pull secrets of the resource pod spec
+	// without reading the secrets
+	CredentialRefsByResource(resource unstructured.Unstructured) ([]CredentialRef, error)
+	// CredentialResolver return a resolver of the credential references from the cached secrets
+	CredentialResolver() CredentialResolver
+	// Stats return the lookups done so far
+	Stats() CredentialsCacheStats
+}
+
+// credentialsCache resolves the registry credentials and credential references of resources from a single LIST
+// of the service accounts and dockerconfig secrets of every namespace, it is meant to live for a scan.
+// Credential references only list the secret metadata. Objects are fetched one by one when listing is forbidden.
+type credentialsCache struct {
+	clientset      kubernetes.Interface
+	metadataClient metadata.Interface
+	helper         *docker.CredentialHelper
+	provider       *credentialprovider.Provider
+
+	mu         sync.Mutex
+	namespaces map[string]*namespaceObjects
+	stats      CredentialsCacheStats
+}
+
+func newCredentialsCache(clientset kubernetes.Interface, metadataClient metadata.Interface, helper *docker.CredentialHelper,
+	provider *credentialprovider.Provider) *credentialsCache {
+	return &credentialsCache{
+		clientset:      clientset,
+		metadataClient: metadataClient,
+		helper:         helper,
+		provider:       provider,
+		namespaces:     make(map[string]*namespaceObjects),
+	}
+}
+
+// NewCredentialsCache instansiate a credentials cache for a scan of the cluster
+func (r *cluster) NewCredentialsCache() CredentialsCache {
+	return newCredentialsCache(r.clientset, r.metadataClient, r.credentialHelper, r.credentialProvider)
+}
+
+// CredentialsByResource returns the registry credentials of the resource pod spec with their provenance
+func (c *credentialsCache) CredentialsByResource(resource unstructured.Unstructured) (RegistryCredentials, error) {
+	podSpec, err := getWorkloadPodSpec(resource)
+	if err != nil {
+		return nil, err
+	}
+	return credentialsByPodSpec(context.Background(), c, c.helper, c.provider, podSpec, resource.GetNamespace())
+}
+
+// CredentialRefsByResource returns the references of the image pull secrets of the resource pod spec,
+// the secrets are not read
+func (c *credentialsCache) CredentialRefsByResource(resource unstructured.Unstructured) ([]CredentialRef, error) {
+	podSpec, err := getWorkloadPodSpec(resource)
+	if err != nil {
+		return nil, err
+	}
+	return credentialRefsByPodSpec(context.Background(), c, podSpec, resource.GetNamespace())
+}
+
+// CredentialResolver returns the cache itself, references are resolved from the listed secrets
+func (c *credentialsCache) CredentialResolver() CredentialResolver {
+	return c
+}
+
+// Resolve returns the credential of the reference from the listed secrets
+func (c *credentialsCache) Resolve(ctx context.Context, ref CredentialRef) (docker.Auth, error) {
+	if ref.SecretName == "" {
+		return docker.Auth{}, fmt.Errorf("credential reference for %q has no secret", ref.Server)
+	}
+	secrets, err := c.getSecrets(ctx, ref.Namespace, []corev1.LocalObjectReference{{Name: ref.SecretName}})
+	if err != nil {
+		return docker.Auth{}, err
+	}
+	if len(secrets) == 0 {
+		return docker.Auth{}, fmt.Errorf("getting secret by name: %s/%s: not found", ref.Namespace, ref.SecretName)
+	}
+	return resolveSecretRef(ctx, secrets[0], c.helper, ref)
+}
+
+// Stats returns the lookups done so far
+func (c *credentialsCache) Stats() CredentialsCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+func (c *credentialsCache) getServiceAccount(ctx context.Context, ns, name string) (*corev1.ServiceAccount, error) {
+	serviceAccounts, err := c.namespace(ns).serviceAccounts.get(func() (map[string]*corev1.ServiceAccount, error) {
+		return c.listServiceAccounts(ctx, ns)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serviceAccounts != nil {
+		c.count(&c.stats.Hits)
+		if sa, ok := serviceAccounts[name]; ok {
+			return sa, nil
+		}
+		return nil, k8sapierror.NewNotFound(schema.GroupResource{Resource: "serviceaccounts"}, name)
+	}
+
+	c.count(&c.stats.Misses)
+	return c.clientset.CoreV1().ServiceAccounts(ns).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c *credentialsCache) getSecrets(ctx context.Context, ns string, refs []corev1.LocalObjectReference) ([]*corev1.Secret, error) {
+	listed, err := c.namespace(ns).secrets.get(func() (map[string]*corev1.Secret, error) {
+		return c.listSecrets(ctx, ns)
+	})
+	if err != nil {
+		return nil, err
+	}
+	secrets := make([]*corev1.Secret, 0, len(refs))
+	for _, ref := range refs {
+		if ref.Name == "" {
+			continue
+		}
+		if listed != nil {
+			c.count(&c.stats.Hits)
+			if secret, ok := listed[ref.Name]; ok {
+				secrets = append(secrets, secret)
+			}
+			continue
+		}
+
+		c.count(&c.stats.Misses)
+		secret, err := c.clientset.CoreV1().Secrets(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			if k8sapierror.IsNotFound(err) || k8sapierror.IsForbidden(err) {
+				continue
+			}
+			return nil, fmt.Errorf("getting secret by name: %s/%s: %w", ns, ref.Name, err)
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+func (c *credentialsCache) getSecretTypes(ctx context.Context, ns string) (map[string]corev1.SecretType, error) {
+	if c.metadataClient == nil {
+		return nil, nil
+	}
+	types, err := c.namespace(ns).secretTypes.get(func() (map[string]corev1.SecretType, error) {
+		c.mu.Lock()
+		c.stats.Lists += len(dockerConfigSecretTypes)
+		c.mu.Unlock()
+		return listSecretTypes(ctx, c.metadataClient, ns)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// count increments a counter of the stats
+func (c *credentialsCache) count(counter *int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*counter++
+}
+
+// namespace returns the objects of the namespace, the lock is only held to get the entry of the namespace
+// so that lookups of other namespaces are not blocked by the LISTs
+func (c *credentialsCache) namespace(ns string) *namespaceObjects {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, ok := c.namespaces[ns]
+	if !ok {
+		objects = &namespaceObjects{}
+		c.namespaces[ns] = objects
+	}
+	return objects
+}
+
+func (c *credentialsCache) listServiceAccounts(ctx context.Context, ns string) (map[string]*corev1.ServiceAccount, error) {
+	c.count(&c.stats.Lists)
+	saList, err := c.clientset.CoreV1().ServiceAccounts(ns).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if k8sapierror.IsForbidden(err) {
+			// service accounts are fetched one by one
+			return nil, nil
+		}
+		return nil, fmt.Errorf("listing service accounts: %s: %w", ns, err)
+	}
+	serviceAccounts := make(map[string]*corev1.ServiceAccount, len(saList.Items))
+	for i := range saList.Items {
+		serviceAccounts[saList.Items[i].Name] = &saList.Items[i]
+	}
+	return serviceAccounts, nil
+}
+
+func (c *credentialsCache) listSecrets(ctx context.Context, ns string) (map[string]*corev1.Secret, error) {
+	secrets := make(map[string]*corev1.Secret)
+	for _, secretType := range dockerConfigSecretTypes {
+		c.count(&c.stats.Lists)
+		secretList, err := c.clientset.CoreV1().Secrets(ns).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("type", string(secretType)).String(),
+		})
+		if err != nil {
+			if k8sapierror.IsForbidden(err) {
+				// secrets are fetched one by one
+				return nil, nil
+			}
+			return nil, fmt.Errorf("listing secrets: %s: %w", ns, err)
+		}
+		for i := range secretList.Items {
+			// the type is checked again for API servers ignoring the field selector
+			if secret := &secretList.Items[i]; secret.Type == secretType {
+				secrets[secret.Name] = secret
+			}
+		}
+	}
+	return secrets, nil
+}
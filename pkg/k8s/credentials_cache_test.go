@@ -0,0 +1,195 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8sapierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+)
+
+func pullSecret(ns, name, server, username string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(fmt.Sprintf(
+			`{"auths":{%q:{"username":%q,"password":"pass"}}}`, server, username))},
+	}
+}
+
+func podWithPullSecrets(ns, name string, secrets ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "registry.example.com/app:1.0"}}},
+	}
+	for _, secret := range secrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: secret})
+	}
+	return pod
+}
+
+func TestCredentialsCache(t *testing.T) {
+	objects := []runtime.Object{
+		&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Namespace: "default", Name: "default"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "sa-regcred"}},
+		},
+		pullSecret("default", "regcred", "registry.example.com", "pod-spec"),
+		pullSecret("default", "sa-regcred", "registry.example.com", "service-account"),
+		pullSecret("team-a", "regcred", "registry.example.com", "team-a"),
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "token"}, Type: corev1.SecretTypeOpaque},
+	}
+
+	tests := []struct {
+		name       string
+		forbidList bool
+		wantStats  CredentialsCacheStats
+		wantGets   int
+	}{
+		{
+			name: "namespaces are listed once",
+			// the service accounts and the secrets of each dockerconfig type
+			wantStats: CredentialsCacheStats{Hits: 12, Misses: 0, Lists: 6},
+			wantGets:  0,
+		},
+		{
+			name:       "objects are fetched when listing is forbidden",
+			forbidList: true,
+			wantStats:  CredentialsCacheStats{Hits: 0, Misses: 12, Lists: 4},
+			wantGets:   12,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewClientset(objects...)
+			var gets int
+			clientset.PrependReactor("get", "*", func(k8stesting.Action) (bool, runtime.Object, error) {
+				gets++
+				return false, nil, nil
+			})
+			var secretSelectors []string
+			clientset.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				secretSelectors = append(secretSelectors, action.(k8stesting.ListAction).GetListRestrictions().Fields.String())
+				return false, nil, nil
+			})
+			if tt.forbidList {
+				clientset.PrependReactor("list", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, k8sapierror.NewForbidden(schema.GroupResource{Resource: action.GetResource().Resource}, "", fmt.Errorf("forbidden"))
+				})
+			}
+			cache := newCredentialsCache(clientset, nil, nil, nil)
+
+			// 3 lookups per pod: the service account and two secrets
+			for i := 0; i < 3; i++ {
+				creds, err := cache.CredentialsByResource(toUnstructured(t, KindPod, podWithPullSecrets("default", fmt.Sprintf("app-%d", i), "regcred")))
+				require.NoError(t, err)
+				var usernames []string
+				for _, cred := range creds["registry.example.com"] {
+					usernames = append(usernames, cred.Username)
+				}
+				assert.Equal(t, []string{"pod-spec", "service-account"}, usernames)
+			}
+			creds, err := cache.CredentialsByResource(toUnstructured(t, KindPod, podWithPullSecrets("team-a", "app", "regcred", "missing")))
+			require.NoError(t, err)
+			assert.Equal(t, RegistryCredentials{"registry.example.com": {{
+				Auth:          docker.Auth{Username: "team-a", Password: "pass"},
+				CredentialRef: CredentialRef{Source: CredentialSourcePodSpec, Namespace: "team-a", SecretName: "regcred", Key: corev1.DockerConfigJsonKey, Server: "registry.example.com"},
+			}}}, creds)
+
+			assert.Equal(t, tt.wantStats, cache.Stats())
+			assert.Equal(t, tt.wantGets, gets)
+			// only the secrets holding registry credentials are listed
+			if !tt.forbidList {
+				assert.Len(t, secretSelectors, 4)
+			}
+			for _, selector := range secretSelectors {
+				assert.Contains(t, []string{"type=kubernetes.io/dockerconfigjson", "type=kubernetes.io/dockercfg"}, selector)
+			}
+		})
+	}
+}
+
+// secretMetadataClient serves the secret metadata of each dockerconfig type by the field selector of the LIST
+func secretMetadataClient(names map[corev1.SecretType][]string, lists *int) *metadatafake.FakeMetadataClient {
+	client := metadatafake.NewSimpleMetadataClient(metadatafake.NewTestScheme())
+	client.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		*lists++
+		list := &metav1.List{}
+		for secretType, secretNames := range names {
+			if action.(k8stesting.ListAction).GetListRestrictions().Fields.String() != "type="+string(secretType) {
+				continue
+			}
+			for _, name := range secretNames {
+				list.Items = append(list.Items, runtime.RawExtension{Object: &metav1.PartialObjectMetadata{
+					ObjectMeta: metav1.ObjectMeta{Namespace: action.GetNamespace(), Name: name},
+				}})
+			}
+		}
+		return true, list, nil
+	})
+	return client
+}
+
+func TestCredentialsCacheRefs(t *testing.T) {
+	clientset := fake.NewClientset(
+		&corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Namespace: "default", Name: "default"},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: "sa-regcred"}, {Name: "regcred"}},
+		},
+		pullSecret("default", "regcred", "registry.example.com", "pod-spec"),
+		pullSecret("default", "sa-regcred", "https://registry.example.com/v1/", "service-account"),
+	)
+	var secretReads int
+	clientset.PrependReactor("*", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		secretReads++
+		return false, nil, nil
+	})
+	var metadataLists int
+	cache := newCredentialsCache(clientset, secretMetadataClient(map[corev1.SecretType][]string{
+		corev1.SecretTypeDockerConfigJson: {"regcred", "sa-regcred"},
+		corev1.SecretTypeDockercfg:        {"legacy"},
+	}, &metadataLists), nil, nil)
+
+	pod := podWithPullSecrets("default", "app", "regcred", "missing", "legacy")
+	for i := 0; i < 2; i++ {
+		refs, err := cache.CredentialRefsByResource(toUnstructured(t, KindPod, pod))
+		require.NoError(t, err)
+		assert.Equal(t, []CredentialRef{
+			{Source: CredentialSourcePodSpec, Namespace: "default", SecretName: "regcred", Key: corev1.DockerConfigJsonKey},
+			{Source: CredentialSourcePodSpec, Namespace: "default", SecretName: "legacy", Key: corev1.DockerConfigKey},
+			{Source: CredentialSourceServiceAccount, Namespace: "default", SecretName: "sa-regcred", Key: corev1.DockerConfigJsonKey, ServiceAccount: "default"},
+		}, refs)
+	}
+	// references are built from the secret metadata listed once, secrets are not read
+	assert.Equal(t, 2, metadataLists)
+	assert.Zero(t, secretReads)
+
+	refs, err := cache.CredentialRefsByResource(toUnstructured(t, KindPod, pod))
+	require.NoError(t, err)
+	imageRefs := map[string][]CredentialRef{}
+	for _, image := range []string{"registry.example.com/app:1.0", "registry.example.com/app:2.0"} {
+		for _, ref := range refs {
+			ref.Image = image
+			imageRefs[image] = append(imageRefs[image], ref)
+		}
+	}
+	creds := ResolveCredentials(context.Background(), cache.CredentialResolver(), imageRefs)
+	var usernames []string
+	for _, cred := range creds["registry.example.com/app"] {
+		usernames = append(usernames, cred.Username)
+	}
+	assert.Equal(t, []string{"pod-spec", "service-account"}, usernames)
+	// the secrets are listed once to resolve the references of both images
+	assert.Equal(t, 2, secretReads)
+}
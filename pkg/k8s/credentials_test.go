@@ -0,0 +1,303 @@
+package k8s
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/credentialprovider"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+)
+
+func TestMatchCredentials(t *testing.T) {
+	creds := RegistryCredentials{
+		"registry.example.com": {
+			{Auth: docker.Auth{Username: "pod-spec"}, CredentialRef: CredentialRef{Source: CredentialSourcePodSpec, Namespace: "default", SecretName: "regcred"}},
+			{Auth: docker.Auth{Username: "service-account"}, CredentialRef: CredentialRef{Source: CredentialSourceServiceAccount, Namespace: "default", SecretName: "sa-regcred", ServiceAccount: "default"}},
+		},
+		"registry.example.com/team-a": {
+			{Auth: docker.Auth{Username: "team-a"}, CredentialRef: CredentialRef{Source: CredentialSourceServiceAccount, Namespace: "default", SecretName: "team-a", ServiceAccount: "default"}},
+		},
+		"*.example.com": {
+			{Auth: docker.Auth{Username: "provider"}, CredentialRef: CredentialRef{Source: CredentialSourceCredentialProvider}},
+		},
+	}
+	tests := []struct {
+		name  string
+		image string
+		want  []string
+	}{
+		{
+			name:  "most specific key first, secrets in order within a key",
+			image: "registry.example.com/team-a/app:1.0",
+			want:  []string{"team-a", "pod-spec", "service-account", "provider"},
+		},
+		{
+			name:  "no path match",
+			image: "registry.example.com/team-b/app:1.0",
+			want:  []string{"pod-spec", "service-account", "provider"},
+		},
+		{
+			name:  "no match",
+			image: "nginx:1.25",
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MatchCredentials(tt.image, creds)
+			require.NoError(t, err)
+			var usernames []string
+			for _, cred := range got {
+				usernames = append(usernames, cred.Username)
+			}
+			assert.Equal(t, tt.want, usernames)
+		})
+	}
+}
+
+// ecrPlugin is a kubelet credential provider plugin returning credentials keyed by the ecr glob
+const ecrPlugin = `#!/bin/sh
+cat > /dev/null
+echo '{"apiVersion":"credentialprovider.kubelet.k8s.io/v1","kind":"CredentialProviderResponse","cacheKeyType":"Registry","cacheDuration":"1h","auth":{"*.dkr.ecr.*.amazonaws.com":{"username":"AWS","password":"ecr-token"}}}'
+`
+
+const ecrProviderConfig = `
+apiVersion: kubelet.config.k8s.io/v1
+kind: CredentialProviderConfig
+providers:
+  - name: ecr-credential-provider
+    matchImages: ["*.dkr.ecr.*.amazonaws.com"]
+    defaultCacheDuration: "12h"
+    apiVersion: credentialprovider.kubelet.k8s.io/v1
+`
+
+func TestListCredentialsByPodSpecCredentialProvider(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ecr-credential-provider"), []byte(ecrPlugin), 0o755))
+	config, err := credentialprovider.ReadConfig([]byte(ecrProviderConfig))
+	require.NoError(t, err)
+
+	c := &cluster{
+		clientset: fake.NewClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "regcred"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{
+				"registry.example.com":{"username":"user","password":"pass"}}}`)},
+		}),
+		credentialProvider: credentialprovider.NewProvider(config, dir),
+	}
+	ecrImage := "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app:1.0"
+	spec := &corev1.PodSpec{
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "regcred"}},
+		Containers: []corev1.Container{
+			{Name: "app", Image: ecrImage},
+			{Name: "sidecar", Image: "registry.example.com/sidecar:1.0"},
+		},
+	}
+
+	auths, err := c.ListImagePullSecretsByPodSpec(context.Background(), spec, "default")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]docker.Auth{
+		"registry.example.com":      {Username: "user", Password: "pass"},
+		"*.dkr.ecr.*.amazonaws.com": {Auth: docker.NewBasicAuth("AWS", "ecr-token"), Username: "AWS", Password: "ecr-token"},
+	}, auths)
+
+	creds, err := c.ListCredentialsByPodSpec(context.Background(), spec, "default")
+	require.NoError(t, err)
+	tests := []struct {
+		image    string
+		wantRefs []CredentialRef
+	}{
+		{
+			image:    ecrImage,
+			wantRefs: []CredentialRef{{Source: CredentialSourceCredentialProvider, Server: "*.dkr.ecr.*.amazonaws.com"}},
+		},
+		{
+			image: "registry.example.com/sidecar:1.0",
+			wantRefs: []CredentialRef{{Source: CredentialSourcePodSpec, Namespace: "default", SecretName: "regcred",
+				Key: corev1.DockerConfigJsonKey, Server: "registry.example.com"}},
+		},
+		{
+			// the glob matches the same number of host labels only
+			image: "123456789012.dkr.ecr.eu-west-1.amazonaws.com.cn/app:1.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			matched, err := MatchCredentials(tt.image, creds)
+			require.NoError(t, err)
+			var refs []CredentialRef
+			for _, cred := range matched {
+				refs = append(refs, cred.CredentialRef)
+			}
+			assert.Equal(t, tt.wantRefs, refs)
+		})
+	}
+}
+
+func TestRegistryCredentialsAuths(t *testing.T) {
+	creds := RegistryCredentials{
+		"registry.example.com": {
+			{Auth: docker.Auth{Username: "first", Password: "pass,with,commas"}, CredentialRef: CredentialRef{Source: CredentialSourcePodSpec}},
+			{Auth: docker.Auth{Username: "second", Password: "other"}, CredentialRef: CredentialRef{Source: CredentialSourceServiceAccount}},
+			{Auth: docker.Auth{Username: "third", Password: "provided"}, CredentialRef: CredentialRef{Source: CredentialSourceCredentialProvider}},
+		},
+		"quay.io": {
+			{Auth: docker.Auth{Auth: "cXVheTpwYXNz", Username: "quay", Password: "pass"}},
+		},
+	}
+
+	assert.Equal(t, map[string]docker.Auth{
+		"registry.example.com": {Username: "first", Password: "pass,with,commas"},
+		"quay.io":              {Auth: "cXVheTpwYXNz", Username: "quay", Password: "pass"},
+	}, creds.Auths())
+	assert.Equal(t, map[string]docker.Auth{
+		// service account secrets first as before the ordered credentials
+		"registry.example.com": {Username: "second,first,third", Password: "other,pass,with,commas,provided"},
+		"quay.io":              {Auth: "cXVheTpwYXNz", Username: "quay", Password: "pass"},
+	}, creds.JoinedAuths())
+}
+
+func TestReadSecretAuths(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret *corev1.Secret
+		want   map[string]docker.Auth
+	}{
+		{
+			name: "dockerconfigjson keys are normalized",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "regcred"},
+				Type:       corev1.SecretTypeDockerConfigJson,
+				Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{
+					"https://index.docker.io/v1/":{"username":"hub","password":"pass"},
+					"registry.example.com/team-a/":{"username":"team-a","password":"pass"}}}`)},
+			},
+			want: map[string]docker.Auth{
+				"index.docker.io":             {Username: "hub", Password: "pass"},
+				"registry.example.com/team-a": {Username: "team-a", Password: "pass"},
+			},
+		},
+		{
+			name: "missing data",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "regcred"},
+				Type:       corev1.SecretTypeDockerConfigJson,
+			},
+			want: nil,
+		},
+		{
+			name: "other secret type",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "token"},
+				Type:       corev1.SecretTypeOpaque,
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readSecretAuths(context.Background(), tt.secret, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSecretCredentialResolver(t *testing.T) {
+	clientset := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "regcred"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{
+			"https://index.docker.io/v1/":{"username":"hub","password":"pass"}}}`)},
+	})
+	resolver := NewSecretCredentialResolver(clientset, nil)
+
+	tests := []struct {
+		name    string
+		ref     CredentialRef
+		want    docker.Auth
+		wantErr string
+	}{
+		{
+			name: "resolved",
+			ref:  CredentialRef{Source: CredentialSourcePodSpec, Namespace: "default", SecretName: "regcred", Key: corev1.DockerConfigJsonKey, Server: "index.docker.io"},
+			want: docker.Auth{Username: "hub", Password: "pass"},
+		},
+		{
+			name: "matched by image",
+			ref:  CredentialRef{Source: CredentialSourcePodSpec, Namespace: "default", SecretName: "regcred", Key: corev1.DockerConfigJsonKey, Image: "nginx:1.25"},
+			want: docker.Auth{Username: "hub", Password: "pass"},
+		},
+		{
+			name:    "image without credentials",
+			ref:     CredentialRef{Namespace: "default", SecretName: "regcred", Image: "quay.io/app:1.0"},
+			wantErr: `secret default/regcred has no credentials for "quay.io/app:1.0"`,
+		},
+		{
+			name:    "unknown server",
+			ref:     CredentialRef{Namespace: "default", SecretName: "regcred", Key: corev1.DockerConfigJsonKey, Server: "quay.io"},
+			wantErr: `secret default/regcred has no credentials for "quay.io"`,
+		},
+		{
+			name:    "other key",
+			ref:     CredentialRef{Namespace: "default", SecretName: "regcred", Key: corev1.DockerConfigKey, Server: "index.docker.io"},
+			wantErr: "secret default/regcred has no .dockercfg key",
+		},
+		{
+			name:    "missing secret",
+			ref:     CredentialRef{Namespace: "default", SecretName: "deleted", Server: "index.docker.io"},
+			wantErr: "getting secret by name: default/deleted",
+		},
+		{
+			name:    "credential provider",
+			ref:     CredentialRef{Source: CredentialSourceCredentialProvider, Server: "*.dkr.ecr.*.amazonaws.com"},
+			wantErr: "has no secret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolver.Resolve(context.Background(), tt.ref)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	refs := map[string][]CredentialRef{"nginx:1.25": {
+		{Source: CredentialSourcePodSpec, Namespace: "default", SecretName: "regcred", Key: corev1.DockerConfigJsonKey, Image: "nginx:1.25"},
+		{Source: CredentialSourceServiceAccount, Namespace: "default", SecretName: "deleted", Image: "nginx:1.25"},
+	}}
+	assert.Equal(t, RegistryCredentials{"index.docker.io/library/nginx": {
+		{Auth: docker.Auth{Username: "hub", Password: "pass"}, CredentialRef: refs["nginx:1.25"][0]},
+	}}, ResolveCredentials(context.Background(), resolver, refs))
+}
+
+func TestWithCredentialHelper(t *testing.T) {
+	helper := docker.NewCredentialHelper()
+
+	// helper names read from the secrets never select a helper on their own
+	c := &cluster{}
+	require.NoError(t, newClusterOptions(WithCredentialHelper(helper)).applyCredentials(c))
+	assert.Nil(t, c.credentialHelper)
+
+	c = &cluster{}
+	require.NoError(t, newClusterOptions(WithCredentialHelper(helper, "ecr-login")).applyCredentials(c))
+	require.NotNil(t, c.credentialHelper)
+	_, err := c.credentialHelper.Get(context.Background(), "evil", "registry.example.com")
+	assert.ErrorIs(t, err, docker.ErrHelperNotAllowed)
+	// the runner of the caller is left untouched
+	_, err = helper.Get(context.Background(), "evil", "registry.example.com")
+	assert.NotErrorIs(t, err, docker.ErrHelperNotAllowed)
+}
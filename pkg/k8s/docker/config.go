@@ -53,6 +53,10 @@ func (v Auth) String() string {
 // Config represents Docker configuration which is typically saved as `~/.docker/config.json`.
 type Config struct {
 	Auths map[string]Auth `json:"auths"`
+	// CredsStore is the credential helper storing the credentials of all the servers
+	CredsStore string `json:"credsStore,omitempty"`
+	// CredHelpers maps a registry server to the credential helper storing its credentials
+	CredHelpers map[string]string `json:"credHelpers,omitempty"`
 }
 
 func (c *Config) Read(contents []byte, isLegacy bool) error {
@@ -0,0 +1,251 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	credentialHelperPrefix = "docker-credential-"
+	defaultHelperTimeout   = 10 * time.Second
+	// credentialsNotFound is the error message of the helpers when the server is unknown
+	credentialsNotFound = "credentials not found in native keychain"
+)
+
+// ErrCredentialsNotFound is returned when a credential helper has no credentials for a server
+var ErrCredentialsNotFound = errors.New(credentialsNotFound)
+
+// ErrInvalidHelperName is returned for a helper name that is not a plain binary suffix, the name comes from
+// the image pull secrets and must not select a binary outside of the helpers
+var ErrInvalidHelperName = errors.New("invalid credential helper name")
+
+// ErrHelperNotAllowed is returned for a helper missing from the allowed helpers of the runner
+var ErrHelperNotAllowed = errors.New("credential helper not allowed")
+
+var helperNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// CredentialHelper runs Docker credential helpers (docker-credential-<name>)
+// using the standard stdin/stdout protocol.
+// See https://github.com/docker/docker-credential-helpers
+type CredentialHelper struct {
+	// path is the directory of the helper binaries, PATH is used when empty
+	path    string
+	timeout time.Duration
+	// credsStore allows the credsStore of the configs, it lists and returns every credential of the store
+	credsStore bool
+	// allowed are the names of the helpers which may run, every helper when nil
+	allowed map[string]struct{}
+}
+
+type HelperOption func(*CredentialHelper)
+
+// WithHelperPath sets the directory of the helper binaries
+func WithHelperPath(path string) HelperOption {
+	return func(h *CredentialHelper) {
+		h.path = path
+	}
+}
+
+// WithHelperTimeout sets the timeout of a single helper execution, default 10s
+func WithHelperTimeout(timeout time.Duration) HelperOption {
+	return func(h *CredentialHelper) {
+		h.timeout = timeout
+	}
+}
+
+// WithCredsStore resolves the credsStore of the configs, ignored by default as the store returns all the
+// credentials of the host keychain to whoever can write an image pull secret
+func WithCredsStore(enabled bool) HelperOption {
+	return func(h *CredentialHelper) {
+		h.credsStore = enabled
+	}
+}
+
+// WithAllowedHelpers runs only the named helpers, the helper names of image pull secrets are chosen
+// by whoever can write a secret and must never select a helper on their own
+func WithAllowedHelpers(names ...string) HelperOption {
+	return func(h *CredentialHelper) {
+		h.allowed = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			h.allowed[name] = struct{}{}
+		}
+	}
+}
+
+// NewCredentialHelper instansiate a new credential helper runner
+func NewCredentialHelper(opts ...HelperOption) *CredentialHelper {
+	h := &CredentialHelper{
+		timeout: defaultHelperTimeout,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// With returns a copy of the runner with the options applied
+func (h *CredentialHelper) With(opts ...HelperOption) *CredentialHelper {
+	c := *h
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+type helperCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// Get returns the credentials stored by the named helper for the server
+func (h *CredentialHelper) Get(ctx context.Context, helper, server string) (Auth, error) {
+	out, err := h.run(ctx, helper, "get", server)
+	if err != nil {
+		return Auth{}, err
+	}
+	var creds helperCredentials
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return Auth{}, fmt.Errorf("decoding %s%s output: %w", credentialHelperPrefix, helper, err)
+	}
+	return Auth{
+		Auth:     NewBasicAuth(creds.Username, creds.Secret),
+		Username: creds.Username,
+		Password: creds.Secret,
+	}, nil
+}
+
+// List returns the servers the named helper stores credentials for
+func (h *CredentialHelper) List(ctx context.Context, helper string) ([]string, error) {
+	out, err := h.run(ctx, helper, "list", "")
+	if err != nil {
+		return nil, err
+	}
+	servers := make(map[string]string)
+	if err := json.Unmarshal(out, &servers); err != nil {
+		return nil, fmt.Errorf("decoding %s%s output: %w", credentialHelperPrefix, helper, err)
+	}
+	result := make([]string, 0, len(servers))
+	for server := range servers {
+		result = append(result, server)
+	}
+	return result, nil
+}
+
+func (h *CredentialHelper) run(ctx context.Context, helper, action, input string) ([]byte, error) {
+	bin, err := h.lookup(helper)
+	if err != nil {
+		return nil, err
+	}
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, action)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// do not wait for children of a killed helper holding the output pipes
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("running %s %s: %w", bin, action, ctx.Err())
+		}
+		// helpers report errors on stdout
+		msg := strings.TrimSpace(stdout.String() + " " + stderr.String())
+		if strings.Contains(msg, credentialsNotFound) {
+			return nil, ErrCredentialsNotFound
+		}
+		return nil, fmt.Errorf("running %s %s: %w: %s", bin, action, err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (h *CredentialHelper) lookup(helper string) (string, error) {
+	if !validHelperName(helper) {
+		return "", fmt.Errorf("%w %q", ErrInvalidHelperName, helper)
+	}
+	if _, ok := h.allowed[helper]; h.allowed != nil && !ok {
+		return "", fmt.Errorf("%w %q", ErrHelperNotAllowed, helper)
+	}
+	name := credentialHelperPrefix + helper
+	if h.path == "" {
+		return exec.LookPath(name)
+	}
+	bin := filepath.Join(h.path, name)
+	if _, err := os.Stat(bin); err != nil {
+		return "", fmt.Errorf("credential helper %q: %w", name, err)
+	}
+	return bin, nil
+}
+
+// validHelperName returns true for a name made of letters, digits, dots, underscores and dashes only
+func validHelperName(helper string) bool {
+	return helperNamePattern.MatchString(helper) && !strings.Contains(helper, "..")
+}
+
+// HasCredentialHelpers returns true when the config references credsStore or credHelpers
+func (c Config) HasCredentialHelpers() bool {
+	return c.CredsStore != "" || len(c.CredHelpers) > 0
+}
+
+// ResolveAuths returns the config auths merged with the credentials resolved by the helpers,
+// credHelpers take precedence over credsStore, which takes precedence over auths.
+// credsStore is resolved only when the helper is created WithCredsStore.
+// Helpers failures are joined in the returned error together with the resolved auths.
+func (c Config) ResolveAuths(ctx context.Context, helper *CredentialHelper) (map[string]Auth, error) {
+	auths := make(map[string]Auth, len(c.Auths))
+	for server, auth := range c.Auths {
+		auths[server] = auth
+	}
+	var errs []error
+	if c.CredsStore != "" && helper.credsStore {
+		servers, err := helper.List(ctx, c.CredsStore)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, server := range servers {
+			auth, err := helper.Get(ctx, c.CredsStore, server)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			auths[server] = auth
+		}
+	}
+	for server, name := range c.CredHelpers {
+		auth, err := helper.Get(ctx, name, server)
+		if err != nil {
+			if !errors.Is(err, ErrCredentialsNotFound) {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		auths[server] = auth
+	}
+	return auths, errors.Join(errs...)
+}
+
+// LoadConfigFile reads a Docker configuration file, typically `~/.docker/config.json`
+func LoadConfigFile(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := c.Read(b, false); err != nil {
+		return nil, fmt.Errorf("reading docker config %q: %w", path, err)
+	}
+	return c, nil
+}
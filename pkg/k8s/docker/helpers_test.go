@@ -0,0 +1,215 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubHelper is a credential helper storing credentials for ecr and gcr servers
+const stubHelper = `#!/bin/sh
+read -r server
+case "$1" in
+  list)
+    echo '{"123456789012.dkr.ecr.eu-west-1.amazonaws.com":"AWS","gcr.io":"_json_key"}'
+    ;;
+  get)
+    case "$server" in
+      123456789012.dkr.ecr.eu-west-1.amazonaws.com) echo '{"ServerURL":"'$server'","Username":"AWS","Secret":"ecr-token"}' ;;
+      gcr.io) echo '{"ServerURL":"'$server'","Username":"_json_key","Secret":"gcr-key"}' ;;
+      *) echo "credentials not found in native keychain"; exit 1 ;;
+    esac
+    ;;
+esac
+`
+
+func writeHelper(t *testing.T, dir, name, content string) {
+	err := os.WriteFile(filepath.Join(dir, credentialHelperPrefix+name), []byte(content), 0o755)
+	require.NoError(t, err)
+}
+
+func TestCredentialHelper_Get(t *testing.T) {
+	dir := t.TempDir()
+	writeHelper(t, dir, "stub", stubHelper)
+	writeHelper(t, dir, "slow", "#!/bin/sh\nsleep 5\n")
+	writeHelper(t, dir, "broken", "#!/bin/sh\necho 'not json'\n")
+
+	testCases := []struct {
+		name          string
+		helper        string
+		server        string
+		expectedAuth  Auth
+		expectedError string
+	}{
+		{
+			name:         "Should return credentials of the server",
+			helper:       "stub",
+			server:       "gcr.io",
+			expectedAuth: Auth{Auth: NewBasicAuth("_json_key", "gcr-key"), Username: "_json_key", Password: "gcr-key"},
+		},
+		{
+			name:          "Should return not found error for unknown server",
+			helper:        "stub",
+			server:        "quay.io",
+			expectedError: ErrCredentialsNotFound.Error(),
+		},
+		{
+			name:          "Should return error for missing helper",
+			helper:        "missing",
+			server:        "gcr.io",
+			expectedError: "docker-credential-missing",
+		},
+		{
+			name:          "Should reject helper name with path",
+			helper:        "x/../../../usr/bin/foo",
+			server:        "gcr.io",
+			expectedError: ErrInvalidHelperName.Error(),
+		},
+		{
+			name:          "Should reject helper name with backslash",
+			helper:        `stub\..\foo`,
+			server:        "gcr.io",
+			expectedError: ErrInvalidHelperName.Error(),
+		},
+		{
+			name:          "Should reject helper name with dots",
+			helper:        "..",
+			server:        "gcr.io",
+			expectedError: ErrInvalidHelperName.Error(),
+		},
+		{
+			name:          "Should return error when helper times out",
+			helper:        "slow",
+			server:        "gcr.io",
+			expectedError: context.DeadlineExceeded.Error(),
+		},
+		{
+			name:          "Should return error for invalid output",
+			helper:        "broken",
+			server:        "gcr.io",
+			expectedError: "decoding docker-credential-broken output",
+		},
+	}
+	helper := NewCredentialHelper(WithHelperPath(dir), WithHelperTimeout(500*time.Millisecond))
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			auth, err := helper.Get(context.Background(), tc.helper, tc.server)
+			if tc.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedAuth, auth)
+		})
+	}
+}
+
+func TestConfig_ResolveAuths(t *testing.T) {
+	dir := t.TempDir()
+	writeHelper(t, dir, "stub", stubHelper)
+
+	testCases := []struct {
+		name          string
+		givenJSON     string
+		credsStore    bool
+		allowed       []string
+		expectedAuths map[string]Auth
+		expectedError bool
+	}{
+		{
+			name: "Should resolve servers of credsStore",
+			givenJSON: `{
+							"auths": {"harbor.domain": {"auth": "YWRtaW46SGFyYm9yMTIzNDU="}},
+							"credsStore": "stub"
+						}`,
+			credsStore: true,
+			expectedAuths: map[string]Auth{
+				"harbor.domain": {Auth: "YWRtaW46SGFyYm9yMTIzNDU=", Username: "admin", Password: "Harbor12345"},
+				"123456789012.dkr.ecr.eu-west-1.amazonaws.com": {Auth: NewBasicAuth("AWS", "ecr-token"), Username: "AWS", Password: "ecr-token"},
+				"gcr.io": {Auth: NewBasicAuth("_json_key", "gcr-key"), Username: "_json_key", Password: "gcr-key"},
+			},
+		},
+		{
+			name: "Should ignore credsStore when not enabled",
+			givenJSON: `{
+							"auths": {"harbor.domain": {"auth": "YWRtaW46SGFyYm9yMTIzNDU="}},
+							"credsStore": "stub"
+						}`,
+			expectedAuths: map[string]Auth{
+				"harbor.domain": {Auth: "YWRtaW46SGFyYm9yMTIzNDU=", Username: "admin", Password: "Harbor12345"},
+			},
+		},
+		{
+			name: "Should prefer credHelpers over auths and skip unknown servers",
+			givenJSON: `{
+							"auths": {"gcr.io": {"auth": "YWRtaW46SGFyYm9yMTIzNDU="}},
+							"credHelpers": {"gcr.io": "stub", "quay.io": "stub"}
+						}`,
+			expectedAuths: map[string]Auth{
+				"gcr.io": {Auth: NewBasicAuth("_json_key", "gcr-key"), Username: "_json_key", Password: "gcr-key"},
+			},
+		},
+		{
+			name: "Should not run helpers missing from the allowed helpers",
+			givenJSON: `{
+							"auths": {"harbor.domain": {"auth": "YWRtaW46SGFyYm9yMTIzNDU="}},
+							"credsStore": "stub",
+							"credHelpers": {"gcr.io": "stub"}
+						}`,
+			credsStore: true,
+			allowed:    []string{"ecr-login"},
+			expectedAuths: map[string]Auth{
+				"harbor.domain": {Auth: "YWRtaW46SGFyYm9yMTIzNDU=", Username: "admin", Password: "Harbor12345"},
+			},
+			expectedError: true,
+		},
+		{
+			name: "Should run the allowed helpers",
+			givenJSON: `{
+							"credHelpers": {"gcr.io": "stub"}
+						}`,
+			allowed: []string{"stub"},
+			expectedAuths: map[string]Auth{
+				"gcr.io": {Auth: NewBasicAuth("_json_key", "gcr-key"), Username: "_json_key", Password: "gcr-key"},
+			},
+		},
+		{
+			name: "Should return auths and error for missing helper",
+			givenJSON: `{
+							"auths": {"harbor.domain": {"auth": "YWRtaW46SGFyYm9yMTIzNDU="}},
+							"credHelpers": {"gcr.io": "missing"}
+						}`,
+			expectedAuths: map[string]Auth{
+				"harbor.domain": {Auth: "YWRtaW46SGFyYm9yMTIzNDU=", Username: "admin", Password: "Harbor12345"},
+			},
+			expectedError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			require.NoError(t, os.WriteFile(path, []byte(tc.givenJSON), 0o600))
+			config, err := LoadConfigFile(path)
+			require.NoError(t, err)
+			assert.True(t, config.HasCredentialHelpers())
+
+			helper := NewCredentialHelper(WithHelperPath(dir), WithCredsStore(tc.credsStore))
+			if tc.allowed != nil {
+				helper = helper.With(WithAllowedHelpers(tc.allowed...))
+			}
+			auths, err := config.ResolveAuths(context.Background(), helper)
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedAuths, auths)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+
+	"github.com/aquasecurity/trivy-kubernetes/utils"
+)
+
+// URLsMatchStr reports whether the target registry URL (host[:port][/path]) matches the glob,
+// following the kubelet keyring semantics: every host label is matched against the glob label
+// at the same position (so `*.*.amazonaws.com` needs the same number of labels), ports must be
+// equal and the glob path must be a prefix of the target path.
+func URLsMatchStr(glob, target string) (bool, error) {
+	globURL, err := parseSchemelessURL(glob)
+	if err != nil {
+		return false, err
+	}
+	targetURL, err := parseSchemelessURL(target)
+	if err != nil {
+		return false, err
+	}
+	return urlsMatch(globURL, targetURL)
+}
+
+func parseSchemelessURL(schemelessURL string) (*url.URL, error) {
+	parsed, err := url.Parse("https://" + schemelessURL)
+	if err != nil {
+		return nil, err
+	}
+	parsed.Scheme = ""
+	return parsed, nil
+}
+
+func splitURL(u *url.URL) ([]string, string) {
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		// no port
+		host, port = u.Host, ""
+	}
+	return strings.Split(host, "."), port
+}
+
+func urlsMatch(globURL, targetURL *url.URL) (bool, error) {
+	globParts, globPort := splitURL(globURL)
+	targetParts, targetPort := splitURL(targetURL)
+	if globPort != targetPort {
+		return false, nil
+	}
+	if len(globParts) != len(targetParts) {
+		return false, nil
+	}
+	if !strings.HasPrefix(targetURL.Path, globURL.Path) {
+		return false, nil
+	}
+	for i, globPart := range globParts {
+		matched, err := filepath.Match(globPart, targetParts[i])
+		if err != nil {
+			return false, err
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// GetRepositoryFromImageRef returns the registry server and repository of the image (without tag or digest),
+// the form matched against registry URLs, e.g. `index.docker.io/library/nginx` for `nginx:1.25`
+func GetRepositoryFromImageRef(imageRef string) (string, error) {
+	ref, err := utils.ParseReference(imageRef)
+	if err != nil {
+		return "", err
+	}
+	return ref.Context().Name(), nil
+}
+
+// GetRegistryKeyFromDockerAuthKey returns the host[:port][/path] registry key for the specified Docker auth key,
+// the form matched with URLsMatchStr. Registry API paths of legacy keys such as `https://index.docker.io/v1/`
+// are dropped and Docker Hub aliases are normalized to `index.docker.io`.
+func GetRegistryKeyFromDockerAuthKey(key string) (string, error) {
+	if !strings.HasPrefix(key, "http://") && !strings.HasPrefix(key, "https://") {
+		key = "https://" + key
+	}
+	parsed, err := url.Parse(key)
+	if err != nil {
+		return "", err
+	}
+
+	host := parsed.Host
+	if _, ok := dockerHubAliases[host]; ok {
+		host = name.DefaultRegistry
+	}
+	path := strings.TrimSuffix(parsed.Path, "/")
+	if path == "/v1" || path == "/v2" {
+		path = ""
+	}
+	return host + path, nil
+}
+
+var dockerHubAliases = map[string]struct{}{
+	"docker.io":            {},
+	"registry-1.docker.io": {},
+}
@@ -0,0 +1,74 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLsMatchStr(t *testing.T) {
+	testCases := []struct {
+		glob   string
+		target string
+		match  bool
+	}{
+		{glob: "registry.example.com", target: "registry.example.com/team-a/app", match: true},
+		{glob: "*.example.com", target: "registry.example.com/app", match: true},
+		{glob: "*.example.com", target: "a.registry.example.com/app", match: false},
+		{glob: "*.*.example.com", target: "a.registry.example.com/app", match: true},
+		{glob: "*.dkr.ecr.*.amazonaws.com", target: "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app", match: true},
+		{glob: "*.dkr.ecr.*.amazonaws.com", target: "123456789012.dkr.ecr.eu-west-1.amazonaws.com.cn/app", match: false},
+		{glob: "registry.example.com/team-a", target: "registry.example.com/team-a/app", match: true},
+		{glob: "registry.example.com/team-a", target: "registry.example.com/team-b/app", match: false},
+		{glob: "registry.example.com:5000", target: "registry.example.com:5000/app", match: true},
+		{glob: "registry.example.com:5000", target: "registry.example.com/app", match: false},
+		{glob: "registry.example.com", target: "registry.example.com:5000/app", match: false},
+		{glob: "gcr.io", target: "us.gcr.io/app", match: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.glob+" "+tc.target, func(t *testing.T) {
+			match, err := URLsMatchStr(tc.glob, tc.target)
+			require.NoError(t, err)
+			assert.Equal(t, tc.match, match)
+		})
+	}
+}
+
+func TestGetRepositoryFromImageRef(t *testing.T) {
+	testCases := []struct {
+		imageRef string
+		expected string
+	}{
+		{imageRef: "nginx:1.25", expected: "index.docker.io/library/nginx"},
+		{imageRef: "registry.example.com:5000/team-a/app@sha256:18e61c783b41758dd391ab901366ec3546b26fae00eef7e223d1f94da808e02f", expected: "registry.example.com:5000/team-a/app"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.imageRef, func(t *testing.T) {
+			repository, err := GetRepositoryFromImageRef(tc.imageRef)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, repository)
+		})
+	}
+}
+
+func TestGetRegistryKeyFromDockerAuthKey(t *testing.T) {
+	testCases := []struct {
+		authKey  string
+		expected string
+	}{
+		{authKey: "registry.aquasec.com", expected: "registry.aquasec.com"},
+		{authKey: "rg.pl-waw.scw.cloud:7777/private/", expected: "rg.pl-waw.scw.cloud:7777/private"},
+		{authKey: "https://index.docker.io/v1/", expected: "index.docker.io"},
+		{authKey: "docker.io", expected: "index.docker.io"},
+		{authKey: "https://registry:3780/v2/", expected: "registry:3780"},
+		{authKey: "*.dkr.ecr.*.amazonaws.com", expected: "*.dkr.ecr.*.amazonaws.com"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.authKey, func(t *testing.T) {
+			key, err := GetRegistryKeyFromDockerAuthKey(tc.authKey)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, key)
+		})
+	}
+}
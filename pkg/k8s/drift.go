@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"cmp"
+	"context"
+	"slices"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// WorkloadImageDrift holds the image digests running for the containers of a workload
+type WorkloadImageDrift struct {
+	Namespace  string
+	Kind       string
+	Name       string
+	Containers []ContainerImageDrift
+}
+
+// ContainerImageDrift holds the distinct image digests running for a single container
+type ContainerImageDrift struct {
+	Name    string
+	Digests []DigestUsage
+}
+
+// DigestUsage holds the pods and nodes running an image digest
+type DigestUsage struct {
+	ImageID string
+	Pods    []string
+	Nodes   []string
+}
+
+// HasDrift returns true when a container of the workload runs more than one digest
+func (w WorkloadImageDrift) HasDrift() bool {
+	for _, c := range w.Containers {
+		if len(c.Digests) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// ListImageDrift reports the image digests running per container for every Deployment,
+// StatefulSet and DaemonSet of a namespace (all namespaces when empty), derived from
+// pod.Status.ContainerStatuses and owner references
+func ListImageDrift(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]WorkloadImageDrift, error) {
+	index, err := newPodOwnerIndex(ctx, clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	type workloadKey struct {
+		namespace string
+		kind      string
+		name      string
+	}
+	// workload -> container -> image id -> usage
+	usages := make(map[workloadKey]map[string]map[string]*DigestUsage)
+	for _, pod := range index.pods {
+		owner := index.controllerOf(&pod)
+		if owner == nil || !slices.Contains([]string{KindDeployment, KindStatefulSet, KindDaemonSet}, owner.Kind) {
+			continue
+		}
+		key := workloadKey{namespace: pod.Namespace, kind: owner.Kind, name: owner.Name}
+		if usages[key] == nil {
+			usages[key] = make(map[string]map[string]*DigestUsage)
+		}
+		for container, imageID := range containerImageIDs(pod) {
+			if usages[key][container] == nil {
+				usages[key][container] = make(map[string]*DigestUsage)
+			}
+			usage, ok := usages[key][container][imageID]
+			if !ok {
+				usage = &DigestUsage{ImageID: imageID, Pods: []string{}, Nodes: []string{}}
+				usages[key][container][imageID] = usage
+			}
+			usage.Pods = append(usage.Pods, pod.Name)
+			if pod.Spec.NodeName != "" && !slices.Contains(usage.Nodes, pod.Spec.NodeName) {
+				usage.Nodes = append(usage.Nodes, pod.Spec.NodeName)
+			}
+		}
+	}
+
+	report := make([]WorkloadImageDrift, 0, len(usages))
+	for key, containers := range usages {
+		workload := WorkloadImageDrift{
+			Namespace:  key.namespace,
+			Kind:       key.kind,
+			Name:       key.name,
+			Containers: make([]ContainerImageDrift, 0, len(containers)),
+		}
+		for name, digests := range containers {
+			c := ContainerImageDrift{Name: name, Digests: make([]DigestUsage, 0, len(digests))}
+			for _, usage := range digests {
+				slices.Sort(usage.Pods)
+				slices.Sort(usage.Nodes)
+				c.Digests = append(c.Digests, *usage)
+			}
+			slices.SortFunc(c.Digests, func(a, b DigestUsage) int {
+				return cmp.Compare(a.ImageID, b.ImageID)
+			})
+			workload.Containers = append(workload.Containers, c)
+		}
+		slices.SortFunc(workload.Containers, func(a, b ContainerImageDrift) int {
+			return cmp.Compare(a.Name, b.Name)
+		})
+		report = append(report, workload)
+	}
+	slices.SortFunc(report, func(a, b WorkloadImageDrift) int {
+		return cmp.Or(
+			cmp.Compare(a.Namespace, b.Namespace),
+			cmp.Compare(a.Kind, b.Kind),
+			cmp.Compare(a.Name, b.Name),
+		)
+	})
+	return report, nil
+}
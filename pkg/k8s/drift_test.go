@@ -0,0 +1,70 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestListImageDrift(t *testing.T) {
+	clientset := fake.NewClientset(
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "nginx-1", Namespace: "default", UID: "rs1-uid",
+			OwnerReferences: controllerRef(KindDeployment, "nginx", "deploy-uid")}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "nginx-2", Namespace: "default", UID: "rs2-uid",
+			OwnerReferences: controllerRef(KindDeployment, "nginx", "deploy-uid")}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "default", UID: "job-uid",
+			OwnerReferences: controllerRef(KindCronJob, "backup", "cron-uid")}},
+		runningPod("nginx-1-a", "node-1", controllerRef(KindReplicaSet, "nginx-1", "rs1-uid"),
+			map[string]string{"nginx": "docker-pullable://nginx@" + digestA}),
+		runningPod("nginx-2-b", "node-2", controllerRef(KindReplicaSet, "nginx-2", "rs2-uid"),
+			map[string]string{"nginx": "docker-pullable://nginx@" + digestB}),
+		runningPod("nginx-2-a", "node-1", controllerRef(KindReplicaSet, "nginx-2", "rs2-uid"),
+			map[string]string{"nginx": "docker-pullable://nginx@" + digestB}),
+		runningPod("agent-x", "node-1", controllerRef(KindDaemonSet, "agent", "ds-uid"),
+			map[string]string{"agent": digestA, "sidecar": digestB}),
+		runningPod("agent-y", "node-2", controllerRef(KindDaemonSet, "agent", "ds-uid"),
+			map[string]string{"agent": digestA, "sidecar": digestB}),
+		runningPod("backup-1-x", "node-1", controllerRef(KindJob, "backup-1", "job-uid"),
+			map[string]string{"backup": digestA}),
+		runningPod("bare", "node-1", nil, map[string]string{"app": digestA}),
+	)
+
+	got, err := ListImageDrift(context.Background(), clientset, "default")
+	require.NoError(t, err)
+
+	want := []WorkloadImageDrift{
+		{
+			Namespace: "default",
+			Kind:      KindDaemonSet,
+			Name:      "agent",
+			Containers: []ContainerImageDrift{
+				{Name: "agent", Digests: []DigestUsage{
+					{ImageID: digestA, Pods: []string{"agent-x", "agent-y"}, Nodes: []string{"node-1", "node-2"}},
+				}},
+				{Name: "sidecar", Digests: []DigestUsage{
+					{ImageID: digestB, Pods: []string{"agent-x", "agent-y"}, Nodes: []string{"node-1", "node-2"}},
+				}},
+			},
+		},
+		{
+			Namespace: "default",
+			Kind:      KindDeployment,
+			Name:      "nginx",
+			Containers: []ContainerImageDrift{
+				{Name: "nginx", Digests: []DigestUsage{
+					{ImageID: digestB, Pods: []string{"nginx-2-a", "nginx-2-b"}, Nodes: []string{"node-1", "node-2"}},
+					{ImageID: digestA, Pods: []string{"nginx-1-a"}, Nodes: []string{"node-1"}},
+				}},
+			},
+		},
+	}
+	assert.Equal(t, want, got)
+	assert.False(t, got[0].HasDrift())
+	assert.True(t, got[1].HasDrift())
+}
@@ -0,0 +1,163 @@
+// Package fake provides an in-memory k8s.Cluster backed by the client-go fakes, for tests of the cluster consumers
+package fake
+
+import (
+	"context"
+
+	k8sapierror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	fakekubernetes "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+)
+
+// DefaultServerVersion is the server version of the cluster unless WithServerVersion is used
+const DefaultServerVersion = "v1.31.0"
+
+// builtinKinds are the kinds known to the static RESTMapper, their preferred version only
+var builtinKinds = []struct {
+	gvk   schema.GroupVersionKind
+	scope meta.RESTScope
+}{
+	{gvk: schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Version: "v1", Kind: "ReplicationController"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Version: "v1", Kind: "Service"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Version: "v1", Kind: "ServiceAccount"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Version: "v1", Kind: "Secret"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Version: "v1", Kind: "ResourceQuota"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Version: "v1", Kind: "LimitRange"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, scope: meta.RESTScopeRoot},
+	{gvk: schema.GroupVersionKind{Version: "v1", Kind: "Node"}, scope: meta.RESTScopeRoot},
+	{gvk: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "NetworkPolicy"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "Role"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "RoleBinding"}, scope: meta.RESTScopeNamespace},
+	{gvk: schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, scope: meta.RESTScopeRoot},
+	{gvk: schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRoleBinding"}, scope: meta.RESTScopeRoot},
+}
+
+// Cluster is an in-memory k8s.Cluster, the fakes are exposed to add reactors and inspect actions
+type Cluster struct {
+	k8s.Cluster
+	Clientset     *fakekubernetes.Clientset
+	DynamicClient *fakedynamic.FakeDynamicClient
+	RESTMapper    *meta.DefaultRESTMapper
+
+	platform    *k8s.Platform
+	nodeConfigs map[string][]byte
+}
+
+type Option func(*options)
+
+type options struct {
+	platform       *k8s.Platform
+	serverVersion  string
+	nodeConfigs    map[string][]byte
+	clusterOptions []k8s.ClusterOption
+}
+
+// WithPlatform set the platform of the cluster, it is detected from the objects otherwise
+func WithPlatform(platform k8s.Platform) Option {
+	return func(o *options) {
+		o.platform = &platform
+	}
+}
+
+// WithServerVersion set the git version of the API server, e.g. v1.29.1
+func WithServerVersion(gitVersion string) Option {
+	return func(o *options) {
+		o.serverVersion = gitVersion
+	}
+}
+
+// WithNodeConfig set the kubelet configz response of the node
+func WithNodeConfig(nodeName string, configz []byte) Option {
+	return func(o *options) {
+		o.nodeConfigs[nodeName] = configz
+	}
+}
+
+// WithClusterOptions set the options of the underlying cluster, such as the credential helper
+func WithClusterOptions(opts ...k8s.ClusterOption) Option {
+	return func(o *options) {
+		o.clusterOptions = append(o.clusterOptions, opts...)
+	}
+}
+
+// NewCluster instansiate a cluster serving the objects, typed objects are served by both clients,
+// unstructured objects by the dynamic client only and their kinds are added to the RESTMapper
+func NewCluster(objects []runtime.Object, opts ...Option) (*Cluster, error) {
+	o := &options{
+		serverVersion: DefaultServerVersion,
+		nodeConfigs:   make(map[string][]byte),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, kind := range builtinKinds {
+		mapper.Add(kind.gvk, kind.scope)
+	}
+	typed := make([]runtime.Object, 0, len(objects))
+	for _, obj := range objects {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			typed = append(typed, obj)
+			continue
+		}
+		scope := meta.RESTScopeRoot
+		if u.GetNamespace() != "" {
+			scope = meta.RESTScopeNamespace
+		}
+		mapper.Add(u.GroupVersionKind(), scope)
+	}
+
+	clientset := fakekubernetes.NewClientset(typed...)
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: o.serverVersion}
+	dynamicClient := fakedynamic.NewSimpleDynamicClient(scheme.Scheme, objects...)
+
+	cluster, err := k8s.NewCluster(clientset, dynamicClient, mapper, o.clusterOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &Cluster{
+		Cluster:       cluster,
+		Clientset:     clientset,
+		DynamicClient: dynamicClient,
+		RESTMapper:    mapper,
+		platform:      o.platform,
+		nodeConfigs:   o.nodeConfigs,
+	}, nil
+}
+
+// Platform returns the platform set with WithPlatform, the detected one otherwise
+func (c *Cluster) Platform() k8s.Platform {
+	if c.platform != nil {
+		return *c.platform
+	}
+	return c.Cluster.Platform()
+}
+
+// GetNodeConfig returns the configz set with WithNodeConfig, not found otherwise
+func (c *Cluster) GetNodeConfig(_ context.Context, nodeName string) ([]byte, error) {
+	configz, ok := c.nodeConfigs[nodeName]
+	if !ok {
+		return nil, k8sapierror.NewNotFound(schema.GroupResource{Resource: "nodes/proxy"}, nodeName)
+	}
+	return configz, nil
+}
@@ -0,0 +1,85 @@
+package fake
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sapierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+)
+
+func TestNewCluster(t *testing.T) {
+	objects := []runtime.Object{
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "kind-control-plane"},
+			Spec:       corev1.NodeSpec{ProviderID: "kind://docker/kind/kind-control-plane"},
+		},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"}},
+		&unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "example.com/v1",
+			"kind":       "Widget",
+			"metadata":   map[string]any{"namespace": "default", "name": "widget"},
+		}},
+	}
+	ctx := context.Background()
+
+	t.Run("defaults", func(t *testing.T) {
+		cluster, err := NewCluster(objects)
+		require.NoError(t, err)
+
+		assert.Equal(t, "1.31.0", cluster.GetClusterVersion())
+		assert.Equal(t, "default", cluster.GetCurrentNamespace())
+		platform := cluster.Platform()
+		assert.Equal(t, "kind", platform.Name)
+		assert.Equal(t, "1.31", platform.Version)
+
+		nodes, err := cluster.GetK8sClientSet().CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		assert.Len(t, nodes.Items, 1)
+
+		gvrs, err := cluster.GetGVRs(false, nil)
+		require.NoError(t, err)
+		assert.Len(t, gvrs, len(k8s.GetAllResources()))
+
+		gvr, err := cluster.GetGVR("deployments")
+		require.NoError(t, err)
+		deployments, err := cluster.GetDynamicClient().Resource(gvr).Namespace("default").List(ctx, metav1.ListOptions{})
+		require.NoError(t, err)
+		assert.Len(t, deployments.Items, 1)
+
+		gvr, err = cluster.GetGVR("widgets")
+		require.NoError(t, err)
+		assert.Equal(t, schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}, gvr)
+		widget, err := cluster.GetDynamicClient().Resource(gvr).Namespace("default").Get(ctx, "widget", metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "widget", widget.GetName())
+
+		_, err = cluster.GetNodeConfig(ctx, "kind-control-plane")
+		assert.True(t, k8sapierror.IsNotFound(err))
+	})
+
+	t.Run("options", func(t *testing.T) {
+		platform := k8s.Platform{Name: "eks", Version: "1.29", Confidence: k8s.ConfidenceHigh}
+		cluster, err := NewCluster(objects,
+			WithPlatform(platform),
+			WithServerVersion("v1.29.1-eks-a5565ad"),
+			WithNodeConfig("kind-control-plane", []byte(`{"kubeletconfig":{}}`)),
+		)
+		require.NoError(t, err)
+
+		assert.Equal(t, "1.29.1-eks-a5565ad", cluster.GetClusterVersion())
+		assert.Equal(t, platform, cluster.Platform())
+		configz, err := cluster.GetNodeConfig(ctx, "kind-control-plane")
+		require.NoError(t, err)
+		assert.Equal(t, `{"kubeletconfig":{}}`, string(configz))
+	})
+}
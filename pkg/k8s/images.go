@@ -0,0 +1,177 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	k8sapierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxOwnerDepth bounds the owner chain walk, e.g. Pod -> Job -> CronJob
+const maxOwnerDepth = 5
+
+// podOwnerIndex resolves pods to the built-in workloads controlling them,
+// following the owner chain through intermediate ReplicaSets and Jobs
+type podOwnerIndex struct {
+	pods []corev1.Pod
+	// owners maps an intermediate controller UID to its own controller reference
+	owners map[types.UID]*metav1.OwnerReference
+}
+
+func newPodOwnerIndex(ctx context.Context, clientset kubernetes.Interface, namespace string) (*podOwnerIndex, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods in namespace %q: %w", namespace, err)
+	}
+	index := &podOwnerIndex{
+		pods:   pods.Items,
+		owners: make(map[types.UID]*metav1.OwnerReference),
+	}
+	replicaSets, err := clientset.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil && !k8sapierror.IsNotFound(err) && !k8sapierror.IsForbidden(err) {
+		return nil, fmt.Errorf("listing replicasets in namespace %q: %w", namespace, err)
+	}
+	if replicaSets != nil {
+		for i := range replicaSets.Items {
+			index.addOwner(&replicaSets.Items[i])
+		}
+	}
+	jobs, err := clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil && !k8sapierror.IsNotFound(err) && !k8sapierror.IsForbidden(err) {
+		return nil, fmt.Errorf("listing jobs in namespace %q: %w", namespace, err)
+	}
+	if jobs != nil {
+		for i := range jobs.Items {
+			index.addOwner(&jobs.Items[i])
+		}
+	}
+	return index, nil
+}
+
+func (i *podOwnerIndex) addOwner(obj metav1.Object) {
+	if ref := metav1.GetControllerOf(obj); ref != nil {
+		i.owners[obj.GetUID()] = ref
+	}
+}
+
+// ownerChain returns the controller references of a pod, from its direct
+// controller up to the top-level workload
+func (i *podOwnerIndex) ownerChain(pod *corev1.Pod) []*metav1.OwnerReference {
+	chain := make([]*metav1.OwnerReference, 0)
+	ref := metav1.GetControllerOf(pod)
+	for ref != nil && len(chain) < maxOwnerDepth {
+		chain = append(chain, ref)
+		ref = i.owners[ref.UID]
+	}
+	return chain
+}
+
+// controllerOf returns the top-level controller of a pod, e.g. the Deployment
+// of a pod created by one of its ReplicaSets, or nil for bare pods
+func (i *podOwnerIndex) controllerOf(pod *corev1.Pod) *metav1.OwnerReference {
+	chain := i.ownerChain(pod)
+	if len(chain) == 0 {
+		return nil
+	}
+	return chain[len(chain)-1]
+}
+
+// podsOf returns the pods having the given UID in their owner chain
+func (i *podOwnerIndex) podsOf(uid types.UID) []corev1.Pod {
+	pods := make([]corev1.Pod, 0)
+	for _, pod := range i.pods {
+		for _, ref := range i.ownerChain(&pod) {
+			if ref.UID == uid {
+				pods = append(pods, pod)
+				break
+			}
+		}
+	}
+	return pods
+}
+
+// RunningImagesResolver looks up the image digests running for workloads,
+// listing pods and intermediate controllers once per namespace
+type RunningImagesResolver struct {
+	clientset kubernetes.Interface
+	indexes   map[string]*podOwnerIndex
+}
+
+// NewRunningImagesResolver instansiate a new running images resolver
+func NewRunningImagesResolver(clientset kubernetes.Interface) *RunningImagesResolver {
+	return &RunningImagesResolver{
+		clientset: clientset,
+		indexes:   make(map[string]*podOwnerIndex),
+	}
+}
+
+// ImageIDsByResource returns the distinct image digests (pod.Status.ContainerStatuses.ImageID)
+// running for each container of a Pod or a built-in workload, keyed by container name.
+// Workload pods are found by following their owner references up to the resource.
+func (r *RunningImagesResolver) ImageIDsByResource(ctx context.Context, resource unstructured.Unstructured) (map[string][]string, error) {
+	var pods []corev1.Pod
+	switch resource.GetKind() {
+	case KindPod:
+		var pod corev1.Pod
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource.Object, &pod); err != nil {
+			return nil, err
+		}
+		pods = []corev1.Pod{pod}
+	case KindDeployment, KindReplicaSet, KindReplicationController, KindStatefulSet, KindDaemonSet, KindJob, KindCronJob:
+		index, err := r.ownerIndex(ctx, resource.GetNamespace())
+		if err != nil {
+			return nil, err
+		}
+		pods = index.podsOf(resource.GetUID())
+	default:
+		return map[string][]string{}, nil
+	}
+	return runningImageIDs(pods), nil
+}
+
+func (r *RunningImagesResolver) ownerIndex(ctx context.Context, namespace string) (*podOwnerIndex, error) {
+	if index, ok := r.indexes[namespace]; ok {
+		return index, nil
+	}
+	index, err := newPodOwnerIndex(ctx, r.clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+	r.indexes[namespace] = index
+	return index, nil
+}
+
+func runningImageIDs(pods []corev1.Pod) map[string][]string {
+	ids := make(map[string][]string)
+	for _, pod := range pods {
+		for name, id := range containerImageIDs(pod) {
+			if !slices.Contains(ids[name], id) {
+				ids[name] = append(ids[name], id)
+			}
+		}
+	}
+	for name := range ids {
+		slices.Sort(ids[name])
+	}
+	return ids
+}
+
+// containerImageIDs maps container names to the image digest reported in the pod statuses,
+// containers without a sha256 digest (e.g. not started yet) are skipped
+func containerImageIDs(pod corev1.Pod) map[string]string {
+	ids := make(map[string]string)
+	statuses := slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses, pod.Status.EphemeralContainerStatuses)
+	for _, status := range statuses {
+		if id := getImageID(status.ImageID); id != "" {
+			ids[status.Name] = id
+		}
+	}
+	return ids
+}
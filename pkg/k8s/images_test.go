@@ -0,0 +1,113 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	digestA = "sha256:a886e56d532d1388c77c8340261149d974370edca1093af4c97a96fb1467cb39"
+	digestB = "sha256:18e61c783b41758dd391ab901366ec3546b26fae00eef7e223d1f94da808e02f"
+)
+
+func controllerRef(kind, name string, uid types.UID) []metav1.OwnerReference {
+	return []metav1.OwnerReference{{Kind: kind, Name: name, UID: uid, Controller: ptr.To(true)}}
+}
+
+func runningPod(name, node string, owners []metav1.OwnerReference, imageIDs map[string]string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", OwnerReferences: owners},
+		Spec:       corev1.PodSpec{NodeName: node},
+	}
+	for container, id := range imageIDs {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: container, Image: container + ":latest"})
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:    container,
+			Image:   container + ":latest",
+			ImageID: id,
+		})
+	}
+	return pod
+}
+
+func toUnstructured(t *testing.T, kind string, obj runtime.Object) unstructured.Unstructured {
+	raw, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	require.NoError(t, err)
+	u := unstructured.Unstructured{Object: raw}
+	u.SetKind(kind)
+	return u
+}
+
+func TestImageIDsByResource(t *testing.T) {
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "default", UID: "deploy-uid"}}
+	rs1 := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "nginx-1", Namespace: "default", UID: "rs1-uid",
+		OwnerReferences: controllerRef(KindDeployment, "nginx", "deploy-uid")}}
+	rs2 := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "nginx-2", Namespace: "default", UID: "rs2-uid",
+		OwnerReferences: controllerRef(KindDeployment, "nginx", "deploy-uid")}}
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", UID: "sts-uid"}}
+
+	bare := runningPod("bare", "node-1", nil, map[string]string{"app": "docker-pullable://app@" + digestB})
+	clientset := fake.NewClientset(rs1, rs2,
+		runningPod("nginx-1-a", "node-1", controllerRef(KindReplicaSet, "nginx-1", "rs1-uid"),
+			map[string]string{"nginx": "docker-pullable://nginx@" + digestA}),
+		runningPod("nginx-2-a", "node-2", controllerRef(KindReplicaSet, "nginx-2", "rs2-uid"),
+			map[string]string{"nginx": "docker-pullable://nginx@" + digestB}),
+		runningPod("nginx-2-b", "node-2", controllerRef(KindReplicaSet, "nginx-2", "rs2-uid"),
+			map[string]string{"nginx": "docker-pullable://nginx@" + digestB}),
+		runningPod("db-0", "node-1", controllerRef(KindStatefulSet, "db", "sts-uid"),
+			map[string]string{"db": digestA, "pending": ""}),
+		bare,
+	)
+
+	tests := []struct {
+		name     string
+		resource unstructured.Unstructured
+		want     map[string][]string
+	}{
+		{
+			name:     "deployment through replicasets",
+			resource: toUnstructured(t, KindDeployment, deploy),
+			want:     map[string][]string{"nginx": {digestB, digestA}},
+		},
+		{
+			name:     "replicaset",
+			resource: toUnstructured(t, KindReplicaSet, rs1),
+			want:     map[string][]string{"nginx": {digestA}},
+		},
+		{
+			name:     "statefulset skips containers without digest",
+			resource: toUnstructured(t, KindStatefulSet, sts),
+			want:     map[string][]string{"db": {digestA}},
+		},
+		{
+			name:     "pod uses its own statuses",
+			resource: toUnstructured(t, KindPod, bare),
+			want:     map[string][]string{"app": {digestB}},
+		},
+		{
+			name:     "non workload",
+			resource: toUnstructured(t, "ConfigMap", &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"}}),
+			want:     map[string][]string{},
+		},
+	}
+
+	resolver := NewRunningImagesResolver(clientset)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolver.ImageIDsByResource(context.Background(), tt.resource)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	containerimage "github.com/google/go-containerregistry/pkg/name"
 	ms "github.com/mitchellh/mapstructure"
@@ -18,11 +21,14 @@ import (
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/utils/strings/slices"
 
 	"github.com/aquasecurity/trivy-kubernetes/pkg/bom"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/credentialprovider"
 	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
 	"github.com/aquasecurity/trivy-kubernetes/utils"
 )
@@ -109,7 +115,7 @@ type Cluster interface {
 	// GetDynamicClient returns a dynamic k8s client
 	GetDynamicClient() dynamic.Interface
 	// GetK8sClientSet returns a k8s client set
-	GetK8sClientSet() *kubernetes.Clientset
+	GetK8sClientSet() kubernetes.Interface
 	// GetGVRs returns cluster GroupVersionResource to query kubernetes, receives
 	// a boolean to determine if returns namespaced GVRs only or all GVRs, unless
 	// resources is passed to filter
@@ -123,8 +129,20 @@ type Cluster interface {
 	CreateClusterBom(ctx context.Context) (*bom.Result, error)
 	// GetClusterVersion return cluster git version
 	GetClusterVersion() string
-	// AuthByResource return image pull secrets by resource pod spec
+	// GetNodeConfig return the kubelet configz of the node
+	GetNodeConfig(ctx context.Context, nodeName string) ([]byte, error)
+	// AuthByResource return image pull secrets by resource pod spec, credentials of several secrets
+	// for the same registry are joined with commas
 	AuthByResource(resource unstructured.Unstructured) (map[string]docker.Auth, error)
+	// CredentialsByResource return the ordered registry credentials of the resource pod spec
+	CredentialsByResource(resource unstructured.Unstructured) (RegistryCredentials, error)
+	// CredentialRefsByResource return the references of the image pull secrets of the resource pod spec
+	// without reading the secrets
+	CredentialRefsByResource(resource unstructured.Unstructured) ([]CredentialRef, error)
+	// CredentialResolver return a resolver for the credential references of the cluster image pull secrets
+	CredentialResolver() CredentialResolver
+	// NewCredentialsCache return a cache of the service accounts and image pull secrets for a scan
+	NewCredentialsCache() CredentialsCache
 	// SpecByPlatform return spec by platform type and version
 	Platform() Platform
 }
@@ -135,28 +153,60 @@ type cluster struct {
 	serverVersion    string
 	dynamicClient    dynamic.Interface
 	restMapper       meta.RESTMapper
-	clientset        *kubernetes.Clientset
-	cConfig          clientcmd.ClientConfig
+	clientset        kubernetes.Interface
+	// metadataClient lists the secret metadata of the credential references, nil when not built from a config
+	metadataClient metadata.Interface
+	cConfig        clientcmd.ClientConfig
+	// clusterName of clusters without kubeconfig
+	clusterName        string
+	credentialHelper   *docker.CredentialHelper
+	credentialProvider *credentialprovider.Provider
 }
 
-type ClusterOption func(*genericclioptions.ConfigFlags)
+type ClusterOption func(*clusterOptions)
+
+type clusterOptions struct {
+	configFlags               *genericclioptions.ConfigFlags
+	credentialHelper          *docker.CredentialHelper
+	allowedHelpers            []string
+	credentialProviderConfig  string
+	credentialProviderBinDir  string
+	credentialProviderOptions []credentialprovider.ProviderOption
+	discoveryCacheDir         string
+	discoveryCacheTTL         time.Duration
+}
+
+// WithConfigFlags adapts a func of the kubectl config flags, the type of ClusterOption before the
+// cluster options held more than the config flags
+func WithConfigFlags(fn func(*genericclioptions.ConfigFlags)) ClusterOption {
+	return func(o *clusterOptions) {
+		fn(o.configFlags)
+	}
+}
 
 // Specify the context to use, if empty uses default
 func WithContext(context string) ClusterOption {
-	return func(c *genericclioptions.ConfigFlags) {
-		c.Context = &context
+	return func(o *clusterOptions) {
+		o.configFlags.Context = &context
+	}
+}
+
+// WithNamespace set the current namespace, it overrides the kubeconfig context and in-cluster namespaces
+func WithNamespace(namespace string) ClusterOption {
+	return func(o *clusterOptions) {
+		o.configFlags.Namespace = &namespace
 	}
 }
 
 // kubeconfig can be used to specify the config file path (overrides KUBECONFIG env)
 func WithKubeConfig(kubeConfig string) ClusterOption {
-	return func(c *genericclioptions.ConfigFlags) {
-		c.KubeConfig = &kubeConfig
+	return func(o *clusterOptions) {
+		o.configFlags.KubeConfig = &kubeConfig
 	}
 }
 func WithQPS(qps float32) ClusterOption {
-	return func(o *genericclioptions.ConfigFlags) {
-		o.WrapConfigFn = combineConfigFns(o.WrapConfigFn, func(c *rest.Config) *rest.Config {
+	return func(o *clusterOptions) {
+		o.configFlags.WrapConfigFn = combineConfigFns(o.configFlags.WrapConfigFn, func(c *rest.Config) *rest.Config {
 			c.QPS = qps
 			return c
 		})
@@ -164,14 +214,111 @@ func WithQPS(qps float32) ClusterOption {
 }
 
 func WithBurst(burst int) ClusterOption {
-	return func(o *genericclioptions.ConfigFlags) {
-		o.WrapConfigFn = combineConfigFns(o.WrapConfigFn, func(c *rest.Config) *rest.Config {
+	return func(o *clusterOptions) {
+		o.configFlags.WrapConfigFn = combineConfigFns(o.configFlags.WrapConfigFn, func(c *rest.Config) *rest.Config {
 			c.Burst = burst
 			return c
 		})
 	}
 }
 
+// WithImpersonation impersonate the user and its groups on every request, as kubectl --as and --as-group
+func WithImpersonation(user string, groups ...string) ClusterOption {
+	return func(o *clusterOptions) {
+		o.configFlags.Impersonate = &user
+		o.configFlags.ImpersonateGroup = &groups
+	}
+}
+
+// WithBearerToken authenticate with the token, it overrides the kubeconfig user credentials
+func WithBearerToken(token string) ClusterOption {
+	return func(o *clusterOptions) {
+		o.configFlags.BearerToken = &token
+	}
+}
+
+// WithAPIServer set the address and port of the API server, it overrides the kubeconfig cluster server
+func WithAPIServer(server string) ClusterOption {
+	return func(o *clusterOptions) {
+		o.configFlags.APIServer = &server
+	}
+}
+
+// WithCAFile verify the API server certificate with the CA bundle file
+func WithCAFile(caFile string) ClusterOption {
+	return func(o *clusterOptions) {
+		o.configFlags.CAFile = &caFile
+	}
+}
+
+// WithCAData verify the API server certificate with the PEM encoded CA bundle
+func WithCAData(caData []byte) ClusterOption {
+	return func(o *clusterOptions) {
+		o.configFlags.WrapConfigFn = combineConfigFns(o.configFlags.WrapConfigFn, func(c *rest.Config) *rest.Config {
+			c.TLSClientConfig.CAFile = ""
+			c.TLSClientConfig.CAData = caData
+			return c
+		})
+	}
+}
+
+// WithTLSServerName set the server name used to verify the API server certificate, when it differs from the server host
+func WithTLSServerName(serverName string) ClusterOption {
+	return func(o *clusterOptions) {
+		o.configFlags.TLSServerName = &serverName
+	}
+}
+
+// WithProxyURL send the requests through the HTTP(S) proxy, HTTPS_PROXY is used otherwise
+func WithProxyURL(proxyURL *url.URL) ClusterOption {
+	return func(o *clusterOptions) {
+		o.configFlags.WrapConfigFn = combineConfigFns(o.configFlags.WrapConfigFn, func(c *rest.Config) *rest.Config {
+			c.Proxy = http.ProxyURL(proxyURL)
+			return c
+		})
+	}
+}
+
+// WithRequestTimeout set the timeout of a single request to the API server, zero means no timeout
+func WithRequestTimeout(timeout time.Duration) ClusterOption {
+	return func(o *clusterOptions) {
+		o.configFlags.WrapConfigFn = combineConfigFns(o.configFlags.WrapConfigFn, func(c *rest.Config) *rest.Config {
+			c.Timeout = timeout
+			return c
+		})
+	}
+}
+
+// WithDiscoveryCache cache the API discovery of the cluster in dir, the cache of a server version is
+// refreshed after the ttl (6 hours when zero) and can be shared by several processes
+func WithDiscoveryCache(dir string, ttl time.Duration) ClusterOption {
+	return func(o *clusterOptions) {
+		o.discoveryCacheDir = dir
+		o.discoveryCacheTTL = ttl
+	}
+}
+
+// WithCredentialHelper resolves the credHelpers, and the credsStore when enabled, referenced by image pull secrets
+// using the given Docker credential helpers runner. Only the allowedHelpers run, replacing the helpers allowed
+// by the runner, as the helper names of the secrets come from whoever can write a secret. Helpers are ignored
+// when none is allowed.
+func WithCredentialHelper(helper *docker.CredentialHelper, allowedHelpers ...string) ClusterOption {
+	return func(o *clusterOptions) {
+		o.credentialHelper = helper
+		o.allowedHelpers = allowedHelpers
+	}
+}
+
+// WithCredentialProviderConfig looks up images credentials using the kubelet credential provider
+// plugins of binDir, as configured by the CredentialProviderConfig file (--image-credential-provider-config)
+func WithCredentialProviderConfig(configPath, binDir string, opts ...credentialprovider.ProviderOption) ClusterOption {
+	return func(o *clusterOptions) {
+		o.credentialProviderConfig = configPath
+		o.credentialProviderBinDir = binDir
+		o.credentialProviderOptions = opts
+	}
+}
+
 // Helper function to combine multiple config functions
 func combineConfigFns(existing, newFn func(*rest.Config) *rest.Config) func(*rest.Config) *rest.Config {
 	if existing == nil {
@@ -187,60 +334,129 @@ func combineConfigFns(existing, newFn func(*rest.Config) *rest.Config) func(*res
 
 // GetCluster returns a current configured cluster,
 func GetCluster(opts ...ClusterOption) (Cluster, error) {
-	cf := genericclioptions.NewConfigFlags(true)
-	for _, opt := range opts {
-		opt(cf)
-	}
+	o := newClusterOptions(opts...)
+	cf := o.configFlags
 
 	// disable warnings
 	rest.SetDefaultWarningHandler(rest.NoWarnings{})
 
 	clientConfig := cf.ToRawKubeConfigLoader()
 
-	restMapper, err := cf.ToRESTMapper()
+	kubeConfig, err := cf.ToRESTConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	kubeConfig, err := cf.ToRESTConfig()
+	var restMapper meta.RESTMapper
+	if o.discoveryCacheDir != "" {
+		restMapper, err = o.newRESTMapper(kubeConfig)
+	} else {
+		restMapper, err = cf.ToRESTMapper()
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return getCluster(clientConfig, kubeConfig, restMapper, *cf.Context, false)
+	c, err := getCluster(clientConfig, kubeConfig, restMapper, *cf.Context, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.applyCredentials(c); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
-func getCluster(clientConfig clientcmd.ClientConfig, kubeConfig *rest.Config, restMapper meta.RESTMapper, currentContext string, fakeConfig bool) (*cluster, error) {
+func newClusterOptions(opts ...ClusterOption) *clusterOptions {
+	o := &clusterOptions{
+		configFlags: genericclioptions.NewConfigFlags(true),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
 
-	k8sDynamicClient, err := dynamic.NewForConfig(kubeConfig)
+// NewCluster instansiate a cluster from existing clients, such as the client-go fakes, the kubeconfig
+// options are ignored and the current namespace is default unless WithNamespace is used
+func NewCluster(clientset kubernetes.Interface, dynamicClient dynamic.Interface, restMapper meta.RESTMapper, opts ...ClusterOption) (Cluster, error) {
+	o := newClusterOptions(opts...)
+	sv, err := clientset.Discovery().ServerVersion()
 	if err != nil {
 		return nil, err
 	}
-	var kubeClientset *kubernetes.Clientset
-
-	kubeClientset, err = kubernetes.NewForConfig(kubeConfig)
-	if err != nil {
+	namespace := "default"
+	if o.configFlags.Namespace != nil && *o.configFlags.Namespace != "" {
+		namespace = *o.configFlags.Namespace
+	}
+	c := &cluster{
+		currentNamespace: namespace,
+		dynamicClient:    dynamicClient,
+		restMapper:       restMapper,
+		clientset:        clientset,
+		serverVersion:    strings.TrimPrefix(sv.GitVersion, "v"),
+	}
+	if err := o.applyCredentials(c); err != nil {
 		return nil, err
 	}
+	return c, nil
+}
+
+func (o *clusterOptions) applyCredentials(c *cluster) error {
+	if o.credentialHelper != nil && len(o.allowedHelpers) > 0 {
+		c.credentialHelper = o.credentialHelper.With(docker.WithAllowedHelpers(o.allowedHelpers...))
+	}
+	if o.credentialProviderConfig != "" {
+		config, err := credentialprovider.LoadConfig(o.credentialProviderConfig)
+		if err != nil {
+			return err
+		}
+		c.credentialProvider = credentialprovider.NewProvider(config, o.credentialProviderBinDir, o.credentialProviderOptions...)
+	}
+	return nil
+}
+
+func getCluster(clientConfig clientcmd.ClientConfig, kubeConfig *rest.Config, restMapper meta.RESTMapper, currentContext string, fakeConfig bool) (*cluster, error) {
 	rawCfg, err := clientConfig.RawConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	var namespace string
-
 	if len(currentContext) == 0 {
 		currentContext = rawCfg.CurrentContext
 	}
-	if context, ok := rawCfg.Contexts[currentContext]; ok {
-		namespace = context.Namespace
+	// the namespace of the context, of the service account when running in-cluster, default otherwise
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newClusterForConfig(kubeConfig, restMapper, !fakeConfig)
+	if err != nil {
+		return nil, err
 	}
+	c.currentContext = currentContext
+	c.currentNamespace = namespace
+	c.cConfig = clientConfig
+	return c, nil
+}
 
-	if len(namespace) == 0 {
-		namespace = "default"
+// newClusterForConfig instansiate the clients of the cluster, the server version is fetched unless fetchVersion is false
+func newClusterForConfig(kubeConfig *rest.Config, restMapper meta.RESTMapper, fetchVersion bool) (*cluster, error) {
+	k8sDynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	kubeClientset, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	metadataClient, err := metadata.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, err
 	}
 	var serverVersion string
-	if !fakeConfig {
+	if fetchVersion {
 		sv, err := kubeClientset.ServerVersion()
 		if err != nil {
 			return nil, err
@@ -248,13 +464,11 @@ func getCluster(clientConfig clientcmd.ClientConfig, kubeConfig *rest.Config, re
 		serverVersion = strings.TrimPrefix(sv.GitVersion, "v")
 	}
 	return &cluster{
-		currentContext:   currentContext,
-		currentNamespace: namespace,
-		dynamicClient:    k8sDynamicClient,
-		restMapper:       restMapper,
-		clientset:        kubeClientset,
-		cConfig:          clientConfig,
-		serverVersion:    serverVersion,
+		dynamicClient:  k8sDynamicClient,
+		restMapper:     restMapper,
+		clientset:      kubeClientset,
+		metadataClient: metadataClient,
+		serverVersion:  serverVersion,
 	}, nil
 }
 
@@ -279,81 +493,24 @@ func (c *cluster) GetDynamicClient() dynamic.Interface {
 }
 
 // GetK8sClientSet returns k8s clientSet
-func (c *cluster) GetK8sClientSet() *kubernetes.Clientset {
+func (c *cluster) GetK8sClientSet() kubernetes.Interface {
 	return c.clientset
 }
 
+// GetNodeConfig returns the kubelet configz of the node through the API server node proxy
+func (c *cluster) GetNodeConfig(ctx context.Context, nodeName string) ([]byte, error) {
+	return c.clientset.CoreV1().RESTClient().Get().AbsPath(fmt.Sprintf("/api/v1/nodes/%s/proxy/configz", nodeName)).DoRaw(ctx)
+}
+
 // GetK8sClientSet returns k8s clientSet
 func (c *cluster) Platform() Platform {
 	platform, err := c.Platfrom()
 	if err != nil {
-		return Platform{Name: "k8s", Version: "1.23.0"}
+		return Platform{Name: "k8s", Version: "1.23.0", Confidence: ConfidenceLow, Evidence: []string{fmt.Sprintf("detection failed: %v", err)}}
 	}
 	return platform
 }
 
-func (cluster *cluster) Platfrom() (Platform, error) {
-	v := cluster.getOpenShiftVersion(context.Background())
-	if len(v) != 0 {
-		return Platform{Name: "ocp", Version: majorVersion(v)}, nil
-	}
-	nodeName := cluster.getNodeName()
-	semVersion, err := cluster.clientset.ServerVersion()
-	if err != nil {
-		return Platform{}, err
-	}
-	p := getPlatformInfoFromVersion(semVersion.GitVersion)
-	var name string
-	switch {
-	case strings.Contains(p.Version, k3s):
-		name = k3s
-	case strings.Contains(p.Version, rke2):
-		name = rke2
-	case strings.Contains(p.Version, microk8s):
-		name = microk8s
-	case strings.Contains(nodeName, aks):
-		name = aks
-	case strings.Contains(nodeName, eks):
-		name = eks
-	case strings.Contains(nodeName, gke):
-		name = gke
-	default:
-		name = "k8s"
-	}
-	return Platform{Name: name, Version: p.Version}, nil
-}
-
-type Platform struct {
-	Name    string
-	Version string
-}
-
-func (cluster *cluster) getOpenShiftVersion(ctx context.Context) string {
-	gvr, err := cluster.restMapper.ResourceFor(schema.GroupVersionResource{Resource: "clusterversions"})
-	if err != nil {
-		return ""
-	}
-	dclient := cluster.dynamicClient.Resource(gvr).Namespace("")
-	resources, err := dclient.List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return ""
-	}
-	var version string
-	for _, resource := range resources.Items {
-		version, _, _ = unstructured.NestedString(resource.Object, []string{"status", "desired", "version"}...)
-
-	}
-	return version
-}
-
-func (cluster *cluster) getNodeName() string {
-	nodes, err := cluster.clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
-	if err != nil {
-		return "k8s"
-	}
-	return nodes.Items[0].Name
-}
-
 // GetGVRs returns cluster GroupVersionResource to query kubernetes, receives
 // a boolean to determine if returns namespaced GVRs only or all GVRs, unless
 // resources is passed to filter
@@ -377,6 +534,8 @@ func (c *cluster) GetGVRs(namespaced bool, resources []string) ([]schema.GroupVe
 	return grvs, nil
 }
 
+// GetGVR returns the GroupVersionResource of the resource, the deferred discovery mapper refreshes
+// a discovery served from cache when the resource is unknown, e.g. a CRD created after it was cached
 func (c *cluster) GetGVR(kind string) (schema.GroupVersionResource, error) {
 	return c.restMapper.ResourceFor(schema.GroupVersionResource{Resource: kind})
 }
@@ -578,7 +737,7 @@ func NodeInfo(node corev1.Node) bom.NodeInfo {
 	}
 }
 
-func getPodsInfo(ctx context.Context, clientset *kubernetes.Clientset, labelSelector string, namespace string) (*corev1.PodList, error) {
+func getPodsInfo(ctx context.Context, clientset kubernetes.Interface, labelSelector string, namespace string) (*corev1.PodList, error) {
 	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
 	if err != nil {
 		return nil, err
@@ -699,6 +858,16 @@ func k8sVersions(version string) string {
 	return version
 }
 
+// ClusterUID returns the UID of the kube-system namespace, a stable identity of the cluster
+// which does not depend on the kubeconfig context or cluster names
+func ClusterUID(ctx context.Context, clientset kubernetes.Interface) (string, error) {
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, k8sComponentNamespace, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("getting %s namespace: %w", k8sComponentNamespace, err)
+	}
+	return string(ns.UID), nil
+}
+
 func (c *cluster) isOpenShift() bool {
 	ctx := context.Background()
 	_, err := c.clientset.CoreV1().Namespaces().Get(ctx, "openshift-kube-apiserver", metav1.GetOptions{})
@@ -722,11 +891,17 @@ func (c *cluster) getClusterBomInfo(components []bom.Component, nodeInfo []bom.N
 }
 
 func (c *cluster) ClusterNameVersion() (string, string, error) {
-	rawCfg, err := c.cConfig.RawConfig()
-	if err != nil {
-		return "", "", err
-	}
 	clusterName := "k8s.io/kubernetes"
+	if c.clusterName != "" {
+		clusterName = c.clusterName
+	}
+	rawCfg := clientcmdapi.Config{}
+	if c.cConfig != nil {
+		var err error
+		if rawCfg, err = c.cConfig.RawConfig(); err != nil {
+			return "", "", err
+		}
+	}
 	if len(rawCfg.Contexts) > 0 {
 		if c.currentContext != "" {
 			rawCfg.CurrentContext = c.currentContext
@@ -735,44 +910,35 @@ func (c *cluster) ClusterNameVersion() (string, string, error) {
 			clusterName = clusterContext.Cluster
 		}
 	}
-	version, err := c.clientset.ServerVersion()
+	version, err := c.clientset.Discovery().ServerVersion()
 	if err != nil {
 		return "", "", err
 	}
 	return clusterName, version.GitVersion, nil
 }
 
-// ListImagePullSecretsByPodSpec return image pull secrets by pod spec
+// ListImagePullSecretsByPodSpec return image pull secrets by pod spec, credentials of several secrets
+// for the same registry are joined with commas, see ListCredentialsByPodSpec for the ordered credentials
 func (r *cluster) ListImagePullSecretsByPodSpec(ctx context.Context, spec *corev1.PodSpec, ns string) (map[string]docker.Auth, error) {
-	if spec == nil {
-		return map[string]docker.Auth{}, nil
-	}
-	imagePullSecrets := spec.ImagePullSecrets
-
-	sa, err := r.getServiceAccountByPodSpec(ctx, spec, ns)
-	if err != nil && !k8sapierror.IsNotFound(err) && !k8sapierror.IsForbidden(err) {
-		return nil, err
-	}
-	imagePullSecrets = append(sa.ImagePullSecrets, imagePullSecrets...)
-
-	secrets, err := r.ListByLocalObjectReferences(ctx, imagePullSecrets, ns)
+	creds, err := r.ListCredentialsByPodSpec(ctx, spec, ns)
 	if err != nil {
 		return nil, err
 	}
-
-	return mapDockerRegistryServersToAuths(secrets, true)
+	return creds.JoinedAuths(), nil
 }
 
-func (r *cluster) getServiceAccountByPodSpec(ctx context.Context, spec *corev1.PodSpec, ns string) (*corev1.ServiceAccount, error) {
-	serviceAccountName := spec.ServiceAccountName
-	if serviceAccountName == "" {
-		serviceAccountName = serviceAccountDefault
+func podSpecImages(spec *corev1.PodSpec) []string {
+	images := make([]string, 0)
+	for _, c := range spec.InitContainers {
+		images = append(images, c.Image)
 	}
-	sa, err := r.clientset.CoreV1().ServiceAccounts(ns).Get(ctx, serviceAccountName, metav1.GetOptions{})
-	if err != nil {
-		return sa, fmt.Errorf("getting service account by name: %s/%s: %w", ns, serviceAccountName, err)
+	for _, c := range spec.Containers {
+		images = append(images, c.Image)
+	}
+	for _, c := range spec.EphemeralContainers {
+		images = append(images, c.Image)
 	}
-	return sa, nil
+	return images
 }
 
 func (r *cluster) ListByLocalObjectReferences(ctx context.Context, refs []corev1.LocalObjectReference, ns string) ([]*corev1.Secret, error) {
@@ -794,73 +960,102 @@ func (r *cluster) ListByLocalObjectReferences(ctx context.Context, refs []corev1
 	return secrets, nil
 }
 
+// MapSecretDockerRegistryServersToAuths creates the mapping from a Docker registry server
+// to the Docker authentication credentials of a single image pull Secret.
+func MapSecretDockerRegistryServersToAuths(secret *corev1.Secret) (map[string]docker.Auth, error) {
+	return mapDockerRegistryServersToAuths(context.Background(), []*corev1.Secret{secret}, nil)
+}
+
 // MapDockerRegistryServersToAuths creates the mapping from a Docker registry server
 // to the Docker authentication credentials for the specified slice of image pull Secrets.
-func mapDockerRegistryServersToAuths(imagePullSecrets []*corev1.Secret, multiSecretSupport bool) (map[string]docker.Auth, error) {
+// Credential helpers referenced by the secrets are resolved when a helper runner is given.
+func mapDockerRegistryServersToAuths(ctx context.Context, imagePullSecrets []*corev1.Secret, helper *docker.CredentialHelper) (map[string]docker.Auth, error) {
 	auths := make(map[string]docker.Auth)
 	for _, secret := range imagePullSecrets {
-		var data []byte
-		var hasRequiredData, isLegacy bool
-
-		switch secret.Type {
-		case corev1.SecretTypeDockerConfigJson:
-			data, hasRequiredData = secret.Data[corev1.DockerConfigJsonKey]
-		case corev1.SecretTypeDockercfg:
-			data, hasRequiredData = secret.Data[corev1.DockerConfigKey]
-			isLegacy = true
-		default:
-			continue
+		secretAuths, err := readSecretAuths(ctx, secret, helper)
+		if err != nil {
+			return nil, err
 		}
-
-		// Skip a secrets of type "kubernetes.io/dockerconfigjson" or "kubernetes.io/dockercfg" which does not contain
-		// the required ".dockerconfigjson" or ".dockercfg" key.
-		if !hasRequiredData {
-			continue
+		for server, auth := range secretAuths {
+			auths[server] = auth
 		}
-		dockerConfig := &docker.Config{}
-		err := dockerConfig.Read(data, isLegacy)
+	}
+	return auths, nil
+}
+
+// readSecretAuths returns the credentials of an image pull Secret by registry key,
+// nil for secrets of other types or without the required data
+func readSecretAuths(ctx context.Context, secret *corev1.Secret, helper *docker.CredentialHelper) (map[string]docker.Auth, error) {
+	var data []byte
+	var hasRequiredData, isLegacy bool
+
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		data, hasRequiredData = secret.Data[corev1.DockerConfigJsonKey]
+	case corev1.SecretTypeDockercfg:
+		data, hasRequiredData = secret.Data[corev1.DockerConfigKey]
+		isLegacy = true
+	default:
+		return nil, nil
+	}
+
+	// Skip a secrets of type "kubernetes.io/dockerconfigjson" or "kubernetes.io/dockercfg" which does not contain
+	// the required ".dockerconfigjson" or ".dockercfg" key.
+	if !hasRequiredData {
+		return nil, nil
+	}
+	dockerConfig := &docker.Config{}
+	err := dockerConfig.Read(data, isLegacy)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s or %s field of %q secret: %w", corev1.DockerConfigJsonKey, corev1.DockerConfigKey, secret.Namespace+"/"+secret.Name, err)
+	}
+	secretAuths := dockerConfig.Auths
+	if helper != nil && dockerConfig.HasCredentialHelpers() {
+		secretAuths, err = dockerConfig.ResolveAuths(ctx, helper)
 		if err != nil {
-			return nil, fmt.Errorf("reading %s or %s field of %q secret: %w", corev1.DockerConfigJsonKey, corev1.DockerConfigKey, secret.Namespace+"/"+secret.Name, err)
+			slog.Warn("Unable to resolve credential helpers", "secret", secret.Namespace+"/"+secret.Name, "error", err)
 		}
-		for authKey, auth := range dockerConfig.Auths {
-			server, err := docker.GetServerFromDockerAuthKey(authKey)
-			if err != nil {
-				return nil, err
-			}
-			if a, ok := auths[server]; multiSecretSupport && ok {
-				user := fmt.Sprintf("%s,%s", a.Username, auth.Username)
-				pass := fmt.Sprintf("%s,%s", a.Password, auth.Password)
-				auths[server] = docker.Auth{Username: user, Password: pass}
-			} else {
-				auths[server] = auth
-			}
+	}
+	auths := make(map[string]docker.Auth, len(secretAuths))
+	for authKey, auth := range secretAuths {
+		server, err := docker.GetRegistryKeyFromDockerAuthKey(authKey)
+		if err != nil {
+			return nil, err
 		}
+		auths[server] = auth
 	}
 	return auths, nil
 }
 
 type ContainerImages map[string]string
 
+// MapContainerNamesToDockerAuths returns the credentials the kubelet would use first for the image,
+// nil when no registry key matches
 func MapContainerNamesToDockerAuths(imageRef string, auths map[string]docker.Auth) (*docker.Auth, error) {
-	wildcardServers := GetWildcardServers(auths)
+	matched, err := MapContainerNamesToAllDockerAuths(imageRef, auths)
+	if err != nil || len(matched) == 0 {
+		return nil, err
+	}
+	return &matched[0], nil
+}
 
-	var authsCred docker.Auth
-	server, err := docker.GetServerFromImageRef(imageRef)
-	if err != nil {
-		return &authsCred, err
+// MapContainerNamesToAllDockerAuths returns every credential matching the image in the kubelet keyring order.
+// Registry keys may contain a port, a path prefix and globs in any host label (e.g. `*.*.amazonaws.com`),
+// keys are tried in reverse lexical order so the most specific key comes first.
+func MapContainerNamesToAllDockerAuths(imageRef string, auths map[string]docker.Auth) ([]docker.Auth, error) {
+	keys := make([]string, 0, len(auths))
+	for key := range auths {
+		keys = append(keys, key)
 	}
-	if auth, ok := auths[server]; ok {
-		return &auth, nil
+	matched, err := matchRegistryKeys(imageRef, keys)
+	if err != nil {
+		return nil, err
 	}
-	if len(wildcardServers) > 0 {
-		if wildcardDomain := matchSubDomain(wildcardServers, server); len(wildcardDomain) > 0 {
-			if auth, ok := auths[wildcardDomain]; ok {
-				return &auth, nil
-			}
-		}
+	var result []docker.Auth
+	for _, key := range matched {
+		result = append(result, auths[key])
 	}
-
-	return nil, nil
+	return result, nil
 }
 
 func GetWildcardServers(auths map[string]docker.Auth) []string {
@@ -873,16 +1068,6 @@ func GetWildcardServers(auths map[string]docker.Auth) []string {
 	return wildcardServers
 }
 
-func matchSubDomain(wildcardServers []string, subDomain string) string {
-	for _, domain := range wildcardServers {
-		domainWithoutWildcard := strings.Replace(domain, "*", "", 1)
-		if strings.HasSuffix(subDomain, domainWithoutWildcard) {
-			return domain
-		}
-	}
-	return ""
-}
-
 func getWorkloadPodSpec(un unstructured.Unstructured) (*corev1.PodSpec, error) {
 	switch un.GetKind() {
 	case KindPod:
@@ -927,16 +1112,11 @@ func mapToPodSpec(objectMap map[string]interface{}) (*corev1.PodSpec, error) {
 }
 
 func (r *cluster) AuthByResource(resource unstructured.Unstructured) (map[string]docker.Auth, error) {
-	podSpec, err := getWorkloadPodSpec(resource)
-	if err != nil {
-		return nil, err
-	}
-	var serverAuths map[string]docker.Auth
-	serverAuths, err = r.ListImagePullSecretsByPodSpec(context.Background(), podSpec, resource.GetNamespace())
+	creds, err := r.CredentialsByResource(resource)
 	if err != nil {
 		return nil, err
 	}
-	return serverAuths, nil
+	return creds.JoinedAuths(), nil
 }
 
 func upstreamOrgByName(component string) string {
@@ -1,16 +1,26 @@
 package k8s
 
 import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/aquasecurity/trivy-kubernetes/pkg/bom"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/utils/ptr"
 )
 
 func TestGetCurrentNamespace(t *testing.T) {
@@ -708,3 +718,160 @@ func TestGetContainer(t *testing.T) {
 		})
 	}
 }
+
+func TestMapContainerNamesToAllDockerAuths(t *testing.T) {
+	auths := map[string]docker.Auth{
+		"registry.example.com":                 {Username: "registry"},
+		"registry.example.com/team-a":          {Username: "team-a"},
+		"registry.example.com/team-a/app":      {Username: "team-a-app"},
+		"*.example.com":                        {Username: "wildcard"},
+		"*.dkr.ecr.*.amazonaws.com":            {Username: "ecr"},
+		"registry.example.com:5000":            {Username: "port"},
+		"https://index.docker.io/v1/":          {Username: "dockerhub"},
+		"123456789012.dkr.ecr.*.amazonaws.com": {Username: "ecr-account"},
+	}
+	tests := []struct {
+		name  string
+		image string
+		want  []string
+	}{
+		{
+			name:  "path prefixes, most specific first",
+			image: "registry.example.com/team-a/app:1.0",
+			want:  []string{"team-a-app", "team-a", "registry", "wildcard"},
+		},
+		{
+			name:  "other path",
+			image: "registry.example.com/team-b/app:1.0",
+			want:  []string{"registry", "wildcard"},
+		},
+		{
+			name:  "port must match",
+			image: "registry.example.com:5000/app:1.0",
+			want:  []string{"port"},
+		},
+		{
+			name:  "single label glob",
+			image: "a.registry.example.com/app:1.0",
+			want:  nil,
+		},
+		{
+			name:  "multi label glob",
+			image: "123456789012.dkr.ecr.eu-west-1.amazonaws.com/app:1.0",
+			want:  []string{"ecr-account", "ecr"},
+		},
+		{
+			name:  "docker hub legacy key",
+			image: "nginx:1.25",
+			want:  []string{"dockerhub"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MapContainerNamesToAllDockerAuths(tt.image, auths)
+			require.NoError(t, err)
+			var usernames []string
+			for _, auth := range got {
+				usernames = append(usernames, auth.Username)
+			}
+			assert.Equal(t, tt.want, usernames)
+
+			first, err := MapContainerNamesToDockerAuths(tt.image, auths)
+			require.NoError(t, err)
+			if len(tt.want) == 0 {
+				assert.Nil(t, first)
+			} else {
+				assert.Equal(t, tt.want[0], first.Username)
+			}
+		})
+	}
+}
+
+func TestClusterOptionsRESTConfig(t *testing.T) {
+	config := createValidTestConfig("")
+	rawConfig, err := config.RawConfig()
+	require.NoError(t, err)
+	kubeConfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, clientcmd.WriteToFile(rawConfig, kubeConfig))
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, []byte("ca-bundle"), 0o600))
+	proxyURL, err := url.Parse("http://proxy.example.com:3128")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		opts   []ClusterOption
+		assert func(t *testing.T, c *rest.Config)
+	}{
+		{
+			name: "kubeconfig",
+			assert: func(t *testing.T, c *rest.Config) {
+				assert.Equal(t, "https://anything.com:8080", c.Host)
+				assert.Equal(t, "the-token", c.BearerToken)
+				assert.Empty(t, c.Impersonate.UserName)
+				assert.Nil(t, c.Proxy)
+				assert.Zero(t, c.Timeout)
+			},
+		},
+		{
+			name: "impersonation",
+			opts: []ClusterOption{WithImpersonation("scanner", "system:authenticated", "scanners")},
+			assert: func(t *testing.T, c *rest.Config) {
+				assert.Equal(t, "scanner", c.Impersonate.UserName)
+				assert.Equal(t, []string{"system:authenticated", "scanners"}, c.Impersonate.Groups)
+			},
+		},
+		{
+			name: "server, token and CA",
+			opts: []ClusterOption{
+				WithAPIServer("https://api.example.com:6443"),
+				WithBearerToken("vault-token"),
+				WithCAData([]byte("ca-bundle")),
+				WithTLSServerName("kubernetes.default.svc"),
+			},
+			assert: func(t *testing.T, c *rest.Config) {
+				assert.Equal(t, "https://api.example.com:6443", c.Host)
+				assert.Equal(t, "vault-token", c.BearerToken)
+				assert.Equal(t, []byte("ca-bundle"), c.TLSClientConfig.CAData)
+				assert.Equal(t, "kubernetes.default.svc", c.TLSClientConfig.ServerName)
+			},
+		},
+		{
+			name: "config flags",
+			opts: []ClusterOption{WithConfigFlags(func(f *genericclioptions.ConfigFlags) {
+				f.BearerToken = ptr.To("flags-token")
+			})},
+			assert: func(t *testing.T, c *rest.Config) {
+				assert.Equal(t, "flags-token", c.BearerToken)
+			},
+		},
+		{
+			name: "CA file",
+			opts: []ClusterOption{WithCAFile(caFile)},
+			assert: func(t *testing.T, c *rest.Config) {
+				assert.Equal(t, caFile, c.TLSClientConfig.CAFile)
+			},
+		},
+		{
+			name: "proxy and timeout",
+			opts: []ClusterOption{WithProxyURL(proxyURL), WithRequestTimeout(30 * time.Second), WithQPS(50)},
+			assert: func(t *testing.T, c *rest.Config) {
+				require.NotNil(t, c.Proxy)
+				got, err := c.Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "api.example.com"}})
+				require.NoError(t, err)
+				assert.Equal(t, proxyURL, got)
+				assert.Equal(t, 30*time.Second, c.Timeout)
+				assert.Equal(t, float32(50), c.QPS)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newClusterOptions(append([]ClusterOption{WithKubeConfig(kubeConfig)}, tt.opts...)...)
+			c, err := o.configFlags.ToRESTConfig()
+			require.NoError(t, err)
+			tt.assert(t, c)
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	kind     = "kind"
+	minikube = "minikube"
+	talos    = "talos"
+
+	// platformNodesSample is the number of nodes inspected for platform signals
+	platformNodesSample = 20
+)
+
+// Confidence tells how reliable the platform detection is
+type Confidence string
+
+const (
+	// ConfidenceHigh several or unambiguous signals agree
+	ConfidenceHigh Confidence = "high"
+	// ConfidenceMedium a single platform specific signal, or two weak ones
+	ConfidenceMedium Confidence = "medium"
+	// ConfidenceLow vanilla k8s, no platform specific signal or a single weak one
+	ConfidenceLow Confidence = "low"
+)
+
+type Platform struct {
+	Name    string
+	Version string
+	// Confidence of the detected name
+	Confidence Confidence
+	// Evidence lists the signals the platform was detected from
+	Evidence []string
+}
+
+// platformSignals holds the cluster information the platform is detected from
+type platformSignals struct {
+	gitVersion       string
+	openShiftVersion string
+	apiGroups        []string
+	nodes            []corev1.Node
+}
+
+// signal weights, strong signals are specific to a single platform
+const (
+	weakSignal   = 1
+	strongSignal = 2
+)
+
+var (
+	providerIDSchemes = []struct {
+		prefix   string
+		platform string
+		weight   int
+	}{
+		// self managed clusters run on the clouds too, the scheme alone is not conclusive
+		{prefix: "aws://", platform: eks, weight: weakSignal},
+		{prefix: "gce://", platform: gke, weight: weakSignal},
+		{prefix: "azure://", platform: aks, weight: weakSignal},
+		{prefix: "kind://", platform: kind, weight: strongSignal},
+		{prefix: "k3s://", platform: k3s, weight: strongSignal},
+	}
+	nodeLabelPrefixes = []struct {
+		prefix   string
+		platform string
+	}{
+		{prefix: "eks.amazonaws.com/", platform: eks},
+		{prefix: "cloud.google.com/gke-", platform: gke},
+		{prefix: "kubernetes.azure.com/", platform: aks},
+		{prefix: "minikube.k8s.io/", platform: minikube},
+		{prefix: "microk8s.io/", platform: microk8s},
+		{prefix: "node.openshift.io/", platform: ocp},
+	}
+	versionSuffixes = []struct {
+		re       *regexp.Regexp
+		platform string
+	}{
+		{re: regexp.MustCompile(`-eks-`), platform: eks},
+		{re: regexp.MustCompile(`-gke\.`), platform: gke},
+		{re: regexp.MustCompile(`\+k3s`), platform: k3s},
+		{re: regexp.MustCompile(`\+rke2`), platform: rke2},
+	}
+	apiGroupPlatforms = map[string]string{
+		"config.openshift.io":  ocp,
+		"networking.gke.io":    gke,
+		"vpcresources.k8s.aws": eks,
+		"k3s.cattle.io":        k3s,
+	}
+	// platformPrecedence breaks ties, distributions before the clouds they may run on
+	platformPrecedence = []string{ocp, k3s, rke2, microk8s, talos, minikube, kind, eks, gke, aks}
+)
+
+// Platfrom detects the platform of the cluster, see Platform for the evidence used
+func (cluster *cluster) Platfrom() (Platform, error) {
+	ctx := context.Background()
+	semVersion, err := cluster.clientset.Discovery().ServerVersion()
+	if err != nil {
+		return Platform{}, err
+	}
+	signals := platformSignals{
+		gitVersion:       semVersion.GitVersion,
+		openShiftVersion: cluster.getOpenShiftVersion(ctx),
+	}
+	// nodes and API groups are optional signals, a scanning identity may not list them
+	if nodes, err := cluster.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: platformNodesSample}); err == nil {
+		signals.nodes = nodes.Items
+	}
+	if groups, err := cluster.clientset.Discovery().ServerGroups(); err == nil {
+		for _, g := range groups.Groups {
+			signals.apiGroups = append(signals.apiGroups, g.Name)
+		}
+	}
+	return detectPlatform(signals), nil
+}
+
+func detectPlatform(signals platformSignals) Platform {
+	scores := make(map[string]int)
+	evidence := make(map[string][]string)
+	seen := make(map[string]struct{})
+	add := func(platform string, weight int, e string) {
+		if _, ok := seen[e]; ok {
+			return
+		}
+		seen[e] = struct{}{}
+		scores[platform] += weight
+		evidence[platform] = append(evidence[platform], e)
+	}
+
+	if signals.openShiftVersion != "" {
+		add(ocp, strongSignal, fmt.Sprintf("clusterversion %s", signals.openShiftVersion))
+	}
+	for _, suffix := range versionSuffixes {
+		if suffix.re.MatchString(signals.gitVersion) {
+			add(suffix.platform, strongSignal, fmt.Sprintf("server version %s", signals.gitVersion))
+		}
+	}
+	for _, group := range signals.apiGroups {
+		if platform, ok := apiGroupPlatforms[group]; ok {
+			add(platform, weakSignal, fmt.Sprintf("API group %s", group))
+		}
+	}
+	for _, node := range signals.nodes {
+		for _, scheme := range providerIDSchemes {
+			if strings.HasPrefix(node.Spec.ProviderID, scheme.prefix) {
+				add(scheme.platform, scheme.weight, fmt.Sprintf("node providerID scheme %s", scheme.prefix))
+			}
+		}
+		for label := range node.Labels {
+			for _, prefix := range nodeLabelPrefixes {
+				if strings.HasPrefix(label, prefix.prefix) {
+					add(prefix.platform, strongSignal, fmt.Sprintf("node label %s*", prefix.prefix))
+				}
+			}
+		}
+		if strings.Contains(node.Status.NodeInfo.OSImage, "Talos") {
+			add(talos, strongSignal, fmt.Sprintf("node OS image %s", node.Status.NodeInfo.OSImage))
+		}
+		if strings.Contains(node.Status.NodeInfo.KubeletVersion, "+k3s") {
+			add(k3s, weakSignal, "kubelet version +k3s")
+		}
+		if strings.Contains(node.Status.NodeInfo.KubeletVersion, "+rke2") {
+			add(rke2, weakSignal, "kubelet version +rke2")
+		}
+	}
+
+	name, score := native, 0
+	for _, platform := range platformPrecedence {
+		if scores[platform] > score {
+			name, score = platform, scores[platform]
+		}
+	}
+	// a single weak signal such as the cloud of the nodes is not conclusive, self managed clusters run there too
+	if score < strongSignal {
+		name, score = native, 0
+	}
+
+	p := Platform{Name: name, Version: majorVersion(signals.gitVersion), Evidence: evidence[name]}
+	if name == ocp && signals.openShiftVersion != "" {
+		p.Version = majorVersion(signals.openShiftVersion)
+	}
+	switch {
+	case score > strongSignal:
+		p.Confidence = ConfidenceHigh
+	case score == strongSignal:
+		p.Confidence = ConfidenceMedium
+	default:
+		p.Confidence = ConfidenceLow
+	}
+	return p
+}
+
+func (cluster *cluster) getOpenShiftVersion(ctx context.Context) string {
+	gvr, err := cluster.restMapper.ResourceFor(schema.GroupVersionResource{Resource: "clusterversions"})
+	if err != nil {
+		return ""
+	}
+	dclient := cluster.dynamicClient.Resource(gvr).Namespace("")
+	resources, err := dclient.List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return ""
+	}
+	var version string
+	for _, resource := range resources.Items {
+		version, _, _ = unstructured.NestedString(resource.Object, []string{"status", "desired", "version"}...)
+
+	}
+	return version
+}
@@ -0,0 +1,150 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func platformNode(providerID string, labels map[string]string, osImage string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: labels},
+		Spec:       corev1.NodeSpec{ProviderID: providerID},
+		Status:     corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{OSImage: osImage}},
+	}
+}
+
+func TestDetectPlatform(t *testing.T) {
+	tests := []struct {
+		name    string
+		signals platformSignals
+		want    Platform
+	}{
+		{
+			name:    "no nodes",
+			signals: platformSignals{gitVersion: "v1.29.1"},
+			want:    Platform{Name: "k8s", Version: "1.29", Confidence: ConfidenceLow},
+		},
+		{
+			name: "eks",
+			signals: platformSignals{
+				gitVersion: "v1.27.3-eks-a5565ad",
+				nodes: []corev1.Node{platformNode("aws:///eu-west-1a/i-0123456789", map[string]string{
+					"eks.amazonaws.com/nodegroup": "default",
+				}, "Amazon Linux 2")},
+			},
+			want: Platform{Name: "eks", Version: "1.27", Confidence: ConfidenceHigh, Evidence: []string{
+				"server version v1.27.3-eks-a5565ad", "node providerID scheme aws://", "node label eks.amazonaws.com/*",
+			}},
+		},
+		{
+			name: "self managed on aws",
+			signals: platformSignals{
+				gitVersion: "v1.28.2",
+				nodes:      []corev1.Node{platformNode("aws:///eu-west-1a/i-0123456789", nil, "Ubuntu 22.04.3 LTS")},
+			},
+			want: Platform{Name: "k8s", Version: "1.28", Confidence: ConfidenceLow},
+		},
+		{
+			name: "eks from weak signals",
+			signals: platformSignals{
+				gitVersion: "v1.28.2",
+				apiGroups:  []string{"apps", "vpcresources.k8s.aws"},
+				nodes:      []corev1.Node{platformNode("aws:///eu-west-1a/i-0123456789", nil, "Bottlerocket OS 1.19.2")},
+			},
+			want: Platform{Name: "eks", Version: "1.28", Confidence: ConfidenceMedium, Evidence: []string{
+				"API group vpcresources.k8s.aws", "node providerID scheme aws://",
+			}},
+		},
+		{
+			name: "gke",
+			signals: platformSignals{
+				gitVersion: "v1.28.3-gke.1286000",
+				nodes: []corev1.Node{platformNode("gce://project/europe-west1-b/gke-cluster-default-pool-1", map[string]string{
+					"cloud.google.com/gke-nodepool": "default-pool",
+				}, "Container-Optimized OS from Google")},
+			},
+			want: Platform{Name: "gke", Version: "1.28", Confidence: ConfidenceHigh, Evidence: []string{
+				"server version v1.28.3-gke.1286000", "node providerID scheme gce://", "node label cloud.google.com/gke-*",
+			}},
+		},
+		{
+			name: "aks",
+			signals: platformSignals{
+				gitVersion: "v1.28.5",
+				nodes: []corev1.Node{platformNode("azure:///subscriptions/id/resourceGroups/mc_rg/providers/Microsoft.Compute/virtualMachineScaleSets/aks-nodepool1/virtualMachines/0", map[string]string{
+					"kubernetes.azure.com/cluster": "mc_rg",
+				}, "Ubuntu 22.04.3 LTS")},
+			},
+			want: Platform{Name: "aks", Version: "1.28", Confidence: ConfidenceHigh, Evidence: []string{
+				"node providerID scheme azure://", "node label kubernetes.azure.com/*",
+			}},
+		},
+		{
+			name: "k3s on aws",
+			signals: platformSignals{
+				gitVersion: "v1.27.4+k3s1",
+				apiGroups:  []string{"apps", "k3s.cattle.io"},
+				nodes:      []corev1.Node{platformNode("aws:///eu-west-1a/i-0123456789", nil, "Ubuntu 22.04.3 LTS")},
+			},
+			want: Platform{Name: "k3s", Version: "1.27", Confidence: ConfidenceHigh, Evidence: []string{
+				"server version v1.27.4+k3s1", "API group k3s.cattle.io",
+			}},
+		},
+		{
+			name:    "rke2",
+			signals: platformSignals{gitVersion: "v1.28.3+rke2r1"},
+			want:    Platform{Name: "rke2", Version: "1.28", Confidence: ConfidenceMedium, Evidence: []string{"server version v1.28.3+rke2r1"}},
+		},
+		{
+			name: "microk8s",
+			signals: platformSignals{
+				gitVersion: "v1.28.3",
+				nodes:      []corev1.Node{platformNode("", map[string]string{"microk8s.io/cluster": "true"}, "Ubuntu 22.04.3 LTS")},
+			},
+			want: Platform{Name: "microk8s", Version: "1.28", Confidence: ConfidenceMedium, Evidence: []string{"node label microk8s.io/*"}},
+		},
+		{
+			name: "kind",
+			signals: platformSignals{
+				gitVersion: "v1.29.2",
+				nodes:      []corev1.Node{platformNode("kind://docker/kind/kind-control-plane", nil, "Debian GNU/Linux 12 (bookworm)")},
+			},
+			want: Platform{Name: "kind", Version: "1.29", Confidence: ConfidenceMedium, Evidence: []string{"node providerID scheme kind://"}},
+		},
+		{
+			name: "minikube",
+			signals: platformSignals{
+				gitVersion: "v1.28.3",
+				nodes:      []corev1.Node{platformNode("", map[string]string{"minikube.k8s.io/name": "minikube"}, "Buildroot 2021.02.12")},
+			},
+			want: Platform{Name: "minikube", Version: "1.28", Confidence: ConfidenceMedium, Evidence: []string{"node label minikube.k8s.io/*"}},
+		},
+		{
+			name: "talos",
+			signals: platformSignals{
+				gitVersion: "v1.29.0",
+				nodes:      []corev1.Node{platformNode("", nil, "Talos (v1.6.1)")},
+			},
+			want: Platform{Name: "talos", Version: "1.29", Confidence: ConfidenceMedium, Evidence: []string{"node OS image Talos (v1.6.1)"}},
+		},
+		{
+			name: "openshift",
+			signals: platformSignals{
+				gitVersion:       "v1.27.6+b49f9d1",
+				openShiftVersion: "4.14.3",
+				apiGroups:        []string{"config.openshift.io"},
+			},
+			want: Platform{Name: "ocp", Version: "4.14", Confidence: ConfidenceHigh, Evidence: []string{
+				"clusterversion 4.14.3", "API group config.openshift.io",
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, detectPlatform(tt.signals))
+		})
+	}
+}
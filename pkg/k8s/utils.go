@@ -6,21 +6,6 @@ import (
 	"strings"
 )
 
-func getPlatformInfoFromVersion(s string) Platform {
-	versionRe := regexp.MustCompile(`v(\d+\.\d+)\.\d+[-+](\w+)(?:[.\-])\w+`)
-	subs := versionRe.FindStringSubmatch(s)
-	if len(subs) < 3 {
-		return Platform{
-			Name:    "k8s",
-			Version: majorVersion(s),
-		}
-	}
-	return Platform{
-		Name:    subs[2],
-		Version: subs[1],
-	}
-}
-
 func majorVersion(semanticVersion string) string {
 	versionRe := regexp.MustCompile(`v(\d+\.\d+)\.\d+`)
 	version := semanticVersion
@@ -28,5 +13,8 @@ func majorVersion(semanticVersion string) string {
 		version = fmt.Sprintf("v%s", semanticVersion)
 	}
 	subs := versionRe.FindStringSubmatch(version)
+	if len(subs) < 2 {
+		return ""
+	}
 	return subs[1]
 }
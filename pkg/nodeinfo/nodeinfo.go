@@ -0,0 +1,106 @@
+// Package nodeinfo is the versioned model of the node-collector output
+package nodeinfo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// APIVersion is the schema version of the node-collector output
+	APIVersion = "v1"
+	// Kind is the kind of the node-collector output
+	Kind = "NodeInfo"
+)
+
+var (
+	// ErrUnsupportedVersion is returned for the node-collector output of another schema version
+	ErrUnsupportedVersion = errors.New("unsupported node info apiVersion")
+	// ErrInvalidNodeInfo is returned when the node-collector output is not a valid NodeInfo
+	ErrInvalidNodeInfo = errors.New("invalid node info")
+)
+
+// NodeInfo is the output of the node-collector on a node
+type NodeInfo struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Metadata   Metadata `json:"metadata"`
+	// Type is the type of the node, master or worker
+	Type string `json:"type"`
+	// Info holds the result of each check by its command key
+	Info map[string]CheckResult `json:"info"`
+}
+
+type Metadata struct {
+	CreationTimestamp time.Time `json:"creationTimestamp"`
+}
+
+// CheckResult is the result of a node-collector command, values are strings or numbers, json.Number once parsed
+type CheckResult struct {
+	Values []any `json:"values"`
+	// Error is set when the command of the check failed on the node
+	Error string `json:"error,omitempty"`
+}
+
+// Strings returns the values of the check formatted as strings
+func (r CheckResult) Strings() []string {
+	values := make([]string, 0, len(r.Values))
+	for _, v := range r.Values {
+		values = append(values, fmt.Sprint(v))
+	}
+	return values
+}
+
+// Parse decodes and validates the node-collector output, numbers are kept as json.Number
+func Parse(data []byte) (*NodeInfo, error) {
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("%w: output is not valid JSON", ErrInvalidNodeInfo)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var nodeInfo NodeInfo
+	if err := decoder.Decode(&nodeInfo); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidNodeInfo, err)
+	}
+	if err := nodeInfo.Validate(); err != nil {
+		return nil, err
+	}
+	return &nodeInfo, nil
+}
+
+// Validate checks the schema version, the kind and the checks of the node info
+func (n *NodeInfo) Validate() error {
+	if n.APIVersion != APIVersion {
+		return fmt.Errorf("%w %q, expected %q", ErrUnsupportedVersion, n.APIVersion, APIVersion)
+	}
+	if n.Kind != Kind {
+		return fmt.Errorf("%w: kind %q, expected %q", ErrInvalidNodeInfo, n.Kind, Kind)
+	}
+	if n.Info == nil {
+		return fmt.Errorf("%w: missing info", ErrInvalidNodeInfo)
+	}
+	for key, result := range n.Info {
+		for _, v := range result.Values {
+			switch v.(type) {
+			case string, json.Number:
+			default:
+				return fmt.Errorf("%w: check %s has a value of type %T", ErrInvalidNodeInfo, key, v)
+			}
+		}
+	}
+	return nil
+}
+
+// Errors returns the error of the failed checks by command key
+func (n *NodeInfo) Errors() map[string]string {
+	errs := make(map[string]string)
+	for key, result := range n.Info {
+		if result.Error != "" {
+			errs[key] = result.Error
+		}
+	}
+	return errs
+}
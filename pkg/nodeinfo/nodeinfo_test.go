@@ -0,0 +1,96 @@
+package nodeinfo
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNodeInfo(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		want       *NodeInfo
+		wantErr    error
+		wantErrMsg string
+	}{
+		{
+			name: "values and errors",
+			data: `{"apiVersion":"v1","kind":"NodeInfo","metadata":{"creationTimestamp":"2024-06-05T06:26:02Z"},"type":"master",
+				"info":{"adminConfFilePermissions":{"values":[600]},"kubeletEventQpsArgumentSet":{"values":[]},
+				"kubeletAuthorizationModeArgumentSet":{"values":["Webhook"]},"etcdDataDirectoryOwnership":{"values":[],"error":"exit status 1"}}}`,
+			want: &NodeInfo{
+				APIVersion: "v1",
+				Kind:       "NodeInfo",
+				Metadata:   Metadata{CreationTimestamp: time.Date(2024, 6, 5, 6, 26, 2, 0, time.UTC)},
+				Type:       "master",
+				Info: map[string]CheckResult{
+					"adminConfFilePermissions":            {Values: []any{json.Number("600")}},
+					"kubeletEventQpsArgumentSet":          {Values: []any{}},
+					"kubeletAuthorizationModeArgumentSet": {Values: []any{"Webhook"}},
+					"etcdDataDirectoryOwnership":          {Values: []any{}, Error: "exit status 1"},
+				},
+			},
+		},
+		{
+			name:       "unsupported version",
+			data:       `{"apiVersion":"v2","kind":"NodeInfo","type":"master","info":{}}`,
+			wantErr:    ErrUnsupportedVersion,
+			wantErrMsg: `unsupported node info apiVersion "v2", expected "v1"`,
+		},
+		{
+			name:       "missing version",
+			data:       `{"kind":"NodeInfo","type":"master","info":{}}`,
+			wantErr:    ErrUnsupportedVersion,
+			wantErrMsg: `unsupported node info apiVersion "", expected "v1"`,
+		},
+		{
+			name:       "kind",
+			data:       `{"apiVersion":"v1","kind":"Node","info":{}}`,
+			wantErr:    ErrInvalidNodeInfo,
+			wantErrMsg: `invalid node info: kind "Node", expected "NodeInfo"`,
+		},
+		{
+			name:       "missing info",
+			data:       `{"apiVersion":"v1","kind":"NodeInfo","type":"master"}`,
+			wantErr:    ErrInvalidNodeInfo,
+			wantErrMsg: "invalid node info: missing info",
+		},
+		{
+			name:       "value type",
+			data:       `{"apiVersion":"v1","kind":"NodeInfo","info":{"kubeletConfFilePermissions":{"values":[{"mode":600}]}}}`,
+			wantErr:    ErrInvalidNodeInfo,
+			wantErrMsg: "invalid node info: check kubeletConfFilePermissions has a value of type map[string]interface {}",
+		},
+		{
+			name:       "truncated",
+			data:       `{"apiVersion":"v1","kind":"NodeInfo","info":{"kubeletConf`,
+			wantErr:    ErrInvalidNodeInfo,
+			wantErrMsg: "invalid node info: output is not valid JSON",
+		},
+		{
+			name:    "check shape",
+			data:    `{"apiVersion":"v1","kind":"NodeInfo","info":{"kubeletConfFilePermissions":[600]}}`,
+			wantErr: ErrInvalidNodeInfo,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.data))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				if tt.wantErrMsg != "" {
+					assert.EqualError(t, err, tt.wantErrMsg)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, map[string]string{"etcdDataDirectoryOwnership": "exit status 1"}, got.Errors())
+			assert.Equal(t, []string{"600"}, got.Info["adminConfFilePermissions"].Strings())
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+)
+
+// ImageMetadata holds image information fetched from a registry
+type ImageMetadata struct {
+	Reference string
+	// Digest is the digest of the manifest (or image index) the reference points to
+	Digest    string
+	MediaType string
+	// Platforms lists the platforms of a multi-platform image index
+	Platforms    []Platform
+	ConfigDigest string
+	OS           string
+	Architecture string
+	Variant      string
+	Labels       map[string]string
+	Created      time.Time
+}
+
+// Platform describes a manifest of an image index
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+	Digest       string
+}
+
+const defaultInspectTimeout = 30 * time.Second
+
+// Inspector fetches image metadata from registries, results and failures are cached by image reference
+// and credential for the lifetime of the inspector, meant to be a scan
+type Inspector struct {
+	platform  v1.Platform
+	transport http.RoundTripper
+	insecure  bool
+	timeout   time.Duration
+
+	mu    sync.Mutex
+	cache map[inspectKey]inspectResult
+}
+
+// inspectKey identifies an inspection, the metadata fetched with a credential is not served to the others
+type inspectKey struct {
+	imageRef string
+	// credential is the image pull secret and server, or else the server and username, of the credential
+	credential string
+}
+
+type inspectResult struct {
+	metadata *ImageMetadata
+	err      error
+}
+
+type InspectorOption func(*Inspector)
+
+// WithPlatform sets the platform to inspect for multi-platform images, default linux/amd64
+func WithPlatform(platform v1.Platform) InspectorOption {
+	return func(i *Inspector) {
+		i.platform = platform
+	}
+}
+
+func WithTransport(transport http.RoundTripper) InspectorOption {
+	return func(i *Inspector) {
+		i.transport = transport
+	}
+}
+
+// WithInsecure allows plain http registries
+func WithInsecure(insecure bool) InspectorOption {
+	return func(i *Inspector) {
+		i.insecure = insecure
+	}
+}
+
+// WithInspectTimeout sets the timeout of a single image inspection, default 30s
+func WithInspectTimeout(timeout time.Duration) InspectorOption {
+	return func(i *Inspector) {
+		i.timeout = timeout
+	}
+}
+
+// NewInspector instansiate a new registry inspector
+func NewInspector(opts ...InspectorOption) *Inspector {
+	i := &Inspector{
+		platform:  v1.Platform{OS: "linux", Architecture: "amd64"},
+		transport: remote.DefaultTransport,
+		timeout:   defaultInspectTimeout,
+		cache:     make(map[inspectKey]inspectResult),
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// Inspect returns the metadata of an image, using the given credentials when not nil
+func (i *Inspector) Inspect(ctx context.Context, imageRef string, auth *docker.Auth) (*ImageMetadata, error) {
+	var credential string
+	if auth != nil {
+		credential = "username:" + auth.Username
+	}
+	return i.inspectCached(ctx, inspectKey{imageRef: imageRef, credential: credential}, auth)
+}
+
+// inspectCached returns the cached inspection of the key, failures included. Failures caused by the
+// cancellation of the caller context are not cached.
+func (i *Inspector) inspectCached(ctx context.Context, key inspectKey, auth *docker.Auth) (*ImageMetadata, error) {
+	i.mu.Lock()
+	result, ok := i.cache[key]
+	i.mu.Unlock()
+	if ok {
+		return result.metadata, result.err
+	}
+
+	metadata, err := i.inspect(ctx, key.imageRef, auth)
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	}
+
+	i.mu.Lock()
+	i.cache[key] = inspectResult{metadata: metadata, err: err}
+	i.mu.Unlock()
+	return metadata, err
+}
+
+// InspectImages returns the metadata of the images keyed by image reference, credentials are matched
+// from the registry server auths and tried in the kubelet order. Images which cannot be inspected are skipped.
+func (i *Inspector) InspectImages(ctx context.Context, images []string, registryCredentials k8s.RegistryCredentials) map[string]ImageMetadata {
+	result := make(map[string]ImageMetadata)
+	for _, im := range images {
+		creds, err := k8s.MatchCredentials(im, registryCredentials)
+		if err != nil {
+			slog.Warn("Unable to parse image reference, skipping", "image", im, "error", err)
+			continue
+		}
+		metadata, err := i.inspectWithCredentials(ctx, im, creds)
+		if err != nil {
+			slog.Warn("Unable to inspect image", "image", im, "error", err)
+			continue
+		}
+		result[im] = *metadata
+	}
+	return result
+}
+
+// inspectWithCredentials tries the credentials in order until one is accepted, anonymously when there are none
+func (i *Inspector) inspectWithCredentials(ctx context.Context, imageRef string, creds []k8s.Credential) (*ImageMetadata, error) {
+	if len(creds) == 0 {
+		return i.inspectCached(ctx, inspectKey{imageRef: imageRef}, nil)
+	}
+	var errs []error
+	for _, cred := range creds {
+		metadata, err := i.inspectCached(ctx, inspectKey{imageRef: imageRef, credential: credentialIdentity(cred)}, &cred.Auth)
+		if err == nil {
+			return metadata, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// credentialIdentity identifies a credential without its secret: the image pull secret and server
+// it comes from, or else its server and username
+func credentialIdentity(cred k8s.Credential) string {
+	if cred.SecretName != "" {
+		return "secret:" + cred.Namespace + "/" + cred.SecretName + "|" + cred.Server
+	}
+	return "server:" + cred.Server + "|" + cred.Username
+}
+
+func (i *Inspector) inspect(ctx context.Context, imageRef string, auth *docker.Auth) (*ImageMetadata, error) {
+	if i.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.timeout)
+		defer cancel()
+	}
+
+	var nameOpts []name.Option
+	if i.insecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	ref, err := name.ParseReference(imageRef, nameOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("parsing image reference %q: %w", imageRef, err)
+	}
+
+	authenticator := authn.Anonymous
+	if auth != nil && (auth.Username != "" || auth.Password != "") {
+		authenticator = authn.FromConfig(authn.AuthConfig{Username: auth.Username, Password: auth.Password})
+	}
+	desc, err := remote.Get(ref,
+		remote.WithContext(ctx),
+		remote.WithAuth(authenticator),
+		remote.WithTransport(i.transport),
+		remote.WithPlatform(i.platform),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("fetching manifest of %q: %w", imageRef, err)
+	}
+
+	metadata := &ImageMetadata{
+		Reference: imageRef,
+		Digest:    desc.Digest.String(),
+		MediaType: string(desc.MediaType),
+	}
+	if desc.MediaType.IsIndex() {
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range manifest.Manifests {
+			if m.Platform == nil {
+				continue
+			}
+			metadata.Platforms = append(metadata.Platforms, Platform{
+				OS:           m.Platform.OS,
+				Architecture: m.Platform.Architecture,
+				Variant:      m.Platform.Variant,
+				Digest:       m.Digest.String(),
+			})
+		}
+	}
+
+	// for an index, Image() picks the manifest matching the inspector platform
+	img, err := desc.Image()
+	if err != nil {
+		return nil, fmt.Errorf("resolving image of %q: %w", imageRef, err)
+	}
+	configDigest, err := img.ConfigName()
+	if err != nil {
+		return nil, err
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("fetching config of %q: %w", imageRef, err)
+	}
+	metadata.ConfigDigest = configDigest.String()
+	metadata.OS = config.OS
+	metadata.Architecture = config.Architecture
+	metadata.Variant = config.Variant
+	metadata.Labels = config.Config.Labels
+	metadata.Created = config.Created.Time
+	return metadata, nil
+}
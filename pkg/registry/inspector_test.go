@@ -0,0 +1,259 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+)
+
+func newTestImage(t *testing.T, platform v1.Platform, labels map[string]string) v1.Image {
+	img, err := random.Image(64, 1)
+	require.NoError(t, err)
+	cfg, err := img.ConfigFile()
+	require.NoError(t, err)
+	cfg = cfg.DeepCopy()
+	cfg.OS = platform.OS
+	cfg.Architecture = platform.Architecture
+	cfg.Variant = platform.Variant
+	cfg.Config.Labels = labels
+	cfg.Created = v1.Time{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	img, err = mutate.ConfigFile(img, cfg)
+	require.NoError(t, err)
+	return img
+}
+
+func newRegistry() http.Handler {
+	return ggcrregistry.New(ggcrregistry.Logger(log.New(io.Discard, "", 0)))
+}
+
+// basicAuth wraps a registry handler requiring the given credentials
+func basicAuth(handler http.Handler, username, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != username || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func TestInspect(t *testing.T) {
+	server := httptest.NewServer(basicAuth(newRegistry(), "user", "pass"))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	writeAuth := remote.WithAuth(&authn.Basic{Username: "user", Password: "pass"})
+
+	amd64 := newTestImage(t, v1.Platform{OS: "linux", Architecture: "amd64"}, map[string]string{"org.opencontainers.image.version": "1.0"})
+	arm64 := newTestImage(t, v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, nil)
+
+	single, err := name.ParseReference(host + "/app:single")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(single, amd64, writeAuth))
+
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}}},
+	)
+	multi, err := name.ParseReference(host + "/app:multi")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(multi, index, writeAuth))
+
+	amd64Digest, err := amd64.Digest()
+	require.NoError(t, err)
+	amd64Config, err := amd64.ConfigName()
+	require.NoError(t, err)
+	arm64Digest, err := arm64.Digest()
+	require.NoError(t, err)
+	arm64Config, err := arm64.ConfigName()
+	require.NoError(t, err)
+	indexDigest, err := index.Digest()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		imageRef string
+		auth     *docker.Auth
+		opts     []InspectorOption
+		want     func(*ImageMetadata)
+		wantErr  bool
+	}{
+		{
+			name:     "single platform image",
+			imageRef: single.String(),
+			auth:     &docker.Auth{Username: "user", Password: "pass"},
+			want: func(m *ImageMetadata) {
+				assert.Equal(t, amd64Digest.String(), m.Digest)
+				assert.Equal(t, amd64Config.String(), m.ConfigDigest)
+				assert.Empty(t, m.Platforms)
+				assert.Equal(t, "linux", m.OS)
+				assert.Equal(t, "amd64", m.Architecture)
+				assert.Equal(t, map[string]string{"org.opencontainers.image.version": "1.0"}, m.Labels)
+				assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), m.Created.UTC())
+			},
+		},
+		{
+			name:     "image index resolved by platform",
+			imageRef: multi.String(),
+			auth:     &docker.Auth{Username: "user", Password: "pass"},
+			opts:     []InspectorOption{WithPlatform(v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"})},
+			want: func(m *ImageMetadata) {
+				assert.Equal(t, indexDigest.String(), m.Digest)
+				assert.Equal(t, arm64Config.String(), m.ConfigDigest)
+				assert.Equal(t, []Platform{
+					{OS: "linux", Architecture: "amd64", Digest: amd64Digest.String()},
+					{OS: "linux", Architecture: "arm64", Variant: "v8", Digest: arm64Digest.String()},
+				}, m.Platforms)
+				assert.Equal(t, "arm64", m.Architecture)
+				assert.Equal(t, "v8", m.Variant)
+			},
+		},
+		{
+			name:     "rejected credentials",
+			imageRef: single.String(),
+			auth:     &docker.Auth{Username: "user", Password: "wrong"},
+			wantErr:  true,
+		},
+		{
+			name:     "anonymous",
+			imageRef: single.String(),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewInspector(tt.opts...).Inspect(context.Background(), tt.imageRef, tt.auth)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.imageRef, got.Reference)
+			tt.want(got)
+		})
+	}
+}
+
+func TestInspectImagesCache(t *testing.T) {
+	var manifestRequests, missingRequests int
+	reg := newRegistry()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/app/manifests/") && r.Method == http.MethodGet {
+			manifestRequests++
+		}
+		if strings.HasPrefix(r.URL.Path, "/v2/missing/manifests/") {
+			missingRequests++
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	ref, err := name.ParseReference(host + "/app:1.0")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, newTestImage(t, v1.Platform{OS: "linux", Architecture: "amd64"}, nil)))
+
+	inspector := NewInspector()
+	images := []string{ref.String(), ref.String(), host + "/missing:1.0"}
+	got := inspector.InspectImages(context.Background(), images, k8s.RegistryCredentials{})
+	assert.Len(t, got, 1)
+	assert.Contains(t, got, ref.String())
+
+	got = inspector.InspectImages(context.Background(), images, k8s.RegistryCredentials{})
+	assert.Len(t, got, 1)
+	assert.Equal(t, 1, manifestRequests, fmt.Sprintf("expected a single manifest request, got %d", manifestRequests))
+	// failures are cached for the scan too
+	assert.Equal(t, 1, missingRequests, fmt.Sprintf("expected a single request of the missing image, got %d", missingRequests))
+}
+
+func TestInspectImagesCredentialCache(t *testing.T) {
+	server := httptest.NewServer(basicAuth(newRegistry(), "user", "pass"))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	ref, err := name.ParseReference(host + "/app:1.0")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, newTestImage(t, v1.Platform{OS: "linux", Architecture: "amd64"}, nil),
+		remote.WithAuth(&authn.Basic{Username: "user", Password: "pass"})))
+
+	secretCreds := func(secret, username, password string) k8s.RegistryCredentials {
+		return k8s.RegistryCredentials{host: {{
+			Auth:          docker.Auth{Username: username, Password: password},
+			CredentialRef: k8s.CredentialRef{Source: k8s.CredentialSourcePodSpec, Namespace: "default", SecretName: secret, Server: host},
+		}}}
+	}
+	inspector := NewInspector()
+	got := inspector.InspectImages(context.Background(), []string{ref.String()}, secretCreds("valid", "user", "pass"))
+	require.Contains(t, got, ref.String())
+
+	// the metadata fetched with the valid secret is not served to another secret or to anonymous pulls
+	got = inspector.InspectImages(context.Background(), []string{ref.String()}, secretCreds("expired", "user", "expired"))
+	assert.Empty(t, got)
+	got = inspector.InspectImages(context.Background(), []string{ref.String()}, k8s.RegistryCredentials{})
+	assert.Empty(t, got)
+}
+
+func TestInspectTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	start := time.Now()
+	_, err := NewInspector(WithInspectTimeout(100*time.Millisecond)).Inspect(context.Background(), host+"/app:1.0", nil)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestInspectImagesCredentialFallback(t *testing.T) {
+	var usernames []string
+	reg := basicAuth(newRegistry(), "user", "pass")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, _, ok := r.BasicAuth(); ok && strings.HasPrefix(r.URL.Path, "/v2/app/manifests/") && r.Method == http.MethodGet {
+			usernames = append(usernames, u)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	ref, err := name.ParseReference(host + "/app:1.0")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, newTestImage(t, v1.Platform{OS: "linux", Architecture: "amd64"}, nil),
+		remote.WithAuth(&authn.Basic{Username: "user", Password: "pass"})))
+
+	// the repository key is more specific and tried first, the registry key is used once it is rejected
+	got := NewInspector().InspectImages(context.Background(), []string{ref.String()}, k8s.CredentialsFromAuths(map[string]docker.Auth{
+		host + "/app": {Username: "expired", Password: "expired"},
+		host:          {Username: "user", Password: "pass"},
+	}))
+	require.Contains(t, got, ref.String())
+	assert.Equal(t, "linux", got[ref.String()].OS)
+	assert.Equal(t, "expired", usernames[0])
+	assert.Equal(t, "user", usernames[len(usernames)-1])
+}
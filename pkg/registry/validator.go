@@ -0,0 +1,188 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+)
+
+// CredentialStatus is the outcome of a registry credentials check
+type CredentialStatus string
+
+const (
+	// CredentialsValid the registry accepted the credentials
+	CredentialsValid CredentialStatus = "Valid"
+	// CredentialsRejected the registry (or its token service) rejected the credentials
+	CredentialsRejected CredentialStatus = "Rejected"
+	// RegistryUnreachable the registry could not be reached or answered with an unexpected status
+	RegistryUnreachable CredentialStatus = "Unreachable"
+)
+
+const defaultValidationTimeout = 30 * time.Second
+
+// ValidationResult holds the credentials check of a registry server
+type ValidationResult struct {
+	// Namespace and Secret are empty when the auths do not come from a secret
+	Namespace string
+	Secret    string
+	Server    string
+	Status    CredentialStatus
+	Message   string
+}
+
+// Validator checks registry credentials with a /v2/ handshake,
+// including the token exchange of registries using bearer authentication
+type Validator struct {
+	transport http.RoundTripper
+	insecure  bool
+	timeout   time.Duration
+}
+
+type ValidatorOption func(*Validator)
+
+func WithValidatorTransport(transport http.RoundTripper) ValidatorOption {
+	return func(v *Validator) {
+		v.transport = transport
+	}
+}
+
+// WithValidatorInsecure allows plain http registries
+func WithValidatorInsecure(insecure bool) ValidatorOption {
+	return func(v *Validator) {
+		v.insecure = insecure
+	}
+}
+
+// WithValidationTimeout sets the timeout of a single registry check, default 30s
+func WithValidationTimeout(timeout time.Duration) ValidatorOption {
+	return func(v *Validator) {
+		v.timeout = timeout
+	}
+}
+
+// NewValidator instansiate a new registry credentials validator
+func NewValidator(opts ...ValidatorOption) *Validator {
+	v := &Validator{
+		transport: remote.DefaultTransport,
+		timeout:   defaultValidationTimeout,
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// ValidateSecrets checks the credentials of every registry server of the image pull secrets
+func (v *Validator) ValidateSecrets(ctx context.Context, secrets []*corev1.Secret) ([]ValidationResult, error) {
+	results := make([]ValidationResult, 0)
+	for _, secret := range secrets {
+		auths, err := k8s.MapSecretDockerRegistryServersToAuths(secret)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range v.ValidateAuths(ctx, auths) {
+			result.Namespace = secret.Namespace
+			result.Secret = secret.Name
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// ValidateAuths checks the credentials of every registry server, results are sorted by server
+func (v *Validator) ValidateAuths(ctx context.Context, auths map[string]docker.Auth) []ValidationResult {
+	servers := make([]string, 0, len(auths))
+	for server := range auths {
+		servers = append(servers, server)
+	}
+	slices.Sort(servers)
+	results := make([]ValidationResult, 0, len(servers))
+	for _, server := range servers {
+		results = append(results, v.Validate(ctx, server, auths[server]))
+	}
+	return results
+}
+
+// Validate checks the credentials of a single registry server, the path of registry keys
+// such as `registry.example.com/team-a` is ignored
+func (v *Validator) Validate(ctx context.Context, server string, auth docker.Auth) ValidationResult {
+	result := ValidationResult{Server: server}
+	if v.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.timeout)
+		defer cancel()
+	}
+
+	var nameOpts []name.Option
+	if v.insecure {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	host, err := docker.GetServerFromDockerAuthKey(server)
+	if err != nil {
+		result.Status = RegistryUnreachable
+		result.Message = fmt.Sprintf("invalid registry server: %v", err)
+		return result
+	}
+	reg, err := name.NewRegistry(host, nameOpts...)
+	if err != nil {
+		result.Status = RegistryUnreachable
+		result.Message = fmt.Sprintf("invalid registry server: %v", err)
+		return result
+	}
+
+	authenticator := authn.FromConfig(authn.AuthConfig{Username: auth.Username, Password: auth.Password})
+	// pings /v2/ and exchanges the credentials for a token when the registry asks for bearer authentication
+	rt, err := transport.NewWithContext(ctx, reg, authenticator, v.transport, []string{})
+	if err != nil {
+		return v.resultFromError(result, err)
+	}
+
+	// basic authentication is not verified by the handshake, check it against /v2/
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/v2/", reg.Scheme(), reg.RegistryStr()), nil)
+	if err != nil {
+		result.Status = RegistryUnreachable
+		result.Message = err.Error()
+		return result
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return v.resultFromError(result, err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		result.Status = CredentialsValid
+	case http.StatusUnauthorized, http.StatusForbidden:
+		result.Status = CredentialsRejected
+		result.Message = fmt.Sprintf("registry returned %s", resp.Status)
+	default:
+		result.Status = RegistryUnreachable
+		result.Message = fmt.Sprintf("registry returned %s", resp.Status)
+	}
+	return result
+}
+
+func (v *Validator) resultFromError(result ValidationResult, err error) ValidationResult {
+	var terr *transport.Error
+	if errors.As(err, &terr) && (terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden) {
+		result.Status = CredentialsRejected
+	} else {
+		result.Status = RegistryUnreachable
+	}
+	result.Message = err.Error()
+	return result
+}
@@ -0,0 +1,125 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
+)
+
+// tokenRegistry is a registry stand-in asking for bearer authentication,
+// tokens are issued by its /token endpoint for the given credentials
+func tokenRegistry(username, password string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			u, p, ok := r.BasicAuth()
+			if !ok || u != username || p != password {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "secret-token"})
+		case "/v2/":
+			if r.Header.Get("Authorization") == "Bearer secret-token" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func TestValidate(t *testing.T) {
+	basic := httptest.NewServer(basicAuth(newRegistry(), "user", "pass"))
+	defer basic.Close()
+	bearer := tokenRegistry("user", "pass")
+	defer bearer.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	host := func(s *httptest.Server) string {
+		return strings.TrimPrefix(s.URL, "http://")
+	}
+
+	tests := []struct {
+		name   string
+		server string
+		auth   docker.Auth
+		want   CredentialStatus
+	}{
+		{name: "basic auth valid", server: host(basic), auth: docker.Auth{Username: "user", Password: "pass"}, want: CredentialsValid},
+		{name: "basic auth rejected", server: host(basic), auth: docker.Auth{Username: "user", Password: "expired"}, want: CredentialsRejected},
+		{name: "token auth valid", server: host(bearer), auth: docker.Auth{Username: "user", Password: "pass"}, want: CredentialsValid},
+		{name: "token auth rejected", server: host(bearer), auth: docker.Auth{Username: "user", Password: "expired"}, want: CredentialsRejected},
+		{name: "unexpected status", server: host(broken), auth: docker.Auth{Username: "user", Password: "pass"}, want: RegistryUnreachable},
+		{name: "unreachable", server: host(closed), auth: docker.Auth{Username: "user", Password: "pass"}, want: RegistryUnreachable},
+	}
+	validator := NewValidator()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validator.Validate(context.Background(), tt.server, tt.auth)
+			assert.Equal(t, tt.server, got.Server)
+			assert.Equal(t, tt.want, got.Status, got.Message)
+		})
+	}
+}
+
+func TestValidateSecrets(t *testing.T) {
+	basic := httptest.NewServer(basicAuth(newRegistry(), "user", "pass"))
+	defer basic.Close()
+	bearer := tokenRegistry("robot", "token")
+	defer bearer.Close()
+	basicHost := strings.TrimPrefix(basic.URL, "http://")
+	bearerHost := strings.TrimPrefix(bearer.URL, "http://")
+
+	dockerConfig, err := docker.Config{Auths: map[string]docker.Auth{
+		basicHost:  {Auth: docker.NewBasicAuth("user", "pass")},
+		bearerHost: {Auth: docker.NewBasicAuth("robot", "stale")},
+	}}.Write()
+	require.NoError(t, err)
+
+	secrets := []*corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "regcred", Namespace: "default"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: dockerConfig},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "default"},
+			Type:       corev1.SecretTypeOpaque,
+		},
+	}
+	got, err := NewValidator().ValidateSecrets(context.Background(), secrets)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+
+	statuses := map[string]CredentialStatus{}
+	for _, r := range got {
+		assert.Equal(t, "default", r.Namespace)
+		assert.Equal(t, "regcred", r.Secret)
+		statuses[r.Server] = r.Status
+	}
+	assert.Equal(t, map[string]CredentialStatus{
+		basicHost:  CredentialsValid,
+		bearerHost: CredentialsRejected,
+	}, statuses)
+}
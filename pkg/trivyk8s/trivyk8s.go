@@ -4,18 +4,22 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aquasecurity/trivy-kubernetes/pkg/artifacts"
 	"github.com/aquasecurity/trivy-kubernetes/pkg/bom"
 	"github.com/aquasecurity/trivy-kubernetes/pkg/jobs"
 	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/nodeinfo"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/registry"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	k8sapierror "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -59,17 +63,66 @@ type client struct {
 	includeNamespaces    []string
 	commandPaths         []string
 	specCommandIds       []string
+	specLoader           jobs.SpecLoader
 	commandFilesystem    embed.FS
 	nodeConfigFilesystem embed.FS
+	runningImageIDs      bool
+	imageInspector       *registry.Inspector
+	credentialMode       CredentialMode
+	credentialsCache     bool
 }
 
 type K8sOption func(*client)
 
+// CredentialMode defines how the registry credentials of the workloads are attached to the artifacts
+type CredentialMode string
+
+const (
+	// CredentialModeInline attach the decoded credentials, the default
+	CredentialModeInline CredentialMode = "inline"
+	// CredentialModeReferences attach only the references of the image pull secrets, built without reading
+	// the secrets, callers resolve them with the cluster k8s.CredentialResolver
+	CredentialModeReferences CredentialMode = "references"
+	// CredentialModeNone never read Secrets nor ServiceAccounts, for low privilege identities
+	CredentialModeNone CredentialMode = "none"
+)
+
 func WithExcludeOwned(excludeOwned bool) K8sOption {
 	return func(c *client) {
 		c.excludeOwned = excludeOwned
 	}
 }
+
+// WithRunningImageIDs attach the image digests running in the workload pods to the artifacts
+func WithRunningImageIDs(runningImageIDs bool) K8sOption {
+	return func(c *client) {
+		c.runningImageIDs = runningImageIDs
+	}
+}
+
+// WithImageInspector fetch images metadata from registries using the discovered credentials
+// and attach it to the artifacts
+func WithImageInspector(inspector *registry.Inspector) K8sOption {
+	return func(c *client) {
+		c.imageInspector = inspector
+	}
+}
+
+// WithCredentialMode set how registry credentials are attached to the artifacts, default CredentialModeInline
+func WithCredentialMode(mode CredentialMode) K8sOption {
+	return func(c *client) {
+		c.credentialMode = mode
+	}
+}
+
+// WithCredentialsCache resolve the credentials of a scan from a single listing of the service accounts
+// and secrets of each namespace, enabled by default
+func WithCredentialsCache(enabled bool) K8sOption {
+	return func(c *client) {
+		c.credentialsCache = enabled
+	}
+}
+
 func WithExcludeKinds(excludeKinds []string) K8sOption {
 	return func(c *client) {
 		for _, kind := range excludeKinds {
@@ -103,7 +156,8 @@ func WithIncludeNamespaces(includeNamespaces []string) K8sOption {
 // New creates a trivyK8S client
 func New(cluster k8s.Cluster, opts ...K8sOption) TrivyK8S {
 	c := &client{
-		cluster: cluster,
+		cluster:          cluster,
+		credentialsCache: true,
 	}
 	for _, opt := range opts {
 		opt(c)
@@ -206,7 +260,7 @@ func (c *client) getNamespaces() ([]string, error) {
 	dClient := c.getDynamicClient(namespaceGVR)
 	namespaces, err := dClient.List(context.TODO(), v1.ListOptions{})
 	if err != nil {
-		if errors.IsForbidden(err) {
+		if k8sapierror.IsForbidden(err) {
 			return result, fmt.Errorf("'exclude namespaces' option requires a cluster role with permissions to list namespaces")
 		}
 		return result, fmt.Errorf("unable to list namespaces: %w", err)
@@ -252,6 +306,19 @@ func (c *client) ListSpecificArtifacts(ctx context.Context) ([]*artifacts.Artifa
 	if err != nil {
 		return nil, err
 	}
+	var imagesResolver *k8s.RunningImagesResolver
+	if c.runningImageIDs {
+		imagesResolver = k8s.NewRunningImagesResolver(c.cluster.GetK8sClientSet())
+	}
+	var credentials credentialsLister = c.cluster
+	if c.credentialsCache && c.credentialMode != CredentialModeNone {
+		cache := c.cluster.NewCredentialsCache()
+		defer func() {
+			stats := cache.Stats()
+			slog.Debug("Credentials cache", "hits", stats.Hits, "misses", stats.Misses, "lists", stats.Lists)
+		}()
+		credentials = cache
+	}
 
 	for _, gvr := range grvs {
 		dclient := c.getDynamicClient(gvr)
@@ -260,7 +327,7 @@ func (c *client) ListSpecificArtifacts(ctx context.Context) ([]*artifacts.Artifa
 		if err != nil {
 			lerr := fmt.Errorf("failed listing resources for gvr: %v - %w", gvr, err)
 
-			if errors.IsNotFound(err) || errors.IsForbidden(err) {
+			if k8sapierror.IsNotFound(err) || k8sapierror.IsForbidden(err) {
 				slog.Error("Unable to list resources", "error", lerr)
 				continue
 			}
@@ -278,13 +345,20 @@ func (c *client) ListSpecificArtifacts(ctx context.Context) ([]*artifacts.Artifa
 				continue
 			}
 
-			auths, err := c.cluster.AuthByResource(resource)
+			artifact, creds, err := c.artifactWithCredentials(ctx, credentials, resource)
 			if err != nil {
 				return nil, fmt.Errorf("failed getting auth for gvr: %v - %w", gvr, err)
 			}
-			artifact, err := artifacts.FromResource(resource, auths)
-			if err != nil {
-				return nil, err
+			if c.imageInspector != nil && len(artifact.Images) > 0 {
+				artifact.ImagesMetadata = c.imageInspector.InspectImages(ctx, artifact.Images, creds())
+			}
+			if imagesResolver != nil {
+				imageIDs, err := imagesResolver.ImageIDsByResource(ctx, resource)
+				if err != nil {
+					slog.Warn("Unable to resolve running image digests", "kind", resource.GetKind(), "name", resource.GetName(), "error", err)
+				} else {
+					artifact.RunningImageIDs = imageIDs
+				}
 			}
 
 			artifactList = append(artifactList, artifact)
@@ -333,8 +407,26 @@ type scanJobParams struct {
 	ignoreLabels     map[string]string
 	scanJobNamespace string
 	imageRef         string
+	concurrency      int
+	deadline         time.Duration
+	strict           bool
+	mode             NodeCollectorMode
+	serviceAccount   string
 }
 
+// NodeCollectorMode defines how the node-collector is deployed on the nodes
+type NodeCollectorMode string
+
+const (
+	// NodeCollectorModeJob run a job per node, the default
+	NodeCollectorModeJob NodeCollectorMode = "job"
+	// NodeCollectorModeDaemonSet run a single DaemonSet on all the nodes
+	NodeCollectorModeDaemonSet NodeCollectorMode = "daemonset"
+)
+
+// defaultNodeCollectorConcurrency is the number of node-collector jobs running at once
+const defaultNodeCollectorConcurrency = 5
+
 type NodeCollectorOption func(*client)
 
 func WithAffinity(affinity *corev1.Affinity) NodeCollectorOption {
@@ -367,6 +459,43 @@ func WithScanJobImageRef(imageRef string) NodeCollectorOption {
 	}
 }
 
+// WithNodeCollectorConcurrency set the number of nodes collected at once, 5 by default
+func WithNodeCollectorConcurrency(concurrency int) NodeCollectorOption {
+	return func(c *client) {
+		c.scanJobParams.concurrency = concurrency
+	}
+}
+
+// WithNodeCollectorDeadline set the deadline of the collection of all the nodes, no deadline when zero
+func WithNodeCollectorDeadline(deadline time.Duration) NodeCollectorOption {
+	return func(c *client) {
+		c.scanJobParams.deadline = deadline
+	}
+}
+
+// WithNodeCollectorStrict fail on the first node whose node-collector fails, otherwise the failures are
+// set on the NodeInfoError of the Node artifacts and the node info of the other nodes is returned
+func WithNodeCollectorStrict(strict bool) NodeCollectorOption {
+	return func(c *client) {
+		c.scanJobParams.strict = strict
+	}
+}
+
+// WithNodeCollectorMode set how the node-collector is deployed, a job per node by default
+func WithNodeCollectorMode(mode NodeCollectorMode) NodeCollectorOption {
+	return func(c *client) {
+		c.scanJobParams.mode = mode
+	}
+}
+
+// WithNodeCollectorServiceAccount set the service account of the node-collector, in DaemonSet mode it
+// must be allowed to get nodes/proxy to load the kubelet config
+func WithNodeCollectorServiceAccount(serviceAccount string) NodeCollectorOption {
+	return func(c *client) {
+		c.scanJobParams.serviceAccount = serviceAccount
+	}
+}
+
 func WithNodeConfig(nodeConfig bool) NodeCollectorOption {
 	return func(c *client) {
 		c.nodeConfig = nodeConfig
@@ -395,6 +524,14 @@ func WithSpecCommandIds(specCommandIds []string) NodeCollectorOption {
 	}
 }
 
+// WithComplianceSpecLoader select the node commands of the compliance spec resolved from the platform mapping
+// of the commands bundle, such as the trivy-checks compliance loader
+func WithComplianceSpecLoader(loader jobs.SpecLoader) NodeCollectorOption {
+	return func(c *client) {
+		c.specLoader = loader
+	}
+}
+
 // ListArtifacts returns kubernetes scannable artifacs.
 func (c *client) ListArtifactAndNodeInfo(ctx context.Context,
 	opts ...NodeCollectorOption) ([]*artifacts.Artifact, error) {
@@ -410,25 +547,24 @@ func (c *client) ListArtifactAndNodeInfo(ctx context.Context,
 		jobs.TrivyAutoCreated:   "true",
 	}
 
-	jc := jobs.NewCollector(
-		c.cluster,
-		jobs.WithTimetout(time.Minute*5),
+	collectorOpts := []jobs.CollectorOption{
+		jobs.WithTimetout(time.Minute * 5),
 		jobs.WithJobTemplateName(jobs.NodeCollectorName),
 		jobs.WithJobNamespace(c.scanJobParams.scanJobNamespace),
 		jobs.WithJobLabels(labels),
 		jobs.WithImageRef(c.scanJobParams.imageRef),
 		jobs.WithJobAffinity(c.scanJobParams.affinity),
 		jobs.WithJobTolerations(c.scanJobParams.tolerations),
+		jobs.WithServiceAccount(c.scanJobParams.serviceAccount),
 		jobs.WithNodeConfig(c.nodeConfig),
 		jobs.WithCommandsPath(c.commandPaths),
 		jobs.WithSpecCommands(c.specCommandIds),
+		jobs.WithComplianceSpecLoader(c.specLoader),
 		jobs.WithEmbeddedCommandFileSystem(c.commandFilesystem),
 		jobs.WithEmbeddedNodeConfigFilesystem(c.nodeConfigFilesystem),
-	)
-	// delete trivy namespace
-	defer jc.Cleanup(ctx)
+	}
 
-	// collect node info
+	nodes := make([]*artifacts.Artifact, 0)
 	for _, resource := range artifactList {
 		if resource.Kind != "Node" {
 			continue
@@ -436,29 +572,234 @@ func (c *client) ListArtifactAndNodeInfo(ctx context.Context,
 		if ignoreNodeByLabel(resource, c.scanJobParams.ignoreLabels) {
 			continue
 		}
+		nodes = append(nodes, resource)
+	}
+	if c.scanJobParams.mode == NodeCollectorModeDaemonSet {
+		dc := jobs.NewDaemonSetCollector(c.cluster, collectorOpts...)
+		// delete trivy namespace
+		defer dc.Cleanup(ctx)
+		nodesInfo, err := c.collectNodesInfoDaemonSet(ctx, dc, nodes)
+		if err != nil {
+			return nil, err
+		}
+		return append(artifactList, nodesInfo...), nil
+	}
+
+	jc := jobs.NewCollector(c.cluster, collectorOpts...)
+	// delete trivy namespace
+	defer jc.Cleanup(ctx)
+
+	nodesInfo, err := c.collectNodesInfo(ctx, jc, nodes)
+	if err != nil {
+		return nil, err
+	}
+	return append(artifactList, nodesInfo...), nil
+}
+
+// collectNodesInfo runs the node-collector jobs of the nodes concurrently, the node info artifacts are in
+// the order of the nodes. In strict mode the first failure cancels the jobs of the other nodes, otherwise
+// failed nodes, including the nodes not collected before the deadline, are skipped and their error is set
+// on the node artifact. The error of ctx is returned when the caller cancels the collection.
+func (c *client) collectNodesInfo(ctx context.Context, jc jobs.Collector, nodes []*artifacts.Artifact) ([]*artifacts.Artifact, error) {
+	concurrency := c.scanJobParams.concurrency
+	if concurrency <= 0 {
+		concurrency = defaultNodeCollectorConcurrency
+	}
+	parent := ctx
+	var cancel context.CancelFunc
+	if c.scanJobParams.deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.scanJobParams.deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	nodesInfo := make([]*artifacts.Artifact, len(nodes))
+	nodeErrs := make([]error, len(nodes))
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node *artifacts.Artifact) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				nodeErrs[i] = &jobs.NodeCollectorError{Node: node.Name, Reason: jobs.NodeFailureTimeout, Err: ctx.Err()}
+				return
+			}
+			defer func() { <-sem }()
+
+			nodeInfo, err := collectNodeInfo(ctx, jc, node)
+			if err != nil {
+				nodeErrs[i] = err
+				if c.scanJobParams.strict {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+				return
+			}
+			nodesInfo[i] = nodeInfo
+		}(i, node)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	// an aborted scan is not a partial success
+	if err := parent.Err(); err != nil {
+		return nil, fmt.Errorf("collecting nodes info: %w", err)
+	}
+	if c.scanJobParams.strict {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("collecting nodes info: %w", err)
+		}
+	}
+
+	return nodeInfoArtifacts(nodes, nodesInfo, nodeErrs), nil
+}
+
+// collectNodesInfoDaemonSet collects the node info of the nodes with a single DaemonSet, failures are
+// handled as in collectNodesInfo
+func (c *client) collectNodesInfoDaemonSet(ctx context.Context, dc jobs.DaemonSetCollector, nodes []*artifacts.Artifact) ([]*artifacts.Artifact, error) {
+	parent := ctx
+	if c.scanJobParams.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.scanJobParams.deadline)
+		defer cancel()
+	}
+	nodeNames := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+	}
+	outputs, err := dc.CollectNodes(ctx, nodeNames)
+	if err != nil {
+		return nil, fmt.Errorf("collecting nodes info: %w", err)
+	}
+	if err := parent.Err(); err != nil {
+		return nil, fmt.Errorf("collecting nodes info: %w", err)
+	}
+
+	nodesInfo := make([]*artifacts.Artifact, len(nodes))
+	nodeErrs := make([]error, len(nodes))
+	for i, output := range outputs {
+		if output.Err != nil {
+			nodeErrs[i] = output.Err
+		} else {
+			nodesInfo[i], nodeErrs[i] = nodeInfoArtifact(nodes[i], output.Output)
+		}
+		if nodeErrs[i] != nil && c.scanJobParams.strict {
+			return nil, nodeErrs[i]
+		}
+	}
+	return nodeInfoArtifacts(nodes, nodesInfo, nodeErrs), nil
+}
+
+// nodeInfoArtifacts returns the node info of the collected nodes, the error of the other nodes is set
+// on their artifact
+func nodeInfoArtifacts(nodes []*artifacts.Artifact, nodesInfo []*artifacts.Artifact, nodeErrs []error) []*artifacts.Artifact {
+	collected := make([]*artifacts.Artifact, 0, len(nodes))
+	for i, node := range nodes {
+		if nodeErrs[i] == nil {
+			collected = append(collected, nodesInfo[i])
+			continue
+		}
+		nodeErr, ok := jobs.AsNodeCollectorError(nodeErrs[i])
+		if !ok {
+			reason := jobs.NodeFailureJobFailed
+			if errors.Is(nodeErrs[i], context.DeadlineExceeded) {
+				reason = jobs.NodeFailureTimeout
+			}
+			nodeErr = &jobs.NodeCollectorError{Node: node.Name, Reason: reason, Err: nodeErrs[i]}
+		}
+		node.NodeInfoError = &artifacts.NodeInfoError{
+			Node:     nodeErr.Node,
+			Reason:   string(nodeErr.Reason),
+			JobName:  nodeErr.JobName,
+			PodPhase: string(nodeErr.PodPhase),
+			Logs:     nodeErr.Logs,
+			Message:  fmt.Sprint(nodeErr.Err),
+		}
+		slog.Warn("Unable to collect node info", "node", node.Name, "reason", nodeErr.Reason, "job", nodeErr.JobName, "error", nodeErr.Err)
+	}
+	return collected
+}
 
-		nodeLabels := map[string]string{
-			jobs.TrivyResourceName: resource.Name,
-			jobs.TrivyResourceKind: resource.Kind,
+func collectNodeInfo(ctx context.Context, jc jobs.Collector, node *artifacts.Artifact) (*artifacts.Artifact, error) {
+	nodeLabels := map[string]string{
+		jobs.TrivyResourceName: node.Name,
+		jobs.TrivyResourceKind: node.Kind,
+	}
+	output, err := jc.ApplyAndCollect(ctx, node.Name, jobs.WithJobLabels(nodeLabels))
+	if err != nil {
+		return nil, err
+	}
+	return nodeInfoArtifact(node, output)
+}
+
+// nodeInfoArtifact returns the NodeInfo artifact of the node-collector output of the node, an output
+// which does not fit the typed model is kept as a map with the validation error
+func nodeInfoArtifact(node *artifacts.Artifact, output string) (*artifacts.Artifact, error) {
+	var nodeInfo map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &nodeInfo); err != nil {
+		return nil, &jobs.NodeCollectorError{Node: node.Name, Reason: jobs.NodeFailureInvalidOutput, Err: err}
+	}
+	artifact := &artifacts.Artifact{
+		Kind:        "NodeInfo",
+		Name:        node.Name,
+		RawResource: nodeInfo,
+	}
+	typed, err := nodeinfo.Parse([]byte(output))
+	if err != nil {
+		slog.Warn("Node info does not fit the typed model", "node", node.Name, "error", err)
+		artifact.NodeInfoValidationError = err.Error()
+		return artifact, nil
+	}
+	artifact.NodeInfo = typed
+	return artifact, nil
+}
+
+// credentialsLister returns the registry credentials and credential references of a resource,
+// the cluster or a per scan k8s.CredentialsCache
+type credentialsLister interface {
+	CredentialsByResource(resource unstructured.Unstructured) (k8s.RegistryCredentials, error)
+	CredentialRefsByResource(resource unstructured.Unstructured) ([]k8s.CredentialRef, error)
+	CredentialResolver() k8s.CredentialResolver
+}
+
+// artifactWithCredentials creates the artifact of the resource according to the credential mode,
+// credentials are returned lazily for the registry access of the library itself
+func (c *client) artifactWithCredentials(ctx context.Context, credentials credentialsLister, resource unstructured.Unstructured) (*artifacts.Artifact, func() k8s.RegistryCredentials, error) {
+	switch c.credentialMode {
+	case CredentialModeNone:
+		artifact, err := artifacts.FromResourceWithCredentials(resource, nil)
+		return artifact, func() k8s.RegistryCredentials { return nil }, err
+	case CredentialModeReferences:
+		refs, err := credentials.CredentialRefsByResource(resource)
+		if err != nil {
+			return nil, nil, err
 		}
-		// append node labels
-		jc.AppendLabels(jobs.WithJobLabels(nodeLabels))
-		output, err := jc.ApplyAndCollect(ctx, resource.Name)
+		artifact, err := artifacts.FromResourceWithCredentialRefs(resource, refs)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		var nodeInfo map[string]interface{}
-		err = json.Unmarshal([]byte(output), &nodeInfo)
+		return artifact, func() k8s.RegistryCredentials {
+			return k8s.ResolveCredentials(ctx, credentials.CredentialResolver(), artifact.CredentialRefs)
+		}, nil
+	default:
+		creds, err := credentials.CredentialsByResource(resource)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		artifactList = append(artifactList, &artifacts.Artifact{
-			Kind:        "NodeInfo",
-			Name:        resource.Name,
-			RawResource: nodeInfo,
-		})
+		artifact, err := artifacts.FromResourceWithCredentials(resource, creds)
+		return artifact, func() k8s.RegistryCredentials { return creds }, err
 	}
-	return artifactList, err
 }
 
 // ListClusterBomInfo returns kubernetes Bom (node,core components and etc) information.
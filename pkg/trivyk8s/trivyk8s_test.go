@@ -3,6 +3,7 @@ package trivyk8s
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,10 +11,12 @@ import (
 	"path"
 	"path/filepath"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aquasecurity/trivy-kubernetes/pkg/artifacts"
+	"github.com/aquasecurity/trivy-kubernetes/pkg/jobs"
 	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s"
 	"github.com/aquasecurity/trivy-kubernetes/pkg/k8s/docker"
 	"github.com/stretchr/testify/assert"
@@ -457,3 +460,385 @@ func kubectlGetArtifacts(kind, kubeConfigPath string) []*artifacts.Artifact {
 
 	return artifactsList
 }
+
+// credentialsCluster serves the credentials of every resource and records the secret reads
+type credentialsCluster struct {
+	k8s.Cluster
+	creds       k8s.RegistryCredentials
+	secretReads int
+}
+
+func (c *credentialsCluster) CredentialsByResource(unstructured.Unstructured) (k8s.RegistryCredentials, error) {
+	c.secretReads++
+	return c.creds, nil
+}
+
+func (c *credentialsCluster) CredentialRefsByResource(unstructured.Unstructured) ([]k8s.CredentialRef, error) {
+	var refs []k8s.CredentialRef
+	for _, creds := range c.creds {
+		for _, cred := range creds {
+			ref := cred.CredentialRef
+			ref.Server = ""
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+func (c *credentialsCluster) CredentialResolver() k8s.CredentialResolver {
+	return c
+}
+
+func (c *credentialsCluster) Resolve(_ context.Context, ref k8s.CredentialRef) (docker.Auth, error) {
+	c.secretReads++
+	for _, creds := range c.creds {
+		for _, cred := range creds {
+			if cred.SecretName == ref.SecretName {
+				return cred.Auth, nil
+			}
+		}
+	}
+	return docker.Auth{}, fmt.Errorf("not found")
+}
+
+func TestCredentialMode(t *testing.T) {
+	ref := k8s.CredentialRef{Source: k8s.CredentialSourcePodSpec, Namespace: "default", SecretName: "regcred", Key: ".dockerconfigjson", Server: "index.docker.io"}
+	auth := docker.Auth{Username: "user", Password: "pass"}
+	resource := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "app", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{"name": "app", "image": "nginx:1.25"}},
+		},
+	}}
+
+	imageRef := ref
+	imageRef.Server, imageRef.Image = "", "nginx:1.25"
+
+	tests := []struct {
+		name            string
+		mode            CredentialMode
+		wantCredentials []docker.Auth
+		wantRefs        map[string][]k8s.CredentialRef
+		wantCreds       k8s.RegistryCredentials
+		wantReads       int
+	}{
+		{
+			name:            "inline",
+			mode:            CredentialModeInline,
+			wantCredentials: []docker.Auth{auth},
+			wantCreds:       k8s.RegistryCredentials{"index.docker.io": {{Auth: auth, CredentialRef: ref}}},
+			wantReads:       1,
+		},
+		{
+			// the references are built without reading the secrets, only the lazy credentials read them
+			name:            "references",
+			mode:            CredentialModeReferences,
+			wantCredentials: []docker.Auth{},
+			wantRefs:        map[string][]k8s.CredentialRef{"nginx:1.25": {imageRef}},
+			wantCreds:       k8s.RegistryCredentials{"index.docker.io/library/nginx": {{Auth: auth, CredentialRef: imageRef}}},
+			wantReads:       1,
+		},
+		{
+			name:            "none",
+			mode:            CredentialModeNone,
+			wantCredentials: []docker.Auth{},
+			wantReads:       0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := &credentialsCluster{creds: k8s.RegistryCredentials{
+				"index.docker.io": {{Auth: auth, CredentialRef: ref}},
+			}}
+			c := New(cluster, WithCredentialMode(tt.mode)).(*client)
+
+			artifact, creds, err := c.artifactWithCredentials(context.Background(), cluster, resource)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantCredentials, artifact.Credentials)
+			assert.Equal(t, tt.wantRefs, artifact.CredentialRefs)
+
+			// credentials used by the library itself are resolved lazily
+			resolved := creds()
+			if tt.wantCreds == nil {
+				assert.Empty(t, resolved)
+			} else {
+				assert.Equal(t, tt.wantCreds, resolved)
+				matched, err := k8s.MatchCredentials("nginx:1.25", resolved)
+				require.NoError(t, err)
+				assert.Len(t, matched, 1)
+			}
+			assert.Equal(t, tt.wantReads, cluster.secretReads)
+		})
+	}
+}
+
+// nodeInfoOutput returns a node-collector output whose nodeName check holds the node name
+func nodeInfoOutput(nodeName string) string {
+	return fmt.Sprintf(`{"apiVersion":"v1","kind":"NodeInfo","type":"worker","info":{"nodeName":{"values":[%q]}}}`, nodeName)
+}
+
+func TestNodeInfoArtifact(t *testing.T) {
+	node := &artifacts.Artifact{Kind: "Node", Name: "node-01"}
+	tests := []struct {
+		name              string
+		output            string
+		wantTyped         bool
+		wantValidationErr string
+		wantErr           bool
+	}{
+		{
+			name:      "typed",
+			output:    nodeInfoOutput("node-01"),
+			wantTyped: true,
+		},
+		{
+			name:              "value of another type",
+			output:            `{"apiVersion":"v1","kind":"NodeInfo","type":"worker","info":{"nodeName":{"values":[true]}}}`,
+			wantValidationErr: "invalid node info: check nodeName has a value of type bool",
+		},
+		{
+			name:              "another schema version",
+			output:            `{"apiVersion":"v2","kind":"NodeInfo","type":"worker","info":{"nodeName":{"values":["node-01"]}}}`,
+			wantValidationErr: `unsupported node info apiVersion "v2", expected "v1"`,
+		},
+		{
+			name:    "not a JSON object",
+			output:  `["node-01"]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nodeInfoArtifact(node, tt.output)
+			if tt.wantErr {
+				nodeErr, ok := jobs.AsNodeCollectorError(err)
+				require.True(t, ok)
+				assert.Equal(t, jobs.NodeFailureInvalidOutput, nodeErr.Reason)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "NodeInfo", got.Kind)
+			assert.Equal(t, tt.wantTyped, got.NodeInfo != nil)
+			assert.Equal(t, tt.wantValidationErr, got.NodeInfoValidationError)
+			// the raw map is kept either way
+			assert.NotNil(t, got.RawResource["info"])
+		})
+	}
+}
+
+// nodeCollector returns the node name as node info, it fails for the nodes of failNodes
+type nodeCollector struct {
+	jobs.Collector
+	delay     time.Duration
+	failNodes map[string]bool
+
+	mu         sync.Mutex
+	running    int
+	maxRunning int
+	collected  []string
+}
+
+func (c *nodeCollector) ApplyAndCollect(ctx context.Context, nodeName string, _ ...jobs.CollectorOption) (string, error) {
+	c.mu.Lock()
+	c.running++
+	c.maxRunning = max(c.maxRunning, c.running)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.running--
+		c.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(c.delay):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if c.failNodes[nodeName] {
+		return "", fmt.Errorf("node %s: job failed", nodeName)
+	}
+	c.mu.Lock()
+	c.collected = append(c.collected, nodeName)
+	c.mu.Unlock()
+	return nodeInfoOutput(nodeName), nil
+}
+
+func TestCollectNodesInfo(t *testing.T) {
+	newNodes := func() []*artifacts.Artifact {
+		nodes := make([]*artifacts.Artifact, 0)
+		for i := 0; i < 12; i++ {
+			nodes = append(nodes, &artifacts.Artifact{Kind: "Node", Name: fmt.Sprintf("node-%02d", i)})
+		}
+		return nodes
+	}
+
+	tests := []struct {
+		name           string
+		opts           []NodeCollectorOption
+		collector      *nodeCollector
+		cancel         time.Duration
+		wantErr        string
+		wantMaxRunning int
+		wantNodeErrs   map[jobs.NodeFailureReason]int
+	}{
+		{
+			name:           "default concurrency",
+			collector:      &nodeCollector{delay: 20 * time.Millisecond},
+			wantMaxRunning: defaultNodeCollectorConcurrency,
+		},
+		{
+			name:           "concurrency",
+			opts:           []NodeCollectorOption{WithNodeCollectorConcurrency(3)},
+			collector:      &nodeCollector{delay: 20 * time.Millisecond},
+			wantMaxRunning: 3,
+		},
+		{
+			name:           "failed nodes are reported on the node artifacts",
+			opts:           []NodeCollectorOption{WithNodeCollectorConcurrency(2)},
+			collector:      &nodeCollector{delay: 20 * time.Millisecond, failNodes: map[string]bool{"node-01": true, "node-07": true}},
+			wantMaxRunning: 2,
+			wantNodeErrs:   map[jobs.NodeFailureReason]int{jobs.NodeFailureJobFailed: 2},
+		},
+		{
+			name:           "nodes beyond the deadline are reported as timeouts",
+			opts:           []NodeCollectorOption{WithNodeCollectorConcurrency(6), WithNodeCollectorDeadline(150 * time.Millisecond)},
+			collector:      &nodeCollector{delay: 100 * time.Millisecond},
+			wantMaxRunning: 6,
+			wantNodeErrs:   map[jobs.NodeFailureReason]int{jobs.NodeFailureTimeout: 6},
+		},
+		{
+			name:      "strict first failure cancels the other nodes",
+			opts:      []NodeCollectorOption{WithNodeCollectorConcurrency(2), WithNodeCollectorStrict(true)},
+			collector: &nodeCollector{delay: 20 * time.Millisecond, failNodes: map[string]bool{"node-01": true}},
+			wantErr:   "node node-01: job failed",
+		},
+		{
+			name:      "cancelled collection",
+			opts:      []NodeCollectorOption{WithNodeCollectorConcurrency(4)},
+			collector: &nodeCollector{delay: time.Second},
+			cancel:    50 * time.Millisecond,
+			wantErr:   context.Canceled.Error(),
+		},
+		{
+			name:      "strict deadline",
+			opts:      []NodeCollectorOption{WithNodeCollectorConcurrency(4), WithNodeCollectorDeadline(50 * time.Millisecond), WithNodeCollectorStrict(true)},
+			collector: &nodeCollector{delay: time.Second},
+			wantErr:   context.DeadlineExceeded.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := newNodes()
+			c := &client{}
+			for _, opt := range tt.opts {
+				opt(c)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel > 0 {
+				time.AfterFunc(tt.cancel, cancel)
+			}
+			nodesInfo, err := c.collectNodesInfo(ctx, tt.collector, nodes)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.Less(t, len(tt.collector.collected), len(nodes))
+				return
+			}
+			require.NoError(t, err)
+
+			nodeErrs := make(map[jobs.NodeFailureReason]int)
+			var wantNames []string
+			for _, node := range nodes {
+				if node.NodeInfoError != nil {
+					assert.Equal(t, node.Name, node.NodeInfoError.Node)
+					nodeErrs[jobs.NodeFailureReason(node.NodeInfoError.Reason)]++
+					continue
+				}
+				wantNames = append(wantNames, node.Name)
+			}
+			if tt.wantNodeErrs == nil {
+				tt.wantNodeErrs = map[jobs.NodeFailureReason]int{}
+			}
+			assert.Equal(t, tt.wantNodeErrs, nodeErrs)
+
+			var names []string
+			for _, nodeInfo := range nodesInfo {
+				assert.Equal(t, "NodeInfo", nodeInfo.Kind)
+				assert.Equal(t, "NodeInfo", nodeInfo.RawResource["kind"])
+				assert.Equal(t, []string{nodeInfo.Name}, nodeInfo.NodeInfo.Info["nodeName"].Strings())
+				names = append(names, nodeInfo.Name)
+			}
+			assert.Equal(t, wantNames, names)
+			assert.Equal(t, tt.wantMaxRunning, tt.collector.maxRunning)
+		})
+	}
+}
+
+// daemonSetCollector returns the outputs of the nodes in the order they are requested
+type daemonSetCollector struct {
+	jobs.DaemonSetCollector
+	outputs map[string]jobs.NodeOutput
+}
+
+func (c *daemonSetCollector) CollectNodes(_ context.Context, nodeNames []string) ([]jobs.NodeOutput, error) {
+	outputs := make([]jobs.NodeOutput, 0, len(nodeNames))
+	for _, name := range nodeNames {
+		outputs = append(outputs, c.outputs[name])
+	}
+	return outputs, nil
+}
+
+func TestCollectNodesInfoDaemonSet(t *testing.T) {
+	collector := &daemonSetCollector{outputs: map[string]jobs.NodeOutput{
+		"node-1": {Node: "node-1", Output: nodeInfoOutput("node-1")},
+		"node-2": {Node: "node-2", Err: &jobs.NodeCollectorError{Node: "node-2", Reason: jobs.NodeFailureUnschedulable, Err: errors.New("unschedulable")}},
+		"node-3": {Node: "node-3", Output: nodeInfoOutput("node-3")},
+	}}
+	tests := []struct {
+		name      string
+		opts      []NodeCollectorOption
+		wantErr   string
+		wantNames []string
+	}{
+		{
+			name:      "unschedulable nodes are reported on the node artifacts",
+			wantNames: []string{"node-1", "node-3"},
+		},
+		{
+			name:    "strict",
+			opts:    []NodeCollectorOption{WithNodeCollectorStrict(true)},
+			wantErr: "node node-2: Unschedulable: unschedulable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := []*artifacts.Artifact{
+				{Kind: "Node", Name: "node-1"},
+				{Kind: "Node", Name: "node-2"},
+				{Kind: "Node", Name: "node-3"},
+			}
+			c := &client{}
+			for _, opt := range tt.opts {
+				opt(c)
+			}
+			nodesInfo, err := c.collectNodesInfoDaemonSet(context.Background(), collector, nodes)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			var names []string
+			for _, nodeInfo := range nodesInfo {
+				assert.Equal(t, "NodeInfo", nodeInfo.RawResource["kind"])
+				assert.Equal(t, []string{nodeInfo.Name}, nodeInfo.NodeInfo.Info["nodeName"].Strings())
+				names = append(names, nodeInfo.Name)
+			}
+			assert.Equal(t, tt.wantNames, names)
+			require.NotNil(t, nodes[1].NodeInfoError)
+			assert.Equal(t, string(jobs.NodeFailureUnschedulable), nodes[1].NodeInfoError.Reason)
+			assert.Equal(t, "unschedulable", nodes[1].NodeInfoError.Message)
+		})
+	}
+}